@@ -7,4 +7,6 @@ require (
 	github.com/joho/godotenv v1.5.1
 )
 
-require github.com/lib/pq v1.10.9 // indirect
+require github.com/lib/pq v1.10.9
+
+require github.com/gorilla/websocket v1.5.3
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github/fbdaf/go-postgres/middleware"
 	"github/fbdaf/go-postgres/router"
@@ -13,6 +14,17 @@ func main() {
 	defer db.Close()
 	middleware.AutoMigrate(db)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		err := middleware.ListenForStockChanges(ctx)
+
+		if err != nil && err != context.Canceled {
+			log.Printf("Stock change listener stopped. %v", err)
+		}
+	}()
+
 	r := router.Router()
 	fmt.Println("Starting server on port 8888...")
 	log.Fatal(http.ListenAndServe(":8888", r))
@@ -0,0 +1,389 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github/fbdaf/go-postgres/models"
+	"github/fbdaf/go-postgres/money"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// maxBulkBody caps the size of a bulk upload.
+const maxBulkBody = 64 << 20
+
+// bulkRow is one parsed input record together with the line it came from,
+// so rejections can point back at the source file.
+type bulkRow struct {
+	Line  int
+	Stock models.Stock
+}
+
+// BulkUpsertStocks loads many stocks in one request. The body is CSV with
+// a header row (name, price, company and optionally currency) or
+// newline-delimited JSON objects shaped like models.Stock. Rows are matched
+// to existing stocks by name: new names are inserted and known names are
+// updated. Invalid rows are skipped and reported instead of failing the
+// whole load.
+func BulkUpsertStocks(w http.ResponseWriter, r *http.Request) {
+	format, err := bulkFormat(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxBulkBody)
+
+	var rows []bulkRow
+	var rejected []models.BulkRejection
+
+	switch format {
+	case "csv":
+		rows, rejected, err = parseBulkCSV(body)
+	case "ndjson":
+		rows, rejected, err = parseBulkNDJSON(body)
+	}
+
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		writeError(w, &apiError{Status: http.StatusRequestEntityTooLarge, Message: "Request body is too large", Err: err})
+		return
+	}
+
+	if err != nil {
+		writeError(w, badRequest("Unable to read the request body", err))
+		return
+	}
+
+	rows, rejected = validateBulkRows(rows, rejected)
+
+	result, err := upsertStocks(rows)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result.Rejected = int64(len(rejected))
+	result.Errors = rejected
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func bulkFormat(r *http.Request) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if f != "csv" && f != "ndjson" {
+			return "", badRequest("format must be csv or ndjson", nil)
+		}
+
+		return f, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "text/csv":
+		return "csv", nil
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return "ndjson", nil
+	}
+
+	return "", unsupportedMediaType("Content-Type must be text/csv or application/x-ndjson")
+}
+
+func parseBulkCSV(body io.Reader) ([]bulkRow, []models.BulkRejection, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	columns := map[string]int{}
+
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, required := range []string{"name", "price", "company"} {
+		if _, ok := columns[required]; !ok {
+			return nil, nil, fmt.Errorf("csv header is missing the %q column", required)
+		}
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+
+		if !ok || i >= len(record) {
+			return ""
+		}
+
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []bulkRow
+	var rejected []models.BulkRejection
+
+	for {
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			break
+		}
+
+		var parseErr *csv.ParseError
+
+		if errors.As(err, &parseErr) {
+			rejected = append(rejected, models.BulkRejection{Line: parseErr.Line, Reason: parseErr.Err.Error()})
+			continue
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+
+		row := bulkRow{Line: line}
+		row.Stock.Name = field(record, "name")
+		row.Stock.Company = field(record, "company")
+		row.Stock.Currency = field(record, "currency")
+
+		row.Stock.Price, err = money.Parse(field(record, "price"))
+
+		if err != nil {
+			rejected = append(rejected, models.BulkRejection{Line: line, Name: row.Stock.Name, Reason: "invalid price: " + err.Error()})
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, rejected, nil
+}
+
+func parseBulkNDJSON(body io.Reader) ([]bulkRow, []models.BulkRejection, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var rows []bulkRow
+	var rejected []models.BulkRejection
+
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := bytes.TrimSpace(scanner.Bytes())
+
+		if len(text) == 0 {
+			continue
+		}
+
+		var raw struct {
+			models.Stock
+			Price *money.Amount `json:"price"`
+		}
+
+		err := json.Unmarshal(text, &raw)
+
+		if err != nil {
+			rejected = append(rejected, models.BulkRejection{Line: line, Reason: "invalid JSON: " + err.Error()})
+			continue
+		}
+
+		if raw.Price == nil {
+			rejected = append(rejected, models.BulkRejection{Line: line, Name: raw.Name, Reason: "price is required"})
+			continue
+		}
+
+		raw.Stock.Price = *raw.Price
+
+		rows = append(rows, bulkRow{Line: line, Stock: raw.Stock})
+	}
+
+	return rows, rejected, scanner.Err()
+}
+
+// validateBulkRows rejects rows with missing fields, a bad currency or
+// values the columns cannot hold. When
+// a name appears more than once the last row wins, because ON CONFLICT
+// cannot touch the same stock twice in one statement.
+func validateBulkRows(rows []bulkRow, rejected []models.BulkRejection) ([]bulkRow, []models.BulkRejection) {
+	valid := make([]bulkRow, 0, len(rows))
+	byName := map[string]int{}
+
+	for _, row := range rows {
+		reject := func(reason string) {
+			rejected = append(rejected, models.BulkRejection{Line: row.Line, Name: row.Stock.Name, Reason: reason})
+		}
+
+		if row.Stock.Name == "" {
+			reject("name is required")
+			continue
+		}
+
+		if row.Stock.Company == "" {
+			reject("company is required")
+			continue
+		}
+
+		if reason := stockProblem(row.Stock); reason != "" {
+			reject(reason)
+			continue
+		}
+
+		currency, err := money.NormalizeCurrency(row.Stock.Currency)
+
+		if err != nil {
+			reject(err.Error())
+			continue
+		}
+
+		row.Stock.Currency = currency
+
+		if i, ok := byName[row.Stock.Name]; ok {
+			prev := valid[i]
+			rejected = append(rejected, models.BulkRejection{
+				Line:   prev.Line,
+				Name:   prev.Stock.Name,
+				Reason: fmt.Sprintf("superseded by line %d", row.Line),
+			})
+			valid[i] = row
+			continue
+		}
+
+		byName[row.Stock.Name] = len(valid)
+		valid = append(valid, row)
+	}
+
+	sort.SliceStable(rejected, func(i, j int) bool {
+		return rejected[i].Line < rejected[j].Line
+	})
+
+	return valid, rejected
+}
+
+// upsertStocks copies rows into a temporary staging table and merges them
+// into stocks in a single statement, all in one transaction on one
+// connection. Price history is written for new stocks and for stocks whose
+// price or currency changed; the join against stocks in the history CTE
+// still sees the values from before the merge.
+func upsertStocks(rows []bulkRow) (models.BulkResult, error) {
+	var result models.BulkResult
+
+	if len(rows) == 0 {
+		return result, nil
+	}
+
+	db, err := openConnection()
+
+	if err != nil {
+		return result, err
+	}
+
+	defer db.Close()
+
+	tx, err := db.Begin()
+
+	if err != nil {
+		return result, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`CREATE TEMP TABLE stocks_staging (
+		name VARCHAR(255) NOT NULL,
+		price NUMERIC(18, 4) NOT NULL,
+		currency CHAR(3) NOT NULL,
+		company VARCHAR(255) NOT NULL
+	) ON COMMIT DROP`)
+
+	if err != nil {
+		return result, fmt.Errorf("unable to create staging table: %w", err)
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("stocks_staging", "name", "price", "currency", "company"))
+
+	if err != nil {
+		return result, fmt.Errorf("unable to start COPY: %w", err)
+	}
+
+	for _, row := range rows {
+		_, err = stmt.Exec(row.Stock.Name, row.Stock.Price.String(), row.Stock.Currency, row.Stock.Company)
+
+		if err != nil {
+			stmt.Close()
+			return result, fmt.Errorf("unable to copy row %d: %w", row.Line, err)
+		}
+	}
+
+	_, err = stmt.Exec()
+
+	if err != nil {
+		stmt.Close()
+		return result, fmt.Errorf("unable to finish COPY: %w", err)
+	}
+
+	err = stmt.Close()
+
+	if err != nil {
+		return result, fmt.Errorf("unable to finish COPY: %w", err)
+	}
+
+	mergeSQL := `WITH merged AS (
+			INSERT INTO stocks (name, price, currency, company)
+			SELECT name, price, currency, company FROM stocks_staging
+			ON CONFLICT (name) DO UPDATE
+				SET price = EXCLUDED.price, currency = EXCLUDED.currency, company = EXCLUDED.company
+				WHERE (stocks.price, stocks.currency, stocks.company)
+					IS DISTINCT FROM (EXCLUDED.price, EXCLUDED.currency, EXCLUDED.company)
+			RETURNING stockid, price, currency, (xmax = 0) AS inserted
+		), history AS (
+			INSERT INTO stock_prices (stockid, price, currency)
+			SELECT m.stockid, m.price, m.currency
+			FROM merged m
+			LEFT JOIN stocks s ON s.stockid = m.stockid
+			WHERE m.inserted OR s.price <> m.price OR s.currency <> m.currency
+		)
+		SELECT
+			COUNT(*) FILTER (WHERE inserted),
+			COUNT(*) FILTER (WHERE NOT inserted)
+		FROM merged`
+
+	err = tx.QueryRow(mergeSQL).Scan(&result.Inserted, &result.Updated)
+
+	if err != nil {
+		return result, fmt.Errorf("unable to merge staged stocks: %w", err)
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return result, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	result.Unchanged = int64(len(rows)) - result.Inserted - result.Updated
+
+	fmt.Printf("Bulk upsert: %d inserted, %d updated, %d unchanged\n", result.Inserted, result.Updated, result.Unchanged)
+
+	return result, nil
+}
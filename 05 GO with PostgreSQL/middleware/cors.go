@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSConfig controls which browser origins may call the API.
+type CORSConfig struct {
+	// AllowedOrigins lists origins such as "https://app.example.com".
+	// "*" allows any origin.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts are allowed to read.
+	ExposedHeaders   []string
+	AllowCredentials bool
+	// MaxAge is how long, in seconds, browsers may cache a preflight answer.
+	MaxAge int
+}
+
+// CORSConfigFromEnv reads the CORS_* environment variables. Lists are
+// comma-separated. With nothing set, no cross-origin requests are allowed.
+func CORSConfigFromEnv() CORSConfig {
+	cfg := CORSConfig{
+		AllowedOrigins: splitEnv("CORS_ALLOWED_ORIGINS"),
+		AllowedMethods: splitEnv("CORS_ALLOWED_METHODS"),
+		AllowedHeaders: splitEnv("CORS_ALLOWED_HEADERS"),
+		ExposedHeaders: []string{"Deprecation", "Link"},
+		MaxAge:         600,
+	}
+
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{"GET", "POST", "PUT", "DELETE"}
+	}
+
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = []string{"Content-Type"}
+	}
+
+	if v, err := strconv.ParseBool(os.Getenv("CORS_ALLOW_CREDENTIALS")); err == nil {
+		cfg.AllowCredentials = v
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("CORS_MAX_AGE")); err == nil {
+		cfg.MaxAge = v
+	}
+
+	return cfg
+}
+
+func splitEnv(key string) []string {
+	var out []string
+
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c CORSConfig) allowsMethod(method string) bool {
+	for _, m := range c.AllowedMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c CORSConfig) wildcard() bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CORS answers every OPTIONS request itself, so handlers never see them,
+// and adds Access-Control-* headers for allowed origins. Routes must list
+// "OPTIONS" in their methods for mux to run the middleware on preflights.
+func CORS(cfg CORSConfig) mux.MiddlewareFunc {
+	// Unless every response is the same "*", the headers depend on the
+	// origin, and caches must not hand one origin's response to another,
+	// including a response without CORS headers to an allowed origin.
+	varies := !cfg.wildcard() || cfg.AllowCredentials
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			allowed := origin != "" && cfg.allowsOrigin(origin)
+
+			if varies {
+				w.Header().Add("Vary", "Origin")
+			}
+
+			if allowed {
+				h := w.Header()
+
+				// The wildcard cannot be combined with credentials, so in
+				// that case the origin is echoed back instead.
+				if cfg.wildcard() && !cfg.AllowCredentials {
+					h.Set("Access-Control-Allow-Origin", "*")
+				} else {
+					h.Set("Access-Control-Allow-Origin", origin)
+				}
+
+				if cfg.AllowCredentials {
+					h.Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+
+			if r.Method != http.MethodOptions {
+				if allowed && len(cfg.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			requested := r.Header.Get("Access-Control-Request-Method")
+
+			if allowed && requested != "" && cfg.allowsMethod(requested) {
+				h := w.Header()
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+				h.Set("Access-Control-Allow-Methods", strings.ToUpper(requested))
+				h.Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+				h.Set("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// checkWebSocketOrigin accepts same-origin upgrades and origins allowed by
+// c.
+func (c CORSConfig) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	return c.allowsOrigin(origin)
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/lib/pq"
+)
+
+var ErrStockNotFound = errors.New("stock not found")
+
+// apiError carries the HTTP status a handler should answer with, the message
+// shown to the client and the underlying cause that only goes to the log.
+type apiError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *apiError) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.Err
+}
+
+func badRequest(msg string, err error) error {
+	return &apiError{Status: http.StatusBadRequest, Message: msg, Err: err}
+}
+
+func unsupportedMediaType(msg string) error {
+	return &apiError{Status: http.StatusUnsupportedMediaType, Message: msg}
+}
+
+// toAPIError classifies errors coming back from the database helpers.
+// Integrity constraint violations (SQLSTATE class 23) are the client's fault
+// and map to 409, everything else is a 500.
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	if errors.Is(err, ErrStockNotFound) {
+		return &apiError{Status: http.StatusNotFound, Message: "Stock not found", Err: err}
+	}
+
+	if errors.Is(err, ErrNoPrices) {
+		return &apiError{Status: http.StatusNotFound, Message: "No prices recorded in range", Err: err}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Class() == "23" {
+		return &apiError{Status: http.StatusConflict, Message: "Stock conflicts with existing data", Err: err}
+	}
+
+	return &apiError{Status: http.StatusInternalServerError, Message: "Internal server error", Err: err}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Unable to encode the response. %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := toAPIError(err)
+
+	log.Printf("%d %v", apiErr.Status, apiErr)
+
+	writeJSON(w, apiErr.Status, response{Message: apiErr.Message})
+}
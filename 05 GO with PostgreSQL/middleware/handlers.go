@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github/fbdaf/go-postgres/models"
+	"github/fbdaf/go-postgres/money"
+	"github/fbdaf/go-postgres/stream"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"unicode/utf8"
 
 	_ "github.com/lib/pq"
 
@@ -22,35 +25,52 @@ type response struct {
 }
 
 func CreateConnection() *sql.DB {
-	err := godotenv.Load(".env")
+	db, err := openConnection()
 
 	if err != nil {
-		log.Fatalf("Error loading .env file")
+		log.Fatalf("Unable to connect to database. %v", err)
+	}
+
+	fmt.Println("Successfully connected to database")
+
+	return db
+}
+
+// openConnection connects to POSTGRES_URL. The .env file is only read when
+// the variable is not already set, so tests and containers can configure
+// the database through the environment alone.
+func openConnection() (*sql.DB, error) {
+	if os.Getenv("POSTGRES_URL") == "" {
+		err := godotenv.Load(".env")
+
+		if err != nil {
+			return nil, fmt.Errorf("error loading .env file: %w", err)
+		}
 	}
 
 	db, err := sql.Open("postgres", os.Getenv("POSTGRES_URL"))
 
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	err = db.Ping()
 
 	if err != nil {
-		panic(err)
+		db.Close()
+		return nil, err
 	}
 
-	fmt.Println("Successfully connected to database")
-
-	return db
+	return db, nil
 }
 
 func AutoMigrate(db *sql.DB) {
 	createTableSQL := `CREATE TABLE IF NOT EXISTS stocks (
 		stockid SERIAL PRIMARY KEY,
 		name VARCHAR(255) NOT NULL,
-		price INT NOT NULL,
-		company VARCHAR(255) NOT NULL
+		price NUMERIC(18, 4) NOT NULL,
+		company VARCHAR(255) NOT NULL,
+		currency CHAR(3) NOT NULL DEFAULT 'USD'
 	)`
 
 	_, err := db.Exec(createTableSQL)
@@ -59,63 +79,202 @@ func AutoMigrate(db *sql.DB) {
 		log.Fatalf("Unable to create table. %v", err)
 	}
 
+	createPricesTableSQL := `CREATE TABLE IF NOT EXISTS stock_prices (
+		id BIGSERIAL PRIMARY KEY,
+		stockid INT NOT NULL REFERENCES stocks (stockid) ON DELETE CASCADE,
+		price NUMERIC(18, 4) NOT NULL,
+		currency CHAR(3) NOT NULL DEFAULT 'USD',
+		recorded_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`
+
+	_, err = db.Exec(createPricesTableSQL)
+
+	if err != nil {
+		log.Fatalf("Unable to create table. %v", err)
+	}
+
+	migrateMoney(db)
+
+	// Bulk upserts match existing stocks by name. Older tables may hold
+	// duplicate names, in which case the index is skipped and bulk loads
+	// fail until the duplicates are cleaned up.
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS stocks_name_key ON stocks (name)`)
+
+	if err != nil {
+		log.Printf("Unable to create unique index on stocks.name, bulk upsert is disabled. %v", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS stock_prices_stockid_recorded_at_idx ON stock_prices (stockid, recorded_at)`)
+
+	if err != nil {
+		log.Fatalf("Unable to create index. %v", err)
+	}
+
+	// Stocks created before price history existed get their current price
+	// as the first point so every stock has at least one entry.
+	_, err = db.Exec(`INSERT INTO stock_prices (stockid, price, currency)
+		SELECT s.stockid, s.price, s.currency FROM stocks s
+		WHERE NOT EXISTS (SELECT 1 FROM stock_prices p WHERE p.stockid = s.stockid)`)
+
+	if err != nil {
+		log.Fatalf("Unable to backfill price history. %v", err)
+	}
+
+	createNotifyTrigger(db)
+
 	fmt.Println("Table checked or created successfully")
 }
 
+// createNotifyTrigger makes every committed change to stocks send a JSON
+// payload on the stock_changes channel. Prices are sent as text so they
+// keep their exact decimal value.
+func createNotifyTrigger(db *sql.DB) {
+	createFunctionSQL := `CREATE OR REPLACE FUNCTION notify_stock_change() RETURNS trigger AS $$
+	DECLARE
+		changed stocks;
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			changed := OLD;
+		ELSE
+			changed := NEW;
+		END IF;
+
+		PERFORM pg_notify('` + stream.Channel + `', json_build_object(
+			'op', lower(TG_OP),
+			'stock', json_build_object(
+				'stockid', changed.stockid,
+				'name', changed.name,
+				'price', changed.price::text,
+				'currency', changed.currency,
+				'company', changed.company
+			)
+		)::text);
+
+		RETURN NULL;
+	END;
+	$$ LANGUAGE plpgsql`
+
+	_, err := db.Exec(createFunctionSQL)
+
+	if err != nil {
+		log.Fatalf("Unable to create notify function. %v", err)
+	}
+
+	_, err = db.Exec(`DROP TRIGGER IF EXISTS stocks_notify ON stocks`)
+
+	if err != nil {
+		log.Fatalf("Unable to drop notify trigger. %v", err)
+	}
+
+	_, err = db.Exec(`CREATE TRIGGER stocks_notify
+		AFTER INSERT OR UPDATE OR DELETE ON stocks
+		FOR EACH ROW EXECUTE FUNCTION notify_stock_change()`)
+
+	if err != nil {
+		log.Fatalf("Unable to create notify trigger. %v", err)
+	}
+}
+
+// migrateMoney converts tables created with integer prices to NUMERIC and
+// adds the currency column. Existing prices are whole units of USD.
+func migrateMoney(db *sql.DB) {
+	for _, table := range []string{"stocks", "stock_prices"} {
+		_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD'`, table))
+
+		if err != nil {
+			log.Fatalf("Unable to add currency column to %s. %v", table, err)
+		}
+
+		var dataType string
+
+		err = db.QueryRow(`SELECT data_type FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = $1 AND column_name = 'price'`, table).Scan(&dataType)
+
+		if err != nil {
+			log.Fatalf("Unable to inspect %s.price. %v", table, err)
+		}
+
+		if dataType == "numeric" {
+			continue
+		}
+
+		_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN price TYPE NUMERIC(18, 4) USING price::numeric`, table))
+
+		if err != nil {
+			log.Fatalf("Unable to migrate %s.price to NUMERIC. %v", table, err)
+		}
+
+		fmt.Printf("Migrated %s.price from %s to NUMERIC\n", table, dataType)
+	}
+}
+
 func CreateStock(w http.ResponseWriter, r *http.Request) {
 	var stock models.Stock
 
 	err := json.NewDecoder(r.Body).Decode(&stock)
 
 	if err != nil {
-		log.Fatalf("Unable to decode the request body. %v", err)
+		writeError(w, badRequest("Unable to decode the request body", err))
+		return
+	}
+
+	err = normalizeStock(&stock)
+
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
-	insertID := insertStock(stock)
+	insertID, err := insertStock(stock)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
 	res := response{
 		ID:      insertID,
 		Message: "Stock created successfully",
 	}
 
-	json.NewEncoder(w).Encode(res)
+	writeJSON(w, http.StatusOK, res)
 }
 
 func GetStock(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-
-	id, err := strconv.Atoi(params["id"])
+	id, err := stockID(r)
 
 	if err != nil {
-		log.Fatalf("Unable to convert the string into int. %v", err)
+		writeError(w, err)
+		return
 	}
 
-	stock, err := getStock(int64(id))
+	stock, err := getStock(id)
 
 	if err != nil {
-		log.Fatalf("Unable to get stock. %v", err)
+		writeError(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(stock)
+	writeJSON(w, http.StatusOK, stock)
 }
 
 func GetAllStock(w http.ResponseWriter, r *http.Request) {
 	stocks, err := getAllStock()
 
 	if err != nil {
-		log.Fatalf("Unable to get all stock. %v", err)
+		writeError(w, err)
+		return
 	}
 
-	json.NewEncoder(w).Encode(stocks)
+	writeJSON(w, http.StatusOK, stocks)
 }
 
 func UpdateStock(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
-
-	id, err := strconv.Atoi(params["id"])
+	id, err := stockID(r)
 
 	if err != nil {
-		log.Fatalf("Unable to convert the string into int. %v", err)
+		writeError(w, err)
+		return
 	}
 
 	var stock models.Stock
@@ -123,99 +282,213 @@ func UpdateStock(w http.ResponseWriter, r *http.Request) {
 	err = json.NewDecoder(r.Body).Decode(&stock)
 
 	if err != nil {
-		log.Fatalf("Unable to decode the request body. %v", err)
+		writeError(w, badRequest("Unable to decode the request body", err))
+		return
+	}
+
+	err = normalizeStock(&stock)
+
+	if err != nil {
+		writeError(w, err)
+		return
 	}
 
-	updatedRows := updateStock(int64(id), stock)
+	updatedRows, err := updateStock(id, stock)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if updatedRows == 0 {
+		writeError(w, ErrStockNotFound)
+		return
+	}
 
 	msg := fmt.Sprintf("Stock updated successfully. Total rows/record affected %v", updatedRows)
 
 	res := response{
-		ID:      int64(id),
+		ID:      id,
 		Message: msg,
 	}
 
-	json.NewEncoder(w).Encode(res)
+	writeJSON(w, http.StatusOK, res)
 }
 
 func DeleteStock(w http.ResponseWriter, r *http.Request) {
-	params := mux.Vars(r)
+	id, err := stockID(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
 
-	id, err := strconv.Atoi(params["id"])
+	deletedRows, err := deleteStock(id)
 
 	if err != nil {
-		log.Fatalf("Unable to convert the string into int. %v", err)
+		writeError(w, err)
+		return
 	}
 
-	deletedRows := deleteStock(int64(id))
+	if deletedRows == 0 {
+		writeError(w, ErrStockNotFound)
+		return
+	}
 
 	msg := fmt.Sprintf("Stock deleted successfully. Total rows/record affected %v", deletedRows)
 
 	res := response{
-		ID:      int64(id),
+		ID:      id,
 		Message: msg,
 	}
 
-	json.NewEncoder(w).Encode(res)
+	writeJSON(w, http.StatusOK, res)
+}
+
+func stockID(r *http.Request) (int64, error) {
+	params := mux.Vars(r)
+
+	id, err := strconv.ParseInt(params["id"], 10, 64)
+
+	if err != nil {
+		return 0, badRequest("Stock id must be an integer", err)
+	}
+
+	return id, nil
+}
+
+// maxTextLength is the length of the VARCHAR(255) name and company columns,
+// in characters.
+const maxTextLength = 255
+
+// NUMERIC(18, 4) holds at most 14 integer digits, fewer than an Amount.
+// In a bulk upload a price beyond these bounds would make COPY fail for the
+// whole batch.
+var (
+	maxPrice = money.FromInt(1e14)
+	minPrice = money.FromInt(-1e14)
+)
+
+// stockProblem returns why the stocks table cannot hold stock, or "" if it
+// can. Checking up front turns what would be a database error into a 400.
+func stockProblem(stock models.Stock) string {
+	if utf8.RuneCountInString(stock.Name) > maxTextLength {
+		return fmt.Sprintf("name is longer than %d characters", maxTextLength)
+	}
+
+	if utf8.RuneCountInString(stock.Company) > maxTextLength {
+		return fmt.Sprintf("company is longer than %d characters", maxTextLength)
+	}
+
+	if stock.Price.Cmp(maxPrice) >= 0 || stock.Price.Cmp(minPrice) <= 0 {
+		return "price must have at most 14 digits before the decimal point"
+	}
+
+	return ""
 }
 
-func insertStock(stock models.Stock) int64 {
-	db := CreateConnection()
+func normalizeStock(stock *models.Stock) error {
+	if reason := stockProblem(*stock); reason != "" {
+		return badRequest(reason, nil)
+	}
+
+	currency, err := money.NormalizeCurrency(stock.Currency)
+
+	if err != nil {
+		return badRequest(err.Error(), err)
+	}
+
+	stock.Currency = currency
+
+	return nil
+}
+
+func insertStock(stock models.Stock) (int64, error) {
+	db, err := openConnection()
+
+	if err != nil {
+		return 0, err
+	}
 
 	defer db.Close()
 
-	sqlStatement := `INSERT INTO stocks (name, price, company) VALUES ($1, $2, $3) RETURNING stockid`
+	tx, err := db.Begin()
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	sqlStatement := `INSERT INTO stocks (name, price, currency, company) VALUES ($1, $2, $3, $4) RETURNING stockid`
 
 	var stockID int64
 
-	err := db.QueryRow(sqlStatement, stock.Name, stock.Price, stock.Company).Scan(&stockID)
+	err = tx.QueryRow(sqlStatement, stock.Name, stock.Price, stock.Currency, stock.Company).Scan(&stockID)
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to execute the query: %w", err)
+	}
+
+	err = recordPrice(tx, stockID, stock.Price, stock.Currency)
 
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+		return 0, err
 	}
 
-	fmt.Printf("Inserted a single record %v", stockID)
+	err = tx.Commit()
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to commit transaction: %w", err)
+	}
 
-	return stockID
+	fmt.Printf("Inserted a single record %v\n", stockID)
+
+	return stockID, nil
 }
 
 func getStock(id int64) (models.Stock, error) {
-	db := CreateConnection()
+	db, err := openConnection()
+
+	if err != nil {
+		return models.Stock{}, err
+	}
 
 	defer db.Close()
 
 	var stock models.Stock
 
-	sqlStatement := `SELECT * FROM stocks WHERE stockid=$1`
+	sqlStatement := `SELECT stockid, name, price, currency, company FROM stocks WHERE stockid=$1`
 
-	err := db.QueryRow(sqlStatement, id).Scan(&stock.StockID, &stock.Name, &stock.Price, &stock.Company)
+	err = db.QueryRow(sqlStatement, id).Scan(&stock.StockID, &stock.Name, &stock.Price, &stock.Currency, &stock.Company)
 
 	switch err {
 	case sql.ErrNoRows:
-		fmt.Printf("No rows were returned!")
-		return stock, nil
+		return stock, ErrStockNotFound
 	case nil:
 		return stock, nil
 	default:
-		log.Fatalf("Unable to scan the row. %v", err)
+		return stock, fmt.Errorf("unable to scan the row: %w", err)
 	}
-
-	return stock, err
 }
 
 func getAllStock() ([]models.Stock, error) {
-	db := CreateConnection()
+	db, err := openConnection()
+
+	if err != nil {
+		return nil, err
+	}
 
 	defer db.Close()
 
 	var stocks []models.Stock
 
-	sqlStatement := `SELECT * FROM stocks`
+	sqlStatement := `SELECT stockid, name, price, currency, company FROM stocks ORDER BY stockid`
 
 	rows, err := db.Query(sqlStatement)
 
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+		return nil, fmt.Errorf("unable to execute the query: %w", err)
 	}
 
 	defer rows.Close()
@@ -223,44 +496,87 @@ func getAllStock() ([]models.Stock, error) {
 	for rows.Next() {
 		var stock models.Stock
 
-		err = rows.Scan(&stock.StockID, &stock.Name, &stock.Price, &stock.Company)
+		err = rows.Scan(&stock.StockID, &stock.Name, &stock.Price, &stock.Currency, &stock.Company)
 
 		if err != nil {
-			log.Fatalf("Unable to scan the row. %v", err)
+			return nil, fmt.Errorf("unable to scan the row: %w", err)
 		}
 
 		stocks = append(stocks, stock)
 	}
 
-	return stocks, nil
+	return stocks, rows.Err()
 }
 
-func updateStock(id int64, stock models.Stock) int64 {
-	db := CreateConnection()
+func updateStock(id int64, stock models.Stock) (int64, error) {
+	db, err := openConnection()
+
+	if err != nil {
+		return 0, err
+	}
 
 	defer db.Close()
 
-	sqlStatement := `UPDATE stocks SET name=$2, price=$3, company=$4 WHERE stockid=$1`
+	tx, err := db.Begin()
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+
+	defer tx.Rollback()
+
+	var oldPrice money.Amount
+	var oldCurrency string
+
+	err = tx.QueryRow(`SELECT price, currency FROM stocks WHERE stockid=$1 FOR UPDATE`, id).Scan(&oldPrice, &oldCurrency)
 
-	res, err := db.Exec(sqlStatement, id, stock.Name, stock.Price, stock.Company)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
 
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+		return 0, fmt.Errorf("unable to scan the row: %w", err)
+	}
+
+	sqlStatement := `UPDATE stocks SET name=$2, price=$3, currency=$4, company=$5 WHERE stockid=$1`
+
+	res, err := tx.Exec(sqlStatement, id, stock.Name, stock.Price, stock.Currency, stock.Company)
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to execute the query: %w", err)
 	}
 
 	rowsAffected, err := res.RowsAffected()
 
 	if err != nil {
-		log.Fatalf("Error while checking the affected rows. %v", err)
+		return 0, fmt.Errorf("error while checking the affected rows: %w", err)
 	}
 
-	fmt.Printf("Total rows/record affected %v", rowsAffected)
+	if oldPrice.Cmp(stock.Price) != 0 || oldCurrency != stock.Currency {
+		err = recordPrice(tx, id, stock.Price, stock.Currency)
 
-	return rowsAffected
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return 0, fmt.Errorf("unable to commit transaction: %w", err)
+	}
+
+	fmt.Printf("Total rows/record affected %v\n", rowsAffected)
+
+	return rowsAffected, nil
 }
 
-func deleteStock(id int64) int64 {
-	db := CreateConnection()
+func deleteStock(id int64) (int64, error) {
+	db, err := openConnection()
+
+	if err != nil {
+		return 0, err
+	}
 
 	defer db.Close()
 
@@ -269,16 +585,16 @@ func deleteStock(id int64) int64 {
 	res, err := db.Exec(sqlStatement, id)
 
 	if err != nil {
-		log.Fatalf("Unable to execute the query. %v", err)
+		return 0, fmt.Errorf("unable to execute the query: %w", err)
 	}
 
 	rowsAffected, err := res.RowsAffected()
 
 	if err != nil {
-		log.Fatalf("Error while checking the affected rows. %v", err)
+		return 0, fmt.Errorf("error while checking the affected rows: %w", err)
 	}
 
-	fmt.Printf("Total rows/record affected %v", rowsAffected)
+	fmt.Printf("Total rows/record affected %v\n", rowsAffected)
 
-	return rowsAffected
+	return rowsAffected, nil
 }
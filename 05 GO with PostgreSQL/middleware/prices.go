@@ -0,0 +1,361 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github/fbdaf/go-postgres/models"
+	"github/fbdaf/go-postgres/money"
+	"net/http"
+	"time"
+)
+
+var ErrNoPrices = errors.New("no prices recorded in range")
+
+// buckets lists the date_trunc units accepted by the OHLC endpoint.
+var buckets = map[string]bool{
+	"minute": true,
+	"hour":   true,
+	"day":    true,
+}
+
+// timeRange is an optional [from, to) window taken from the query string.
+// A zero bound means the range is open on that side.
+type timeRange struct {
+	From sql.NullTime
+	To   sql.NullTime
+}
+
+func GetStockPrices(w http.ResponseWriter, r *http.Request) {
+	id, err := stockID(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tr, err := parseTimeRange(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	prices, err := getPriceHistory(id, tr)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, prices)
+}
+
+func GetStockOHLC(w http.ResponseWriter, r *http.Request) {
+	id, err := stockID(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tr, err := parseTimeRange(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+
+	if bucket == "" {
+		bucket = "day"
+	}
+
+	if !buckets[bucket] {
+		writeError(w, badRequest("bucket must be one of minute, hour or day", nil))
+		return
+	}
+
+	candles, err := getOHLC(id, bucket, tr)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, candles)
+}
+
+func GetStockChange(w http.ResponseWriter, r *http.Request) {
+	id, err := stockID(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	tr, err := parseTimeRange(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	change, err := getPriceChange(id, tr)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, change)
+}
+
+func parseTimeRange(r *http.Request) (timeRange, error) {
+	var tr timeRange
+
+	query := r.URL.Query()
+
+	if v := query.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+
+		if err != nil {
+			return tr, badRequest("from must be an RFC 3339 timestamp", err)
+		}
+
+		tr.From = sql.NullTime{Time: t, Valid: true}
+	}
+
+	if v := query.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+
+		if err != nil {
+			return tr, badRequest("to must be an RFC 3339 timestamp", err)
+		}
+
+		tr.To = sql.NullTime{Time: t, Valid: true}
+	}
+
+	if tr.From.Valid && tr.To.Valid && !tr.From.Time.Before(tr.To.Time) {
+		return tr, badRequest("from must be before to", nil)
+	}
+
+	return tr, nil
+}
+
+// recordPrice appends a point to the price history. It runs inside the
+// transaction that changes stocks.price so the two never disagree.
+func recordPrice(tx *sql.Tx, id int64, price money.Amount, currency string) error {
+	_, err := tx.Exec(`INSERT INTO stock_prices (stockid, price, currency) VALUES ($1, $2, $3)`, id, price, currency)
+
+	if err != nil {
+		return fmt.Errorf("unable to record price: %w", err)
+	}
+
+	return nil
+}
+
+func stockExists(db *sql.DB, id int64) error {
+	_, err := stockCurrency(db, id)
+
+	return err
+}
+
+func stockCurrency(db *sql.DB, id int64) (string, error) {
+	var currency string
+
+	err := db.QueryRow(`SELECT currency FROM stocks WHERE stockid=$1`, id).Scan(&currency)
+
+	if err == sql.ErrNoRows {
+		return "", ErrStockNotFound
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("unable to scan the row: %w", err)
+	}
+
+	return currency, nil
+}
+
+func getPriceHistory(id int64, tr timeRange) ([]models.PricePoint, error) {
+	db, err := openConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	err = stockExists(db, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sqlStatement := `SELECT price, currency, recorded_at FROM stock_prices
+		WHERE stockid=$1
+		AND ($2::timestamptz IS NULL OR recorded_at >= $2)
+		AND ($3::timestamptz IS NULL OR recorded_at < $3)
+		ORDER BY recorded_at, id`
+
+	rows, err := db.Query(sqlStatement, id, tr.From, tr.To)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute the query: %w", err)
+	}
+
+	defer rows.Close()
+
+	prices := []models.PricePoint{}
+
+	for rows.Next() {
+		var p models.PricePoint
+
+		err = rows.Scan(&p.Price, &p.Currency, &p.RecordedAt)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan the row: %w", err)
+		}
+
+		prices = append(prices, p)
+	}
+
+	return prices, rows.Err()
+}
+
+func getOHLC(id int64, bucket string, tr timeRange) ([]models.OHLC, error) {
+	db, err := openConnection()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer db.Close()
+
+	err = stockExists(db, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Buckets are cut in UTC. date_trunc on a timestamptz would cut them in
+	// the session time zone, so the same request could group differently
+	// depending on the server's settings.
+	sqlStatement := `SELECT date_trunc($2, recorded_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket,
+			currency,
+			(array_agg(price ORDER BY recorded_at, id))[1],
+			MAX(price),
+			MIN(price),
+			(array_agg(price ORDER BY recorded_at DESC, id DESC))[1],
+			COUNT(*)
+		FROM stock_prices
+		WHERE stockid=$1
+		AND ($3::timestamptz IS NULL OR recorded_at >= $3)
+		AND ($4::timestamptz IS NULL OR recorded_at < $4)
+		GROUP BY bucket, currency
+		ORDER BY bucket, currency`
+
+	rows, err := db.Query(sqlStatement, id, bucket, tr.From, tr.To)
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to execute the query: %w", err)
+	}
+
+	defer rows.Close()
+
+	candles := []models.OHLC{}
+
+	for rows.Next() {
+		var c models.OHLC
+
+		err = rows.Scan(&c.Bucket, &c.Currency, &c.Open, &c.High, &c.Low, &c.Close, &c.Count)
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to scan the row: %w", err)
+		}
+
+		candles = append(candles, c)
+	}
+
+	return candles, rows.Err()
+}
+
+// getPriceChange compares the first and last price in the range. Only
+// points in the stock's current currency are considered, since amounts in
+// different currencies cannot be compared.
+func getPriceChange(id int64, tr timeRange) (models.PriceChange, error) {
+	change := models.PriceChange{StockID: id}
+
+	db, err := openConnection()
+
+	if err != nil {
+		return change, err
+	}
+
+	defer db.Close()
+
+	change.Currency, err = stockCurrency(db, id)
+
+	if err != nil {
+		return change, err
+	}
+
+	sqlStatement := `SELECT
+			(array_agg(price ORDER BY recorded_at, id))[1],
+			MIN(recorded_at),
+			(array_agg(price ORDER BY recorded_at DESC, id DESC))[1],
+			MAX(recorded_at)
+		FROM stock_prices
+		WHERE stockid=$1 AND currency=$2
+		AND ($3::timestamptz IS NULL OR recorded_at >= $3)
+		AND ($4::timestamptz IS NULL OR recorded_at < $4)`
+
+	var from, to sql.NullTime
+
+	err = db.QueryRow(sqlStatement, id, change.Currency, tr.From, tr.To).Scan(&change.FirstPrice, &from, &change.LastPrice, &to)
+
+	if err != nil {
+		return change, fmt.Errorf("unable to scan the row: %w", err)
+	}
+
+	if !from.Valid {
+		return change, ErrNoPrices
+	}
+
+	change.From = from.Time
+	change.To = to.Time
+
+	change.Change, err = change.LastPrice.Sub(change.FirstPrice)
+
+	if err != nil {
+		return change, err
+	}
+
+	if !change.FirstPrice.IsZero() {
+		change.ChangePercent, err = percentOf(change.Change, change.FirstPrice)
+
+		if err != nil {
+			return change, err
+		}
+	}
+
+	return change, nil
+}
+
+// percentOf returns part/whole*100 rounded half-even to two places.
+func percentOf(part, whole money.Amount) (money.Amount, error) {
+	scaled, err := part.MulInt(100)
+
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	pct, err := scaled.Quo(whole)
+
+	if err != nil {
+		return money.Amount{}, err
+	}
+
+	return pct.Round(2), nil
+}
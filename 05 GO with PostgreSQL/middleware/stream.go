@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"github/fbdaf/go-postgres/stream"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamBuffer     = 64
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = streamPongWait * 9 / 10
+)
+
+// StockHub receives every change published by ListenForStockChanges and
+// hands it to the connected /api/stream clients.
+var StockHub = stream.NewHub(streamBuffer)
+
+// ListenForStockChanges forwards Postgres notifications to StockHub until
+// ctx is cancelled.
+func ListenForStockChanges(ctx context.Context) error {
+	return stream.Listen(ctx, os.Getenv("POSTGRES_URL"), StockHub)
+}
+
+// StreamStocks returns a handler that upgrades the request to a WebSocket
+// and pushes a JSON event for every insert, update or delete of a stock.
+// Clients can narrow the stream with stockid (repeated or comma-separated)
+// and company (repeated) parameters. Browsers do not send preflight
+// requests for WebSockets, so the upgrade itself checks the origin against
+// cors.
+//
+// Clients that cannot keep up with streamBuffer pending events are
+// disconnected with a policy-violation close frame rather than slowing
+// down everyone else.
+func StreamStocks(cors CORSConfig) http.HandlerFunc {
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     cors.checkWebSocketOrigin,
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		streamStocks(upgrader, w, r)
+	}
+}
+
+func streamStocks(upgrader *websocket.Upgrader, w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStreamFilter(r)
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Subscribe before the handshake completes so that no event committed
+	// after the client sees the upgrade response is missed.
+	sub := StockHub.Subscribe(filter)
+	defer StockHub.Unsubscribe(sub)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		log.Printf("Unable to upgrade the connection. %v", err)
+		return
+	}
+
+	defer conn.Close()
+
+	gone := make(chan struct{})
+	go readUntilClosed(conn, gone)
+
+	ping := time.NewTicker(streamPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e := <-sub.Events:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+
+		case <-sub.Done:
+			msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteWait))
+			return
+
+		case <-gone:
+			return
+		}
+	}
+}
+
+// readUntilClosed discards anything the client sends and keeps the read
+// deadline moving on pongs. It closes gone when the client goes away.
+func readUntilClosed(conn *websocket.Conn, gone chan struct{}) {
+	defer close(gone)
+
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func parseStreamFilter(r *http.Request) (stream.Filter, error) {
+	filter := stream.Filter{
+		StockIDs:  map[int64]bool{},
+		Companies: map[string]bool{},
+	}
+
+	query := r.URL.Query()
+
+	for _, v := range splitParams(query["stockid"]) {
+		id, err := strconv.ParseInt(v, 10, 64)
+
+		if err != nil {
+			return filter, badRequest("stockid must be an integer", err)
+		}
+
+		filter.StockIDs[id] = true
+	}
+
+	for _, v := range query["company"] {
+		if v = strings.TrimSpace(v); v != "" {
+			filter.Companies[strings.ToLower(v)] = true
+		}
+	}
+
+	return filter, nil
+}
+
+func splitParams(values []string) []string {
+	var out []string
+
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				out = append(out, part)
+			}
+		}
+	}
+
+	return out
+}
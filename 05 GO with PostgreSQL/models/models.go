@@ -1,8 +1,55 @@
 package models
 
+import (
+	"github/fbdaf/go-postgres/money"
+	"time"
+)
+
 type Stock struct {
-	StockID      int64    `json:"stockid"`
-	Name    string `json:"name"`
-	Price   int64    `json:"price"`
-	Company string `json:"company"`
+	StockID  int64        `json:"stockid"`
+	Name     string       `json:"name"`
+	Price    money.Amount `json:"price"`
+	Currency string       `json:"currency"`
+	Company  string       `json:"company"`
+}
+
+type PricePoint struct {
+	Price      money.Amount `json:"price"`
+	Currency   string       `json:"currency"`
+	RecordedAt time.Time    `json:"recorded_at"`
+}
+
+type OHLC struct {
+	Bucket   time.Time    `json:"bucket"`
+	Currency string       `json:"currency"`
+	Open     money.Amount `json:"open"`
+	High     money.Amount `json:"high"`
+	Low      money.Amount `json:"low"`
+	Close    money.Amount `json:"close"`
+	Count    int64        `json:"count"`
+}
+
+type PriceChange struct {
+	StockID       int64        `json:"stockid"`
+	Currency      string       `json:"currency"`
+	From          time.Time    `json:"from"`
+	To            time.Time    `json:"to"`
+	FirstPrice    money.Amount `json:"first_price"`
+	LastPrice     money.Amount `json:"last_price"`
+	Change        money.Amount `json:"change"`
+	ChangePercent money.Amount `json:"change_percent"`
+}
+
+type BulkResult struct {
+	Inserted  int64           `json:"inserted"`
+	Updated   int64           `json:"updated"`
+	Unchanged int64           `json:"unchanged"`
+	Rejected  int64           `json:"rejected"`
+	Errors    []BulkRejection `json:"errors,omitempty"`
+}
+
+type BulkRejection struct {
+	Line   int    `json:"line"`
+	Name   string `json:"name,omitempty"`
+	Reason string `json:"reason"`
 }
@@ -0,0 +1,343 @@
+// Package money implements an exact fixed-point decimal for prices.
+//
+// Amounts are stored as an int64 count of 1/10000ths, which matches the
+// NUMERIC(18,4) columns in the database. They never pass through float64,
+// so values round-trip through JSON and Postgres unchanged.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of fractional digits kept by an Amount.
+const Scale = 4
+
+const unit = 10000 // 10^Scale
+
+var (
+	ErrInvalid  = errors.New("money: invalid decimal")
+	ErrOverflow = errors.New("money: amount out of range")
+	ErrCurrency = errors.New("money: currency must be a three-letter ISO 4217 code")
+)
+
+// Amount is a decimal number with Scale fractional digits.
+// The zero value is 0.
+type Amount struct {
+	units int64
+}
+
+// FromInt returns a whole amount.
+func FromInt(n int64) Amount {
+	return Amount{units: n * unit}
+}
+
+// Parse reads a decimal string such as "150", "-0.5" or "1234.5678".
+// More than Scale fractional digits is an error rather than a silent
+// rounding, so clients always get back exactly what they sent.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return Amount{}, ErrInvalid
+	}
+
+	neg := false
+
+	switch s[0] {
+	case '-':
+		neg = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, frac, hasDot := strings.Cut(s, ".")
+
+	if whole == "" && frac == "" || hasDot && frac == "" {
+		return Amount{}, ErrInvalid
+	}
+
+	if len(frac) > Scale {
+		return Amount{}, fmt.Errorf("%w: more than %d decimal places", ErrInvalid, Scale)
+	}
+
+	if !digitsOnly(whole) || !digitsOnly(frac) {
+		return Amount{}, ErrInvalid
+	}
+
+	digits := whole + frac + strings.Repeat("0", Scale-len(frac))
+
+	// Parsing the magnitude unsigned lets the most negative amount, whose
+	// magnitude is one more than the largest positive one, round-trip.
+	mag, err := strconv.ParseUint(digits, 10, 64)
+
+	if err != nil {
+		return Amount{}, ErrOverflow
+	}
+
+	if neg {
+		if mag > -math.MinInt64 {
+			return Amount{}, ErrOverflow
+		}
+
+		return Amount{units: int64(-mag)}, nil
+	}
+
+	if mag > math.MaxInt64 {
+		return Amount{}, ErrOverflow
+	}
+
+	return Amount{units: int64(mag)}, nil
+}
+
+// MustParse is like Parse but panics on error. It is meant for constants.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return a
+}
+
+func digitsOnly(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// String formats the amount with at least two fractional digits and
+// without trailing zeros beyond that: 150 -> "150.00", 1.2345 -> "1.2345".
+func (a Amount) String() string {
+	u := a.units
+	sign := ""
+
+	if u < 0 {
+		sign = "-"
+	}
+
+	abs := new(big.Int).Abs(big.NewInt(u)).String()
+
+	if len(abs) <= Scale {
+		abs = strings.Repeat("0", Scale-len(abs)+1) + abs
+	}
+
+	whole := abs[:len(abs)-Scale]
+	frac := strings.TrimRight(abs[len(abs)-Scale:], "0")
+
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	return sign + whole + "." + frac
+}
+
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+// Cmp returns -1, 0 or +1 depending on whether a is less than, equal to or
+// greater than b.
+func (a Amount) Cmp(b Amount) int {
+	switch {
+	case a.units < b.units:
+		return -1
+	case a.units > b.units:
+		return 1
+	}
+
+	return 0
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum := a.units + b.units
+
+	if (sum > a.units) != (b.units > 0) {
+		return Amount{}, ErrOverflow
+	}
+
+	return Amount{units: sum}, nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	diff := a.units - b.units
+
+	if (diff < a.units) != (b.units > 0) {
+		return Amount{}, ErrOverflow
+	}
+
+	return Amount{units: diff}, nil
+}
+
+// Quo returns a/b rounded to Scale digits with round-half-even, so
+// aggregates such as averages come out the same on every run and platform.
+func (a Amount) Quo(b Amount) (Amount, error) {
+	if b.units == 0 {
+		return Amount{}, errors.New("money: division by zero")
+	}
+
+	num := new(big.Int).Mul(big.NewInt(a.units), big.NewInt(unit))
+
+	return fromBig(quoHalfEven(num, big.NewInt(b.units)))
+}
+
+// MulInt multiplies by a plain integer.
+func (a Amount) MulInt(n int64) (Amount, error) {
+	return fromBig(new(big.Int).Mul(big.NewInt(a.units), big.NewInt(n)))
+}
+
+// Round rounds to places fractional digits (0 <= places <= Scale) with
+// round-half-even.
+func (a Amount) Round(places int) Amount {
+	if places < 0 || places >= Scale {
+		return a
+	}
+
+	div := int64(math.Pow10(Scale - places))
+	q := quoHalfEven(big.NewInt(a.units), big.NewInt(div))
+
+	return Amount{units: q.Int64() * div}
+}
+
+// quoHalfEven divides num by den and rounds the result half to even.
+func quoHalfEven(num, den *big.Int) *big.Int {
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+
+	if r.Sign() == 0 {
+		return q
+	}
+
+	twice := new(big.Int).Abs(r)
+	twice.Lsh(twice, 1)
+
+	cmp := twice.Cmp(new(big.Int).Abs(den))
+
+	if cmp > 0 || cmp == 0 && q.Bit(0) == 1 {
+		if (num.Sign() < 0) != (den.Sign() < 0) {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+
+	return q
+}
+
+func fromBig(b *big.Int) (Amount, error) {
+	if !b.IsInt64() {
+		return Amount{}, ErrOverflow
+	}
+
+	return Amount{units: b.Int64()}, nil
+}
+
+// MarshalJSON encodes the amount as a JSON string so no client ever
+// reads it into a binary float.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.String())
+}
+
+// UnmarshalJSON accepts either a decimal string or a bare JSON number.
+// Numbers are still parsed from their literal text, never via float64.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+
+	if s == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+
+	if strings.ContainsAny(s, "eE") {
+		return fmt.Errorf("%w: exponent notation is not supported", ErrInvalid)
+	}
+
+	v, err := Parse(s)
+
+	if err != nil {
+		return err
+	}
+
+	*a = v
+
+	return nil
+}
+
+// Value implements driver.Valuer. Postgres parses the string as NUMERIC.
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+// Scan implements sql.Scanner for NUMERIC and integer columns.
+func (a *Amount) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return a.scanString(string(v))
+	case string:
+		return a.scanString(v)
+	case int64:
+		if v > math.MaxInt64/unit || v < math.MinInt64/unit {
+			return ErrOverflow
+		}
+
+		*a = FromInt(v)
+
+		return nil
+	case nil:
+		*a = Amount{}
+
+		return nil
+	}
+
+	return fmt.Errorf("money: cannot scan %T into Amount", src)
+}
+
+func (a *Amount) scanString(s string) error {
+	v, err := Parse(s)
+
+	if err != nil {
+		return err
+	}
+
+	*a = v
+
+	return nil
+}
+
+// NormalizeCurrency upper-cases code and checks it looks like an ISO 4217
+// code. An empty code means USD.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	if code == "" {
+		return "USD", nil
+	}
+
+	if len(code) != 3 {
+		return "", ErrCurrency
+	}
+
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", ErrCurrency
+		}
+	}
+
+	return code, nil
+}
@@ -0,0 +1,293 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"150", "150.00"},
+		{"0", "0.00"},
+		{"-0", "0.00"},
+		{"+1.5", "1.50"},
+		{"-0.5", "-0.50"},
+		{".25", "0.25"},
+		{"7.", ""},
+		{"1234.5678", "1234.5678"},
+		{"1.2300", "1.23"},
+		{"-0.0001", "-0.0001"},
+		{" 42.1 ", "42.10"},
+		{"922337203685477.5807", "922337203685477.5807"},
+		{"-922337203685477.5808", "-922337203685477.5808"},
+		{"922337203685477.5808", ""},
+		{"-922337203685477.5809", ""},
+		{"1.23456", ""},
+		{"", ""},
+		{"-", ""},
+		{".", ""},
+		{"1e3", ""},
+		{"1,000", ""},
+		{"--1", ""},
+		{"0x10", ""},
+	}
+
+	for _, tt := range tests {
+		a, err := Parse(tt.in)
+
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Parse(%q) = %s, want an error", tt.in, a)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+
+		if got := a.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+
+		if b, err := Parse(a.String()); err != nil || b != a {
+			t.Errorf("Parse(%q) = %s, %v, want %s back", a.String(), b, err, a)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in   string
+		want error
+	}{
+		{"1.23456", ErrInvalid},
+		{"abc", ErrInvalid},
+		{"99999999999999999999", ErrOverflow},
+		{"922337203685477.5808", ErrOverflow},
+		{"-922337203685477.5809", ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		if _, err := Parse(tt.in); !errors.Is(err, tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %v", tt.in, err, tt.want)
+		}
+	}
+}
+
+func TestArithmeticOverflow(t *testing.T) {
+	max := Amount{units: math.MaxInt64}
+	min := Amount{units: math.MinInt64}
+	one := MustParse("0.0001")
+
+	tests := []struct {
+		name string
+		op   func() (Amount, error)
+		want string
+	}{
+		{"add", func() (Amount, error) { return MustParse("1.25").Add(MustParse("-3.5")) }, "-2.25"},
+		{"add max", func() (Amount, error) { return max.Add(one) }, ""},
+		{"add min", func() (Amount, error) { return min.Add(Amount{units: -1}) }, ""},
+		{"add to min", func() (Amount, error) { return min.Add(max) }, "-0.0001"},
+		{"sub", func() (Amount, error) { return MustParse("1.25").Sub(MustParse("3.5")) }, "-2.25"},
+		{"sub min", func() (Amount, error) { return min.Sub(one) }, ""},
+		{"sub from zero", func() (Amount, error) { return Amount{}.Sub(min) }, ""},
+		{"sub min from -1", func() (Amount, error) { return Amount{units: -1}.Sub(min) }, "922337203685477.5807"},
+		{"sub max", func() (Amount, error) { return max.Sub(Amount{units: -1}) }, ""},
+		{"mul", func() (Amount, error) { return MustParse("-2.5").MulInt(3) }, "-7.50"},
+		{"mul overflow", func() (Amount, error) { return max.MulInt(2) }, ""},
+		{"mul min by -1", func() (Amount, error) { return min.MulInt(-1) }, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.op()
+
+		if tt.want == "" {
+			if !errors.Is(err, ErrOverflow) {
+				t.Errorf("%s = %s, %v, want ErrOverflow", tt.name, got, err)
+			}
+
+			continue
+		}
+
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s = %s, %v, want %s", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestQuoHalfEven(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"10", "4", "2.50"},
+		{"1", "3", "0.3333"},
+		{"2", "3", "0.6667"},
+		{"0.0001", "2", "0.00"},
+		{"0.0003", "2", "0.0002"},
+		{"0.0005", "2", "0.0002"},
+		{"0.0007", "2", "0.0004"},
+		{"-0.0001", "2", "0.00"},
+		{"-0.0003", "2", "-0.0002"},
+		{"-0.0007", "2", "-0.0004"},
+		{"0.0007", "-2", "-0.0004"},
+		{"-0.0005", "-2", "0.0002"},
+		{"-2", "3", "-0.6667"},
+	}
+
+	for _, tt := range tests {
+		got, err := MustParse(tt.a).Quo(MustParse(tt.b))
+
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s / %s = %s, %v, want %s", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+
+	if _, err := MustParse("1").Quo(Amount{}); err == nil {
+		t.Error("1 / 0 succeeded")
+	}
+}
+
+func TestRoundHalfEven(t *testing.T) {
+	tests := []struct {
+		in     string
+		places int
+		want   string
+	}{
+		{"1.005", 2, "1.00"},
+		{"1.015", 2, "1.02"},
+		{"1.0151", 2, "1.02"},
+		{"-1.005", 2, "-1.00"},
+		{"-1.015", 2, "-1.02"},
+		{"-1.0149", 2, "-1.01"},
+		{"2.5", 0, "2.00"},
+		{"3.5", 0, "4.00"},
+		{"-2.5", 0, "-2.00"},
+		{"-3.5", 0, "-4.00"},
+		{"0.5", 0, "0.00"},
+		{"1.2345", 4, "1.2345"},
+		{"1.2345", -1, "1.2345"},
+	}
+
+	for _, tt := range tests {
+		if got := MustParse(tt.in).Round(tt.places).String(); got != tt.want {
+			t.Errorf("Round(%s, %d) = %s, want %s", tt.in, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src     interface{}
+		want    string
+		wantErr bool
+	}{
+		{[]byte("160.0000"), "160.00", false},
+		{"-0.1234", "-0.1234", false},
+		{int64(42), "42.00", false},
+		{int64(-7), "-7.00", false},
+		{nil, "0.00", false},
+		{[]byte("1.23456"), "", true},
+		{int64(math.MaxInt64/unit + 1), "", true},
+		{int64(math.MinInt64/unit - 1), "", true},
+		{1.5, "", true},
+	}
+
+	for _, tt := range tests {
+		a := MustParse("99")
+		err := a.Scan(tt.src)
+
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Scan(%#v) = %s, want an error", tt.src, a)
+			}
+
+			continue
+		}
+
+		if err != nil || a.String() != tt.want {
+			t.Errorf("Scan(%#v) = %s, %v, want %s", tt.src, a, err, tt.want)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{`"150.1234"`, `"150.1234"`, false},
+		{`150.5`, `"150.50"`, false},
+		{`-0.0001`, `"-0.0001"`, false},
+		{`"-922337203685477.5808"`, `"-922337203685477.5808"`, false},
+		{`1e3`, ``, true},
+		{`"1.23456"`, ``, true},
+		{`true`, ``, true},
+	}
+
+	for _, tt := range tests {
+		var a Amount
+		err := json.Unmarshal([]byte(tt.in), &a)
+
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Unmarshal(%s) = %s, want an error", tt.in, a)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.in, err)
+			continue
+		}
+
+		got, err := json.Marshal(a)
+
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Marshal(Unmarshal(%s)) = %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+
+	a := MustParse("5")
+
+	if err := json.Unmarshal([]byte(`null`), &a); err != nil || a.String() != "5.00" {
+		t.Errorf("Unmarshal(null) = %s, %v, want the amount unchanged", a, err)
+	}
+}
+
+func TestNormalizeCurrency(t *testing.T) {
+	tests := map[string]string{
+		"":     "USD",
+		" eur": "EUR",
+		"GBP":  "GBP",
+		"US":   "",
+		"US1":  "",
+		"USDT": "",
+	}
+
+	for in, want := range tests {
+		got, err := NormalizeCurrency(in)
+
+		if want == "" {
+			if !errors.Is(err, ErrCurrency) {
+				t.Errorf("NormalizeCurrency(%q) = %q, %v, want ErrCurrency", in, got, err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != want {
+			t.Errorf("NormalizeCurrency(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+}
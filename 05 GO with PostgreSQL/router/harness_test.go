@@ -0,0 +1,333 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github/fbdaf/go-postgres/middleware"
+	"net"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// The harness runs the whole API against a throwaway Postgres cluster.
+//
+// By default it looks for initdb and pg_ctl (on PATH, in $PG_BIN or in the
+// usual package locations), creates a cluster in a temporary directory and
+// starts it on a free port. Set POSTGRES_TEST_URL to use an existing server
+// instead; its stocks and stock_prices tables are truncated by the tests.
+//
+// When neither is available, tests that need the database are skipped and
+// only the request-validation cases run.
+
+const testOrigin = "https://app.example.com"
+
+var (
+	testDB     *sql.DB
+	skipReason string
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	os.Setenv("CORS_ALLOWED_ORIGINS", testOrigin)
+
+	url, stop, err := startPostgres()
+
+	if err != nil {
+		skipReason = err.Error()
+		return m.Run()
+	}
+
+	defer stop()
+
+	os.Setenv("POSTGRES_URL", url)
+
+	testDB = middleware.CreateConnection()
+	defer testDB.Close()
+
+	middleware.AutoMigrate(testDB)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go middleware.ListenForStockChanges(ctx)
+
+	return m.Run()
+}
+
+func startPostgres() (string, func(), error) {
+	if url := os.Getenv("POSTGRES_TEST_URL"); url != "" {
+		return url, func() {}, nil
+	}
+
+	initdb, err := findPostgresBinary("initdb")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	pgCtl, err := findPostgresBinary("pg_ctl")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if os.Geteuid() == 0 {
+		return "", nil, errors.New("initdb refuses to run as root; set POSTGRES_TEST_URL instead")
+	}
+
+	dir, err := os.MkdirTemp("", "stocks-pg-")
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	data := filepath.Join(dir, "data")
+
+	out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput()
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("initdb: %v\n%s", err, out)
+	}
+
+	port, err := freePort()
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	opts := fmt.Sprintf("-p %d -c listen_addresses=127.0.0.1 -k %s -F", port, dir)
+
+	out, err = exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", opts, "-w", "start").CombinedOutput()
+
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("pg_ctl start: %v\n%s", err, out)
+	}
+
+	stop := func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}
+
+	url := fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable&timezone=UTC", port)
+
+	return url, stop, nil
+}
+
+func findPostgresBinary(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+
+	patterns := []string{
+		filepath.Join(os.Getenv("PG_BIN"), name),
+		"/usr/lib/postgresql/*/bin/" + name,
+		"/usr/local/opt/postgresql*/bin/" + name,
+		"/opt/homebrew/opt/postgresql*/bin/" + name,
+	}
+
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+
+		if len(matches) > 0 {
+			return matches[len(matches)-1], nil
+		}
+	}
+
+	return "", fmt.Errorf("%s not found; install Postgres or set PG_BIN or POSTGRES_TEST_URL", name)
+}
+
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func requireDB(t *testing.T) {
+	t.Helper()
+
+	if testDB == nil {
+		t.Skipf("Postgres is not available: %s", skipReason)
+	}
+}
+
+// resetDB empties the tables and loads the fixtures below. Stock IDs restart
+// at 1, so AAPL is stock 1 and MSFT is stock 2.
+//
+// AAPL has four recorded prices across two days and a current price of
+// 160.00; MSFT has a single price of 300.50.
+func resetDB(t *testing.T) {
+	t.Helper()
+
+	requireDB(t)
+
+	fixtures := []string{
+		`TRUNCATE stocks, stock_prices RESTART IDENTITY CASCADE`,
+		`INSERT INTO stocks (name, price, currency, company) VALUES
+			('AAPL', 160, 'USD', 'Apple Inc.'),
+			('MSFT', 300.50, 'USD', 'Microsoft')`,
+		`INSERT INTO stock_prices (stockid, price, currency, recorded_at) VALUES
+			(1, 150, 'USD', '2024-01-01T10:00:00Z'),
+			(1, 155, 'USD', '2024-01-01T10:30:00Z'),
+			(1, 148, 'USD', '2024-01-01T11:15:00Z'),
+			(1, 160, 'USD', '2024-01-02T09:00:00Z'),
+			(2, 300.50, 'USD', '2024-01-01T10:00:00Z')`,
+	}
+
+	for _, q := range fixtures {
+		if _, err := testDB.Exec(q); err != nil {
+			t.Fatalf("loading fixtures: %v", err)
+		}
+	}
+}
+
+type apiCase struct {
+	name        string
+	method      string
+	path        string
+	body        string
+	contentType string
+	headers     map[string]string
+	// needsDB marks cases that reach the database. The others fail
+	// validation first and run even without Postgres.
+	needsDB    bool
+	wantStatus int
+	// want is compared against the decoded JSON response when set.
+	want  interface{}
+	check func(t *testing.T, rec *httptest.ResponseRecorder)
+}
+
+func runCases(t *testing.T, cases []apiCase) {
+	t.Helper()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.needsDB {
+				resetDB(t)
+			}
+
+			rec := serve(tc.method, tc.path, tc.body, tc.contentType, tc.headers)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("%s %s: status = %d, want %d; body: %s", tc.method, tc.path, rec.Code, tc.wantStatus, rec.Body)
+			}
+
+			if tc.want != nil {
+				assertJSON(t, rec.Body.Bytes(), tc.want)
+			}
+
+			if tc.check != nil {
+				tc.check(t, rec)
+			}
+		})
+	}
+}
+
+func serve(method, path, body, contentType string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rec := httptest.NewRecorder()
+	Router().ServeHTTP(rec, req)
+
+	return rec
+}
+
+// assertJSON compares got with want after round-tripping want through
+// encoding/json, so expectations can be written as plain maps and slices.
+func assertJSON(t *testing.T, got []byte, want interface{}) {
+	t.Helper()
+
+	wantBytes, err := json.Marshal(want)
+
+	if err != nil {
+		t.Fatalf("encoding expectation: %v", err)
+	}
+
+	var gotValue, wantValue interface{}
+
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("response is not JSON: %v; body: %s", err, got)
+	}
+
+	json.Unmarshal(wantBytes, &wantValue)
+
+	gotNorm, _ := json.Marshal(gotValue)
+	wantNorm, _ := json.Marshal(wantValue)
+
+	if !bytes.Equal(gotNorm, wantNorm) {
+		t.Fatalf("response mismatch\n got: %s\nwant: %s", gotNorm, wantNorm)
+	}
+}
+
+// field decodes the response as a JSON object and returns one field.
+func field(t *testing.T, rec *httptest.ResponseRecorder, name string) interface{} {
+	t.Helper()
+
+	var m map[string]interface{}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+		t.Fatalf("response is not a JSON object: %v; body: %s", err, rec.Body)
+	}
+
+	return m[name]
+}
+
+func header(name, want string) func(t *testing.T, rec *httptest.ResponseRecorder) {
+	return func(t *testing.T, rec *httptest.ResponseRecorder) {
+		t.Helper()
+
+		if got := rec.Header().Get(name); got != want {
+			t.Fatalf("%s header = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func queryStock(t *testing.T, name string) (price, currency string) {
+	t.Helper()
+
+	err := testDB.QueryRow(`SELECT price::text, currency FROM stocks WHERE name=$1`, name).Scan(&price, &currency)
+
+	if err != nil {
+		t.Fatalf("reading stock %s: %v", name, err)
+	}
+
+	return price, currency
+}
+
+func countRows(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+
+	var n int
+
+	if err := testDB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+
+	return n
+}
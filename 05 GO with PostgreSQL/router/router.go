@@ -2,17 +2,51 @@ package router
 
 import (
 	"github/fbdaf/go-postgres/middleware"
+	"net/http"
+	"strings"
+
 	"github.com/gorilla/mux"
 )
 
 func Router() *mux.Router {
+	cors := middleware.CORSConfigFromEnv()
+
 	router := mux.NewRouter()
+	router.Use(middleware.CORS(cors))
 
+	router.HandleFunc("/api/stocks", middleware.GetAllStock).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/stocks", middleware.CreateStock).Methods("POST", "OPTIONS")
+	router.HandleFunc("/api/stocks/bulk", middleware.BulkUpsertStocks).Methods("POST", "OPTIONS")
 	router.HandleFunc("/api/stocks/{id}", middleware.GetStock).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/stock", middleware.GetAllStock).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/stock", middleware.CreateStock).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/stock/{id}", middleware.UpdateStock).Methods("PUT", "OPTIONS")
-	router.HandleFunc("/api/deletestock/{id}", middleware.DeleteStock).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/stocks/{id}", middleware.UpdateStock).Methods("PUT", "OPTIONS")
+	router.HandleFunc("/api/stocks/{id}", middleware.DeleteStock).Methods("DELETE", "OPTIONS")
+	router.HandleFunc("/api/stocks/{id}/prices", middleware.GetStockPrices).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/stocks/{id}/ohlc", middleware.GetStockOHLC).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/stocks/{id}/change", middleware.GetStockChange).Methods("GET", "OPTIONS")
+	router.HandleFunc("/api/stream", middleware.StreamStocks(cors)).Methods("GET")
+
+	// Deprecated aliases kept for existing clients.
+	router.Handle("/api/stock", deprecated(middleware.GetAllStock, "/api/stocks")).Methods("GET", "OPTIONS")
+	router.Handle("/api/stock", deprecated(middleware.CreateStock, "/api/stocks")).Methods("POST", "OPTIONS")
+	router.Handle("/api/stock/{id}", deprecated(middleware.UpdateStock, "/api/stocks/{id}")).Methods("PUT", "OPTIONS")
+	router.Handle("/api/deletestock/{id}", deprecated(middleware.DeleteStock, "/api/stocks/{id}")).Methods("DELETE", "OPTIONS")
 
 	return router
 }
+
+// deprecated marks responses from an old path with a Deprecation header
+// and a Link to the path that replaces it.
+func deprecated(h http.HandlerFunc, successor string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		link := successor
+
+		if id, ok := mux.Vars(r)["id"]; ok {
+			link = strings.Replace(successor, "{id}", id, 1)
+		}
+
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+link+">; rel=\"successor-version\"")
+
+		h(w, r)
+	})
+}
@@ -0,0 +1,659 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type obj = map[string]interface{}
+
+var (
+	aapl = obj{"stockid": 1, "name": "AAPL", "price": "160.00", "currency": "USD", "company": "Apple Inc."}
+	msft = obj{"stockid": 2, "name": "MSFT", "price": "300.50", "currency": "USD", "company": "Microsoft"}
+
+	notFound = obj{"message": "Stock not found"}
+	badID    = obj{"message": "Stock id must be an integer"}
+	badBody  = obj{"message": "Unable to decode the request body"}
+	badPrice = obj{"message": "price must have at most 14 digits before the decimal point"}
+)
+
+func historyCount(id int, want int) func(t *testing.T, rec *httptest.ResponseRecorder) {
+	return func(t *testing.T, rec *httptest.ResponseRecorder) {
+		t.Helper()
+
+		if got := countRows(t, `SELECT COUNT(*) FROM stock_prices WHERE stockid=$1`, id); got != want {
+			t.Fatalf("stock %d has %d price points, want %d", id, got, want)
+		}
+	}
+}
+
+func historyCountByName(name string, want int) func(t *testing.T, rec *httptest.ResponseRecorder) {
+	return func(t *testing.T, rec *httptest.ResponseRecorder) {
+		t.Helper()
+
+		got := countRows(t, `SELECT COUNT(*) FROM stock_prices JOIN stocks USING (stockid) WHERE name=$1`, name)
+
+		if got != want {
+			t.Fatalf("%s has %d price points, want %d", name, got, want)
+		}
+	}
+}
+
+func TestStocks(t *testing.T) {
+	runCases(t, []apiCase{
+		{
+			name: "list", method: "GET", path: "/api/stocks",
+			needsDB: true, wantStatus: http.StatusOK,
+			want: []obj{aapl, msft},
+		},
+		{
+			name: "get", method: "GET", path: "/api/stocks/1",
+			needsDB: true, wantStatus: http.StatusOK,
+			want: aapl,
+		},
+		{
+			name: "get missing", method: "GET", path: "/api/stocks/99",
+			needsDB: true, wantStatus: http.StatusNotFound,
+			want: notFound,
+		},
+		{
+			name: "get non-numeric id", method: "GET", path: "/api/stocks/abc",
+			wantStatus: http.StatusBadRequest,
+			want:       badID,
+		},
+		{
+			name: "create", method: "POST", path: "/api/stocks",
+			body:    `{"name":"TSLA","price":"250.1234","currency":"usd","company":"Tesla"}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			want: obj{"id": 3, "message": "Stock created successfully"},
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				price, currency := queryStock(t, "TSLA")
+
+				if price != "250.1234" || currency != "USD" {
+					t.Fatalf("stored price = %s %s, want 250.1234 USD", price, currency)
+				}
+
+				historyCount(3, 1)(t, rec)
+			},
+		},
+		{
+			name: "create accepts numeric price", method: "POST", path: "/api/stocks",
+			body:    `{"name":"TSLA","price":250,"company":"Tesla"}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			want: obj{"id": 3, "message": "Stock created successfully"},
+		},
+		{
+			name: "create malformed JSON", method: "POST", path: "/api/stocks",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest,
+			want:       badBody,
+		},
+		{
+			name: "create too many decimals", method: "POST", path: "/api/stocks",
+			body:       `{"name":"TSLA","price":"1.23456","company":"Tesla"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       badBody,
+		},
+		{
+			name: "create bad currency", method: "POST", path: "/api/stocks",
+			body:       `{"name":"TSLA","price":"1","currency":"dollars","company":"Tesla"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "money: currency must be a three-letter ISO 4217 code"},
+		},
+		{
+			name: "create name too long", method: "POST", path: "/api/stocks",
+			body:       `{"name":"` + strings.Repeat("é", 256) + `","price":"1","company":"Tesla"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "name is longer than 255 characters"},
+		},
+		{
+			name: "create company too long", method: "POST", path: "/api/stocks",
+			body:       `{"name":"TSLA","price":"1","company":"` + strings.Repeat("x", 256) + `"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "company is longer than 255 characters"},
+		},
+		{
+			name: "create name of 255 characters", method: "POST", path: "/api/stocks",
+			body:    `{"name":"` + strings.Repeat("é", 255) + `","price":"1","company":"Tesla"}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			want: obj{"id": 3, "message": "Stock created successfully"},
+		},
+		{
+			name: "create price too large", method: "POST", path: "/api/stocks",
+			body:       `{"name":"TSLA","price":"200000000000000","company":"Tesla"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       badPrice,
+		},
+		{
+			name: "create price too small", method: "POST", path: "/api/stocks",
+			body:       `{"name":"TSLA","price":-100000000000000,"company":"Tesla"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       badPrice,
+		},
+		{
+			name: "create largest price", method: "POST", path: "/api/stocks",
+			body:    `{"name":"TSLA","price":"99999999999999.9999","company":"Tesla"}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			want: obj{"id": 3, "message": "Stock created successfully"},
+		},
+		{
+			name: "create duplicate name", method: "POST", path: "/api/stocks",
+			body:    `{"name":"AAPL","price":"1","company":"Apple Inc."}`,
+			needsDB: true, wantStatus: http.StatusConflict,
+			want: obj{"message": "Stock conflicts with existing data"},
+		},
+		{
+			name: "update price", method: "PUT", path: "/api/stocks/1",
+			body:    `{"name":"AAPL","price":"170.25","company":"Apple Inc."}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			want:  obj{"id": 1, "message": "Stock updated successfully. Total rows/record affected 1"},
+			check: historyCount(1, 5),
+		},
+		{
+			name: "update without price change", method: "PUT", path: "/api/stocks/1",
+			body:    `{"name":"AAPL","price":"160","company":"Apple"}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			check: historyCount(1, 4),
+		},
+		{
+			name: "update missing", method: "PUT", path: "/api/stocks/99",
+			body:    `{"name":"X","price":"1","company":"Y"}`,
+			needsDB: true, wantStatus: http.StatusNotFound,
+			want: notFound,
+		},
+		{
+			name: "update non-numeric id", method: "PUT", path: "/api/stocks/abc",
+			body:       `{"name":"X","price":"1","company":"Y"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       badID,
+		},
+		{
+			name: "update name too long", method: "PUT", path: "/api/stocks/1",
+			body:       `{"name":"` + strings.Repeat("x", 256) + `","price":"1","company":"Apple Inc."}`,
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "name is longer than 255 characters"},
+		},
+		{
+			name: "update company too long", method: "PUT", path: "/api/stocks/1",
+			body:       `{"name":"AAPL","price":"1","company":"` + strings.Repeat("é", 256) + `"}`,
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "company is longer than 255 characters"},
+		},
+		{
+			name: "update price too large", method: "PUT", path: "/api/stocks/1",
+			body:       `{"name":"AAPL","price":"100000000000000","company":"Apple Inc."}`,
+			wantStatus: http.StatusBadRequest,
+			want:       badPrice,
+		},
+		{
+			name: "update malformed JSON", method: "PUT", path: "/api/stocks/1",
+			body:       `not json`,
+			wantStatus: http.StatusBadRequest,
+			want:       badBody,
+		},
+		{
+			name: "delete", method: "DELETE", path: "/api/stocks/2",
+			needsDB: true, wantStatus: http.StatusOK,
+			want:  obj{"id": 2, "message": "Stock deleted successfully. Total rows/record affected 1"},
+			check: historyCount(2, 0),
+		},
+		{
+			name: "delete missing", method: "DELETE", path: "/api/stocks/99",
+			needsDB: true, wantStatus: http.StatusNotFound,
+			want: notFound,
+		},
+		{
+			name: "delete non-numeric id", method: "DELETE", path: "/api/stocks/abc",
+			wantStatus: http.StatusBadRequest,
+			want:       badID,
+		},
+		{
+			name: "method not allowed", method: "PATCH", path: "/api/stocks/1",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	})
+}
+
+func TestPriceHistory(t *testing.T) {
+	prices := func(want ...string) func(t *testing.T, rec *httptest.ResponseRecorder) {
+		return func(t *testing.T, rec *httptest.ResponseRecorder) {
+			t.Helper()
+
+			var points []struct {
+				Price      string    `json:"price"`
+				RecordedAt time.Time `json:"recorded_at"`
+			}
+
+			if err := json.Unmarshal(rec.Body.Bytes(), &points); err != nil {
+				t.Fatalf("decoding history: %v", err)
+			}
+
+			var got []string
+
+			for _, p := range points {
+				got = append(got, p.Price)
+			}
+
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Fatalf("prices = %v, want %v", got, want)
+			}
+		}
+	}
+
+	runCases(t, []apiCase{
+		{
+			name: "full history", method: "GET", path: "/api/stocks/1/prices",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: prices("150.00", "155.00", "148.00", "160.00"),
+		},
+		{
+			name: "history in range", method: "GET",
+			path:    "/api/stocks/1/prices?from=2024-01-01T10:15:00Z&to=2024-01-02T00:00:00Z",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: prices("155.00", "148.00"),
+		},
+		{
+			name: "history of missing stock", method: "GET", path: "/api/stocks/99/prices",
+			needsDB: true, wantStatus: http.StatusNotFound,
+			want: notFound,
+		},
+		{
+			name: "history bad timestamp", method: "GET", path: "/api/stocks/1/prices?from=yesterday",
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "from must be an RFC 3339 timestamp"},
+		},
+		{
+			name: "history reversed range", method: "GET",
+			path:       "/api/stocks/1/prices?from=2024-01-02T00:00:00Z&to=2024-01-01T00:00:00Z",
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "from must be before to"},
+		},
+		{
+			name: "ohlc per hour", method: "GET", path: "/api/stocks/1/ohlc?bucket=hour",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				var candles []struct {
+					Open, High, Low, Close string
+					Count                  int
+				}
+
+				if err := json.Unmarshal(rec.Body.Bytes(), &candles); err != nil {
+					t.Fatalf("decoding candles: %v", err)
+				}
+
+				want := []string{
+					"150.00 155.00 150.00 155.00 2",
+					"148.00 148.00 148.00 148.00 1",
+					"160.00 160.00 160.00 160.00 1",
+				}
+
+				if len(candles) != len(want) {
+					t.Fatalf("got %d candles, want %d: %s", len(candles), len(want), rec.Body)
+				}
+
+				for i, c := range candles {
+					got := strings.Join([]string{c.Open, c.High, c.Low, c.Close, strconv.Itoa(c.Count)}, " ")
+
+					if got != want[i] {
+						t.Fatalf("candle %d = %s, want %s", i, got, want[i])
+					}
+				}
+			},
+		},
+		{
+			name: "ohlc bad bucket", method: "GET", path: "/api/stocks/1/ohlc?bucket=week",
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "bucket must be one of minute, hour or day"},
+		},
+		{
+			name: "change", method: "GET", path: "/api/stocks/1/change",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				for name, want := range map[string]string{
+					"first_price":    "150.00",
+					"last_price":     "160.00",
+					"change":         "10.00",
+					"change_percent": "6.67",
+					"currency":       "USD",
+				} {
+					if got := field(t, rec, name); got != want {
+						t.Fatalf("%s = %v, want %s", name, got, want)
+					}
+				}
+			},
+		},
+		{
+			name: "change with no prices in range", method: "GET", path: "/api/stocks/1/change?from=2030-01-01T00:00:00Z",
+			needsDB: true, wantStatus: http.StatusNotFound,
+			want: obj{"message": "No prices recorded in range"},
+		},
+	})
+}
+
+func TestOHLCIgnoresSessionTimeZone(t *testing.T) {
+	resetDB(t)
+
+	// A session in India is five and a half hours ahead of UTC, which
+	// would move both day buckets and split the hours.
+	u, err := url.Parse(os.Getenv("POSTGRES_URL"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := u.Query()
+	q.Set("timezone", "Asia/Kolkata")
+	u.RawQuery = q.Encode()
+
+	t.Setenv("POSTGRES_URL", u.String())
+
+	rec := serve("GET", "/api/stocks/1/ohlc?bucket=day", "", "", nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d; body: %s", rec.Code, rec.Body)
+	}
+
+	var candles []struct {
+		Bucket time.Time
+		Count  int
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &candles); err != nil {
+		t.Fatalf("decoding candles: %v", err)
+	}
+
+	want := []string{"2024-01-01T00:00:00Z 3", "2024-01-02T00:00:00Z 1"}
+
+	if len(candles) != len(want) {
+		t.Fatalf("got %d candles, want %d: %s", len(candles), len(want), rec.Body)
+	}
+
+	for i, c := range candles {
+		if got := c.Bucket.UTC().Format(time.RFC3339) + " " + strconv.Itoa(c.Count); got != want[i] {
+			t.Errorf("candle %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestBulkUpsert(t *testing.T) {
+	counts := func(inserted, updated, unchanged, rejected float64) func(t *testing.T, rec *httptest.ResponseRecorder) {
+		return func(t *testing.T, rec *httptest.ResponseRecorder) {
+			t.Helper()
+
+			for name, want := range map[string]float64{
+				"inserted":  inserted,
+				"updated":   updated,
+				"unchanged": unchanged,
+				"rejected":  rejected,
+			} {
+				if got := field(t, rec, name); got != want {
+					t.Fatalf("%s = %v, want %v; body: %s", name, got, want, rec.Body)
+				}
+			}
+		}
+	}
+
+	runCases(t, []apiCase{
+		{
+			name: "csv", method: "POST", path: "/api/stocks/bulk", contentType: "text/csv",
+			body:    "name,price,company\nAAPL,160,Apple Inc.\nMSFT,310.75,Microsoft\nTSLA,250,Tesla\nBAD,x,Nope\n",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				counts(1, 1, 1, 1)(t, rec)
+				historyCountByName("MSFT", 2)(t, rec)
+				historyCountByName("TSLA", 1)(t, rec)
+			},
+		},
+		{
+			name: "ndjson", method: "POST", path: "/api/stocks/bulk", contentType: "application/x-ndjson",
+			body:    "{\"name\":\"TSLA\",\"price\":\"250\",\"company\":\"Tesla\"}\n{\"name\":\"MSFT\",\"company\":\"Microsoft\"}\n{\"name\":\"NVDA\",\"price\":900,\"currency\":\"eur\",\"company\":\"Nvidia\"}\n",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: counts(2, 0, 0, 1),
+		},
+		{
+			name: "duplicate names keep the last row", method: "POST", path: "/api/stocks/bulk?format=csv",
+			body:    "name,price,company\nTSLA,1,Tesla\nTSLA,2,Tesla\n",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				counts(1, 0, 0, 1)(t, rec)
+
+				if price, _ := queryStock(t, "TSLA"); price != "2.0000" {
+					t.Fatalf("TSLA price = %s, want 2.0000", price)
+				}
+			},
+		},
+		{
+			name: "values the columns cannot hold", method: "POST", path: "/api/stocks/bulk", contentType: "text/csv",
+			body: "name,price,company\n" +
+				strings.Repeat("N", 256) + ",1,Long Name\n" +
+				"LONG,1," + strings.Repeat("é", 256) + "\n" +
+				"BIG,100000000000000,Too Big\n" +
+				"SMALL,-100000000000000.0000,Too Small\n",
+			wantStatus: http.StatusOK,
+			want: obj{
+				"inserted": 0, "updated": 0, "unchanged": 0, "rejected": 4,
+				"errors": []obj{
+					{"line": 2, "name": strings.Repeat("N", 256), "reason": "name is longer than 255 characters"},
+					{"line": 3, "name": "LONG", "reason": "company is longer than 255 characters"},
+					{"line": 4, "name": "BIG", "reason": "price must have at most 14 digits before the decimal point"},
+					{"line": 5, "name": "SMALL", "reason": "price must have at most 14 digits before the decimal point"},
+				},
+			},
+		},
+		{
+			name: "longest values the columns hold", method: "POST", path: "/api/stocks/bulk", contentType: "text/csv",
+			body:    "name,price,company\n" + strings.Repeat("N", 255) + ",99999999999999.9999," + strings.Repeat("é", 255) + "\n",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				counts(1, 0, 0, 0)(t, rec)
+
+				if price, _ := queryStock(t, strings.Repeat("N", 255)); price != "99999999999999.9999" {
+					t.Fatalf("price = %s, want 99999999999999.9999", price)
+				}
+			},
+		},
+		{
+			name: "csv missing column", method: "POST", path: "/api/stocks/bulk", contentType: "text/csv",
+			body:       "name,company\nAAPL,Apple\n",
+			wantStatus: http.StatusBadRequest,
+			want:       obj{"message": "Unable to read the request body"},
+		},
+		{
+			name: "unsupported content type", method: "POST", path: "/api/stocks/bulk", contentType: "text/plain",
+			body:       "AAPL",
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+	})
+}
+
+func TestDeprecatedAliases(t *testing.T) {
+	deprecated := func(link string) func(t *testing.T, rec *httptest.ResponseRecorder) {
+		return func(t *testing.T, rec *httptest.ResponseRecorder) {
+			t.Helper()
+
+			header("Deprecation", "true")(t, rec)
+			header("Link", "<"+link+">; rel=\"successor-version\"")(t, rec)
+		}
+	}
+
+	runCases(t, []apiCase{
+		{
+			name: "list", method: "GET", path: "/api/stock",
+			needsDB: true, wantStatus: http.StatusOK,
+			want:  []obj{aapl, msft},
+			check: deprecated("/api/stocks"),
+		},
+		{
+			name: "create", method: "POST", path: "/api/stock",
+			body:    `{"name":"TSLA","price":"250","company":"Tesla"}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			check: deprecated("/api/stocks"),
+		},
+		{
+			name: "update", method: "PUT", path: "/api/stock/1",
+			body:    `{"name":"AAPL","price":"161","company":"Apple Inc."}`,
+			needsDB: true, wantStatus: http.StatusOK,
+			check: deprecated("/api/stocks/1"),
+		},
+		{
+			name: "delete", method: "DELETE", path: "/api/deletestock/2",
+			needsDB: true, wantStatus: http.StatusOK,
+			check: deprecated("/api/stocks/2"),
+		},
+		{
+			name: "delete non-numeric id", method: "DELETE", path: "/api/deletestock/abc",
+			wantStatus: http.StatusBadRequest,
+			want:       badID,
+			check:      deprecated("/api/stocks/abc"),
+		},
+	})
+}
+
+func TestCORS(t *testing.T) {
+	preflight := func(origin, method string) map[string]string {
+		return map[string]string{"Origin": origin, "Access-Control-Request-Method": method}
+	}
+
+	runCases(t, []apiCase{
+		{
+			name: "preflight from allowed origin", method: "OPTIONS", path: "/api/stocks",
+			headers:    preflight(testOrigin, "POST"),
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				header("Access-Control-Allow-Origin", testOrigin)(t, rec)
+				header("Access-Control-Allow-Methods", "POST")(t, rec)
+				header("Access-Control-Allow-Headers", "Content-Type")(t, rec)
+			},
+		},
+		{
+			name: "preflight from other origin", method: "OPTIONS", path: "/api/stocks/1",
+			headers:    preflight("https://evil.example.com", "DELETE"),
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				header("Access-Control-Allow-Origin", "")(t, rec)
+				header("Vary", "Origin")(t, rec)
+			},
+		},
+		{
+			name: "preflight for disallowed method", method: "OPTIONS", path: "/api/stocks/1",
+			headers:    preflight(testOrigin, "PATCH"),
+			wantStatus: http.StatusNoContent,
+			check:      header("Access-Control-Allow-Methods", ""),
+		},
+		{
+			name: "simple request from allowed origin", method: "GET", path: "/api/stocks/abc",
+			headers:    map[string]string{"Origin": testOrigin},
+			wantStatus: http.StatusBadRequest,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				header("Access-Control-Allow-Origin", testOrigin)(t, rec)
+				header("Access-Control-Expose-Headers", "Deprecation, Link")(t, rec)
+				header("Vary", "Origin")(t, rec)
+			},
+		},
+		{
+			name: "simple request without an origin", method: "GET", path: "/api/stocks/abc",
+			wantStatus: http.StatusBadRequest,
+			check: func(t *testing.T, rec *httptest.ResponseRecorder) {
+				header("Access-Control-Allow-Origin", "")(t, rec)
+				header("Vary", "Origin")(t, rec)
+			},
+		},
+	})
+}
+
+func TestStream(t *testing.T) {
+	resetDB(t)
+
+	server := httptest.NewServer(Router())
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	u.Scheme = "ws"
+	u.Path = "/api/stream"
+	u.RawQuery = url.Values{"company": {"Tesla"}}.Encode()
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+
+	if err != nil {
+		t.Fatalf("dialing stream: %v", err)
+	}
+
+	defer conn.Close()
+
+	rec := serve("POST", "/api/stocks", `{"name":"TSLA","price":"250","company":"Tesla"}`, "", nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("creating stock: %d %s", rec.Code, rec.Body)
+	}
+
+	// The listener may still be connecting when the first change commits,
+	// so keep changing the price until an event arrives.
+	events := make(chan map[string]interface{})
+
+	go func() {
+		for {
+			var e map[string]interface{}
+
+			if err := conn.ReadJSON(&e); err != nil {
+				close(events)
+				return
+			}
+
+			events <- e
+		}
+	}()
+
+	// Changes to other companies must be filtered out.
+	serve("PUT", "/api/stocks/1", `{"name":"AAPL","price":"1","company":"Apple Inc."}`, "", nil)
+
+	deadline := time.After(10 * time.Second)
+	tick := time.NewTicker(200 * time.Millisecond)
+	defer tick.Stop()
+
+	price := 250
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatal("stream closed before an event arrived")
+			}
+
+			stock, _ := e["stock"].(map[string]interface{})
+
+			if stock["company"] != "Tesla" {
+				t.Fatalf("received event for another company: %v", e)
+			}
+
+			if e["op"] != "insert" && e["op"] != "update" {
+				t.Fatalf("op = %v, want insert or update", e["op"])
+			}
+
+			return
+
+		case <-tick.C:
+			price++
+			serve("PUT", "/api/stocks/3", `{"name":"TSLA","price":"`+strconv.Itoa(price)+`","company":"Tesla"}`, "", nil)
+
+		case <-deadline:
+			t.Fatal("no stream event within 10s")
+		}
+	}
+}
+
+func TestStreamRejectsBadFilter(t *testing.T) {
+	rec := serve("GET", "/api/stream?stockid=abc", "", "", nil)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,109 @@
+// Package stream fans out stock change events from Postgres to WebSocket
+// subscribers.
+package stream
+
+import (
+	"github/fbdaf/go-postgres/models"
+	"strings"
+	"sync"
+)
+
+// Event is a single change to the stocks table, as sent by the
+// notify_stock_change trigger and forwarded to clients.
+type Event struct {
+	Op    string       `json:"op"`
+	Stock models.Stock `json:"stock"`
+}
+
+// Filter selects which events a subscriber receives. An empty filter
+// matches everything; otherwise an event matches when its stock ID or its
+// company is in the filter.
+type Filter struct {
+	StockIDs  map[int64]bool
+	Companies map[string]bool
+}
+
+func (f Filter) Match(e Event) bool {
+	if len(f.StockIDs) == 0 && len(f.Companies) == 0 {
+		return true
+	}
+
+	return f.StockIDs[e.Stock.StockID] || f.Companies[strings.ToLower(e.Stock.Company)]
+}
+
+// Subscriber receives matching events on Events. When the subscriber falls
+// more than its buffer behind, the hub drops it and closes Done instead of
+// blocking the other subscribers.
+type Subscriber struct {
+	Events chan Event
+	Done   chan struct{}
+
+	filter Filter
+	once   sync.Once
+}
+
+func (s *Subscriber) close() {
+	s.once.Do(func() { close(s.Done) })
+}
+
+// Hub keeps the set of live subscribers.
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscriber]bool
+	buffer int
+}
+
+// NewHub returns a hub whose subscribers can each queue up to buffer
+// events.
+func NewHub(buffer int) *Hub {
+	return &Hub{
+		subs:   make(map[*Subscriber]bool),
+		buffer: buffer,
+	}
+}
+
+func (h *Hub) Subscribe(f Filter) *Subscriber {
+	s := &Subscriber{
+		Events: make(chan Event, h.buffer),
+		Done:   make(chan struct{}),
+		filter: f,
+	}
+
+	h.mu.Lock()
+	h.subs[s] = true
+	h.mu.Unlock()
+
+	return s
+}
+
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+
+	s.close()
+}
+
+// Publish delivers e to every matching subscriber without blocking.
+// Subscribers whose buffer is full are disconnected.
+func (h *Hub) Publish(e Event) {
+	var slow []*Subscriber
+
+	h.mu.RLock()
+	for s := range h.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+
+		select {
+		case s.Events <- e:
+		default:
+			slow = append(slow, s)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, s := range slow {
+		h.Unsubscribe(s)
+	}
+}
@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the Postgres NOTIFY channel written by the
+// notify_stock_change trigger.
+const Channel = "stock_changes"
+
+// Listen subscribes to Channel on its own connection and publishes every
+// notification to h until ctx is cancelled. pq.Listener reconnects on its
+// own; events that happen while it is disconnected are lost.
+func Listen(ctx context.Context, connStr string, h *Hub) error {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Stock change listener: %v", err)
+		}
+	})
+
+	defer listener.Close()
+
+	err := listener.Listen(Channel)
+
+	if err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(90 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case n := <-listener.Notify:
+			// A nil notification means the connection was re-established.
+			if n == nil {
+				continue
+			}
+
+			var e Event
+
+			err := json.Unmarshal([]byte(n.Extra), &e)
+
+			if err != nil {
+				log.Printf("Unable to decode stock change %q. %v", n.Extra, err)
+				continue
+			}
+
+			h.Publish(e)
+
+		case <-ping.C:
+			go listener.Ping()
+		}
+	}
+}
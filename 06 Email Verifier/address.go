@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Address verification outcomes.
+const (
+	// statusDeliverable means the mail server accepted the recipient and
+	// rejected a made-up one, so the mailbox very likely exists.
+	statusDeliverable = "deliverable"
+	// statusUndeliverable means the address cannot receive mail: bad
+	// syntax, no mail server, or a permanent rejection of the recipient.
+	statusUndeliverable = "undeliverable"
+	// statusRisky means the server accepts the address but cannot be
+	// trusted to say whether it exists, typically a catch-all domain.
+	statusRisky = "risky"
+	// statusUnknown means the check could not finish: DNS or connection
+	// failures, greylisting, or a server that refused the probe itself.
+	statusUnknown = "unknown"
+)
+
+type addressResult struct {
+	Address string `json:"address"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	// MX is the mail server that was asked.
+	MX string `json:"mx,omitempty"`
+	// Code is the SMTP reply to RCPT TO, or 0 if it was never sent.
+	Code     int  `json:"code,omitempty"`
+	CatchAll bool `json:"catchAll"`
+}
+
+// prober holds the settings for SMTP conversations.
+type prober struct {
+	resolver Resolver
+	// helo is the name sent in EHLO. Many servers reject probes whose
+	// HELO name does not resolve.
+	helo string
+	// from is the MAIL FROM address.
+	from    string
+	timeout time.Duration
+	// port is the SMTP port, 25 except in tests.
+	port string
+}
+
+// parseAddress checks s against the addr-spec grammar of RFC 5322 and
+// splits it. Display names and angle brackets are not accepted, since the
+// input is meant to be a bare address.
+func parseAddress(s string) (local, domain string, err error) {
+	parsed, err := mail.ParseAddress(s)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if parsed.Name != "" || strings.ContainsAny(s, "<>") || strings.TrimSpace(s) != s {
+		return "", "", errors.New("mail: expected a bare address")
+	}
+
+	// The local part is kept as written, quotes included, so it can be
+	// sent back to the server unchanged.
+	at := strings.LastIndexByte(s, '@')
+	local, domain = s[:at], s[at+1:]
+
+	if len(local) > 64 {
+		return "", "", errors.New("mail: local part is longer than 64 characters")
+	}
+
+	if strings.HasPrefix(domain, "[") {
+		return "", "", errors.New("mail: address literals are not supported")
+	}
+
+	if len(domain) > 253 || !strings.Contains(domain, ".") {
+		return "", "", errors.New("mail: domain is not a fully qualified name")
+	}
+
+	return local, strings.ToLower(domain), nil
+}
+
+func (p *prober) verifyAddress(ctx context.Context, address string) addressResult {
+	r := addressResult{Address: address, Status: statusUnknown}
+
+	local, domain, err := parseAddress(address)
+
+	if err != nil {
+		r.Status, r.Reason = statusUndeliverable, "invalid syntax: "+strings.TrimPrefix(err.Error(), "mail: ")
+		return r
+	}
+
+	mx, err := p.primaryMX(ctx, domain)
+
+	if err != nil {
+		var dnsErr *net.DNSError
+
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound || errors.Is(err, errNullMX) {
+			r.Status = statusUndeliverable
+		}
+
+		r.Reason = err.Error()
+		return r
+	}
+
+	r.MX = mx
+
+	p.probe(ctx, mx, local+"@"+domain, domain, &r)
+
+	return r
+}
+
+var errNullMX = errors.New("domain does not accept mail (null MX)")
+
+// primaryMX returns the most preferred mail server for domain. A domain
+// without MX records is its own mail server (RFC 5321 section 5.1).
+func (p *prober) primaryMX(ctx context.Context, domain string) (string, error) {
+	mxs, err := p.resolver.LookupMX(ctx, domain)
+
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		if _, err := p.resolver.LookupIP(ctx, "ip", domain); err != nil {
+			return "", err
+		}
+
+		return domain, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(mxs) == 0 {
+		return domain, nil
+	}
+
+	sort.SliceStable(mxs, func(i, j int) bool {
+		return mxs[i].Pref < mxs[j].Pref
+	})
+
+	host := strings.TrimSuffix(mxs[0].Host, ".")
+
+	if host == "" {
+		return "", errNullMX
+	}
+
+	return host, nil
+}
+
+// probe talks to mx up to RCPT TO and fills in r. After the real
+// recipient it tries a random one on the same domain: if that is accepted
+// as well, the server accepts everything and the answer says nothing.
+func (p *prober) probe(ctx context.Context, mx, address, domain string, r *addressResult) {
+	conn, err := p.dial(ctx, mx)
+
+	if err != nil {
+		r.Reason = "connect: " + err.Error()
+		return
+	}
+
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	conn.SetDeadline(time.Now().Add(p.timeout))
+
+	c, err := smtp.NewClient(conn, mx)
+
+	if err != nil {
+		r.Reason = "greeting: " + smtpError(err)
+		return
+	}
+
+	defer c.Close()
+
+	if err := c.Hello(p.helo); err != nil {
+		r.Reason = "EHLO: " + smtpError(err)
+		return
+	}
+
+	if err := c.Mail(p.from); err != nil {
+		r.Reason = "MAIL FROM: " + smtpError(err)
+		return
+	}
+
+	err = c.Rcpt(address)
+
+	if err != nil {
+		r.Code = replyCode(err)
+	}
+
+	switch {
+	case err == nil:
+	case r.Code >= 500:
+		r.Status, r.Reason = statusUndeliverable, "RCPT TO: "+smtpError(err)
+		c.Quit()
+		return
+	default:
+		r.Reason = "RCPT TO: " + smtpError(err)
+		c.Quit()
+		return
+	}
+
+	// net/smtp does not return the code of positive replies.
+	r.Code = 250
+
+	r.CatchAll = c.Rcpt(randomLocalPart()+"@"+domain) == nil
+
+	c.Quit()
+
+	if r.CatchAll {
+		r.Status, r.Reason = statusRisky, "domain accepts any address"
+		return
+	}
+
+	r.Status = statusDeliverable
+}
+
+func (p *prober) dial(ctx context.Context, host string) (net.Conn, error) {
+	ips, err := p.resolver.LookupIP(ctx, "ip", host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var d net.Dialer
+	var lastErr error
+
+	for _, ip := range ips {
+		dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		conn, err := d.DialContext(dialCtx, "tcp", net.JoinHostPort(ip.String(), p.port))
+		cancel()
+
+		if err == nil {
+			return conn, nil
+		}
+
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("%s has no addresses", host)
+	}
+
+	return nil, lastErr
+}
+
+// replyCode extracts the SMTP reply code from an error returned by
+// net/smtp, or 0 for errors that are not replies.
+func replyCode(err error) int {
+	var tpErr *textproto.Error
+
+	if errors.As(err, &tpErr) {
+		return tpErr.Code
+	}
+
+	return 0
+}
+
+// smtpError formats server replies as "550 5.1.1 No such user".
+func smtpError(err error) string {
+	var tpErr *textproto.Error
+
+	if errors.As(err, &tpErr) {
+		return fmt.Sprintf("%d %s", tpErr.Code, tpErr.Msg)
+	}
+
+	return err.Error()
+}
+
+func randomLocalPart() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return "verify-" + hex.EncodeToString(b)
+}
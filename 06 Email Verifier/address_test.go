@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is a minimal SMTP server. rcpt decides the reply to each RCPT
+// TO by address; everything else is accepted.
+type fakeSMTP struct {
+	port string
+	rcpt func(address string) string
+
+	// mu guards the replies, which tests may change after the server has
+	// started, and the commands it has seen.
+	mu       sync.Mutex
+	greeting string
+	mail     string
+	commands []string
+}
+
+func startFakeSMTP(t *testing.T, rcpt func(address string) string) *fakeSMTP {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	s := &fakeSMTP{
+		port:     port,
+		greeting: "220 mx.test ESMTP ready",
+		mail:     "250 2.1.0 Ok",
+		rcpt:     rcpt,
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	reply := func(line string) {
+		rw.WriteString(line + "\r\n")
+		rw.Flush()
+	}
+
+	s.mu.Lock()
+	greeting, mail := s.greeting, s.mail
+	s.mu.Unlock()
+
+	reply(greeting)
+
+	if !strings.HasPrefix(greeting, "220") {
+		return
+	}
+
+	for {
+		line, err := rw.ReadString('\n')
+
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		s.mu.Lock()
+		s.commands = append(s.commands, line)
+		s.mu.Unlock()
+
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250-mx.test greets you")
+			reply("250 PIPELINING")
+		case "MAIL":
+			reply(mail)
+		case "RCPT":
+			addr := line[strings.IndexByte(line, '<')+1 : strings.LastIndexByte(line, '>')]
+			reply(s.rcpt(addr))
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		case "DATA":
+			reply("554 DATA must never be sent by the verifier")
+		default:
+			reply("250 Ok")
+		}
+	}
+}
+
+func (s *fakeSMTP) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+func TestParseAddress(t *testing.T) {
+	valid := map[string]string{
+		"alice@example.com":           "example.com",
+		"Alice.Smith+tag@Example.COM": "example.com",
+		`"john doe"@example.com`:      "example.com",
+		"o'brien@mail.example.co.uk":  "mail.example.co.uk",
+		"x@sub-domain.example.org":    "sub-domain.example.org",
+	}
+
+	for in, wantDomain := range valid {
+		_, domain, err := parseAddress(in)
+
+		if err != nil || domain != wantDomain {
+			t.Errorf("parseAddress(%q) = %q, %v; want %q", in, domain, err, wantDomain)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"alice",
+		"alice@",
+		"@example.com",
+		"alice@@example.com",
+		"alice smith@example.com",
+		"Alice <alice@example.com>",
+		"alice@localhost",
+		"alice@[192.0.2.1]",
+		"alice..smith@example.com",
+		strings.Repeat("a", 65) + "@example.com",
+	} {
+		if _, _, err := parseAddress(in); err == nil {
+			t.Errorf("parseAddress(%q) accepted an invalid address", in)
+		}
+	}
+}
+
+func TestVerifyAddress(t *testing.T) {
+	strict := startFakeSMTP(t, func(addr string) string {
+		switch {
+		case strings.HasPrefix(addr, "alice@"):
+			return "250 2.1.5 Ok"
+		case strings.HasPrefix(addr, "grey@"):
+			return "450 4.2.0 Greylisted, try again later"
+		}
+
+		return "550 5.1.1 No such user"
+	})
+
+	catchAll := startFakeSMTP(t, func(string) string { return "250 2.1.5 Ok" })
+
+	refusing := startFakeSMTP(t, func(string) string { return "250 Ok" })
+	refusing.mu.Lock()
+	refusing.mail = "553 5.7.1 Sender rejected"
+	refusing.mu.Unlock()
+
+	closed := startFakeSMTP(t, func(string) string { return "250 Ok" })
+	closed.mu.Lock()
+	closed.greeting = "554 No SMTP service here"
+	closed.mu.Unlock()
+
+	r := &fakeResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {
+				{Host: "backup.example.com.", Pref: 20},
+				{Host: "mx.example.com.", Pref: 10},
+			},
+			"nullmx.test": {{Host: ".", Pref: 0}},
+		},
+		ip: map[string][]net.IP{
+			"mx.example.com": {net.ParseIP("127.0.0.1")},
+			"implicit.test":  {net.ParseIP("127.0.0.1")},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		server     *fakeSMTP
+		address    string
+		wantStatus string
+		wantReason string
+		wantMX     string
+	}{
+		{name: "mailbox exists", server: strict, address: "alice@example.com", wantStatus: statusDeliverable, wantMX: "mx.example.com"},
+		{name: "mailbox rejected", server: strict, address: "bob@example.com", wantStatus: statusUndeliverable, wantReason: "550 5.1.1 No such user"},
+		{name: "greylisted", server: strict, address: "grey@example.com", wantStatus: statusUnknown, wantReason: "Greylisted"},
+		{name: "catch-all", server: catchAll, address: "anyone@example.com", wantStatus: statusRisky, wantReason: "accepts any address"},
+		{name: "sender refused", server: refusing, address: "alice@example.com", wantStatus: statusUnknown, wantReason: "MAIL FROM: 553"},
+		{name: "no service", server: closed, address: "alice@example.com", wantStatus: statusUnknown, wantReason: "greeting: 554"},
+		{name: "implicit MX", server: strict, address: "alice@implicit.test", wantStatus: statusDeliverable, wantMX: "implicit.test"},
+		{name: "null MX", server: strict, address: "alice@nullmx.test", wantStatus: statusUndeliverable, wantReason: "null MX"},
+		{name: "no such domain", server: strict, address: "alice@nowhere.test", wantStatus: statusUndeliverable, wantReason: "no such host"},
+		{name: "bad syntax", server: strict, address: "alice@@example.com", wantStatus: statusUndeliverable, wantReason: "invalid syntax"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &prober{
+				resolver: r,
+				helo:     "verifier.example.net",
+				from:     "probe@verifier.example.net",
+				timeout:  5 * time.Second,
+				port:     tc.server.port,
+			}
+
+			got := p.verifyAddress(context.Background(), tc.address)
+
+			if got.Status != tc.wantStatus || !strings.Contains(got.Reason, tc.wantReason) {
+				t.Fatalf("verifyAddress(%q) = %s (%q), want %s (%q)", tc.address, got.Status, got.Reason, tc.wantStatus, tc.wantReason)
+			}
+
+			if tc.wantMX != "" && got.MX != tc.wantMX {
+				t.Fatalf("MX = %q, want %q", got.MX, tc.wantMX)
+			}
+		})
+	}
+}
+
+func TestVerifyAddressConversation(t *testing.T) {
+	srv := startFakeSMTP(t, func(addr string) string {
+		if addr == "alice@example.com" {
+			return "250 Ok"
+		}
+
+		return "550 No such user"
+	})
+
+	p := &prober{
+		resolver: &fakeResolver{
+			mx: map[string][]*net.MX{"example.com": {{Host: "mx.example.com.", Pref: 10}}},
+			ip: map[string][]net.IP{"mx.example.com": {net.ParseIP("127.0.0.1")}},
+		},
+		helo:    "verifier.example.net",
+		from:    "probe@verifier.example.net",
+		timeout: 5 * time.Second,
+		port:    srv.port,
+	}
+
+	got := p.verifyAddress(context.Background(), "alice@example.com")
+
+	if got.Status != statusDeliverable || got.Code != 250 || got.CatchAll {
+		t.Fatalf("result = %+v", got)
+	}
+
+	cmds := srv.sent()
+
+	if len(cmds) != 5 {
+		t.Fatalf("commands = %q, want EHLO, MAIL, RCPT, RCPT, QUIT", cmds)
+	}
+
+	if cmds[0] != "EHLO verifier.example.net" || !strings.HasPrefix(cmds[1], "MAIL FROM:<probe@verifier.example.net>") {
+		t.Fatalf("HELO name or sender not used: %q", cmds)
+	}
+
+	if cmds[2] != "RCPT TO:<alice@example.com>" || !strings.HasPrefix(cmds[3], "RCPT TO:<verify-") || cmds[4] != "QUIT" {
+		t.Fatalf("unexpected conversation %q", cmds)
+	}
+}
+
+func TestVerifyAddressTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	defer l.Close()
+
+	// Accept and never answer.
+	go func() {
+		for {
+			conn, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	p := &prober{
+		resolver: &fakeResolver{ip: map[string][]net.IP{"silent.test": {net.ParseIP("127.0.0.1")}}},
+		helo:     "verifier.example.net",
+		from:     "probe@verifier.example.net",
+		timeout:  200 * time.Millisecond,
+		port:     port,
+	}
+
+	start := time.Now()
+	got := p.verifyAddress(context.Background(), "alice@silent.test")
+
+	if got.Status != statusUnknown || time.Since(start) > 5*time.Second {
+		t.Fatalf("result = %+v after %v", got, time.Since(start))
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type bimiReport struct {
+	// Logo is the l= URL of the SVG logo. It is empty when the domain
+	// publishes a record that declines to show a logo.
+	Logo string
+	// Authority is the a= URL of the Verified Mark Certificate, if any.
+	Authority string
+	Found     bool
+	Err       error
+}
+
+// checkBIMI reads default._bimi.<domain>. Mailbox providers only show the
+// logo when DMARC is enforced for all mail, so a BIMI record on a domain
+// whose effective policy is weaker is reported as an error.
+func checkBIMI(ctx context.Context, resolver Resolver, domain string, dmarc dmarcReport) bimiReport {
+	record, err := singleRecord(ctx, resolver, "default._bimi."+domain, "v=BIMI1")
+
+	if record == "" || err != nil {
+		return bimiReport{Err: err}
+	}
+
+	tags, err := parseTagList(record)
+
+	if err != nil {
+		return bimiReport{Found: true, Err: err}
+	}
+
+	report := bimiReport{Found: true, Logo: tags["l"], Authority: tags["a"]}
+
+	for _, u := range []string{report.Logo, report.Authority} {
+		if u == "" {
+			continue
+		}
+
+		parsed, err := url.Parse(u)
+
+		if err != nil || parsed.Scheme != "https" {
+			report.Err = fmt.Errorf("%q is not an https URL", u)
+			return report
+		}
+	}
+
+	if report.Logo != "" && !strings.HasSuffix(strings.ToLower(report.Logo), ".svg") {
+		report.Err = errors.New("logo must be an SVG file")
+		return report
+	}
+
+	policy := dmarc.Effective()
+
+	if report.Logo != "" && ((policy != "quarantine" && policy != "reject") || dmarc.Policy.Pct < 100) {
+		report.Err = errors.New("BIMI requires a DMARC policy of quarantine or reject at pct=100")
+	}
+
+	return report
+}
+
+func (r bimiReport) String() string {
+	switch {
+	case r.Err != nil:
+		return "error: " + r.Err.Error()
+	case !r.Found:
+		return ""
+	case r.Logo == "":
+		return "declined"
+	}
+
+	return r.Logo
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// defaultSelectors are DKIM selectors used by common mail providers. DNS
+// cannot list a domain's selectors, so only these are tried unless
+// -selectors names others.
+var defaultSelectors = []string{"default", "google", "selector1", "selector2", "k1", "mail", "dkim", "s1", "s2"}
+
+// minRSABits is the smallest RSA key RFC 8301 allows for signing.
+const minRSABits = 1024
+
+type dkimKey struct {
+	Selector string `json:"selector"`
+	// KeyType is the k= tag: "rsa" or "ed25519".
+	KeyType string `json:"keyType,omitempty"`
+	Bits    int    `json:"bits,omitempty"`
+	// Revoked is set when the record publishes an empty key.
+	Revoked bool  `json:"revoked,omitempty"`
+	Err     error `json:"-"`
+}
+
+// MarshalJSON adds the weak flag and writes Err as a string.
+func (k dkimKey) MarshalJSON() ([]byte, error) {
+	type plain dkimKey
+
+	out := struct {
+		plain
+		Weak  bool   `json:"weak,omitempty"`
+		Error string `json:"error,omitempty"`
+	}{plain: plain(k), Weak: k.weak()}
+
+	if k.Err != nil {
+		out.Error = k.Err.Error()
+	}
+
+	return json.Marshal(out)
+}
+
+func (k dkimKey) weak() bool {
+	return k.Err == nil && !k.Revoked && k.KeyType == "rsa" && k.Bits < minRSABits
+}
+
+func (k dkimKey) String() string {
+	switch {
+	case k.Err != nil:
+		return fmt.Sprintf("%s error: %v", k.Selector, k.Err)
+	case k.Revoked:
+		return k.Selector + " revoked"
+	case k.weak():
+		return fmt.Sprintf("%s %s %d (weak)", k.Selector, k.KeyType, k.Bits)
+	}
+
+	return fmt.Sprintf("%s %s %d", k.Selector, k.KeyType, k.Bits)
+}
+
+// checkDKIM looks up every selector under _domainkey.<domain> and returns
+// the keys that exist. Selectors with no record are left out.
+func checkDKIM(ctx context.Context, resolver Resolver, domain string, selectors []string) []dkimKey {
+	var keys []dkimKey
+
+	for _, selector := range selectors {
+		txts, err := resolver.LookupTXT(ctx, selector+"._domainkey."+domain)
+
+		var dnsErr *net.DNSError
+
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			continue
+		}
+
+		if err != nil {
+			keys = append(keys, dkimKey{Selector: selector, Err: err})
+			continue
+		}
+
+		if len(txts) == 0 {
+			continue
+		}
+
+		var key dkimKey
+
+		if len(txts) > 1 {
+			key.Err = errors.New("multiple key records")
+		} else {
+			key = parseDKIMKey(txts[0])
+		}
+
+		key.Selector = selector
+
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// parseDKIMKey reads a DKIM key record (RFC 6376 section 3.6.1) and works
+// out the key type and size.
+func parseDKIMKey(record string) dkimKey {
+	tags, err := parseTagList(record)
+
+	if err != nil {
+		return dkimKey{Err: err}
+	}
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return dkimKey{Err: fmt.Errorf("unsupported version %q", v)}
+	}
+
+	key := dkimKey{KeyType: strings.ToLower(tags["k"])}
+
+	if key.KeyType == "" {
+		key.KeyType = "rsa"
+	}
+
+	p, ok := tags["p"]
+
+	if !ok {
+		key.Err = errors.New("missing p tag")
+		return key
+	}
+
+	p = strings.Join(strings.Fields(p), "")
+
+	if p == "" {
+		key.Revoked = true
+		return key
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p)
+
+	if err != nil {
+		key.Err = errors.New("key is not valid base64")
+		return key
+	}
+
+	switch key.KeyType {
+	case "rsa":
+		key.Bits, key.Err = rsaKeyBits(der)
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			key.Err = fmt.Errorf("ed25519 key is %d bytes", len(der))
+			return key
+		}
+
+		key.Bits = 256
+	default:
+		key.Err = fmt.Errorf("unknown key type %q", key.KeyType)
+	}
+
+	return key
+}
+
+// rsaKeyBits accepts SubjectPublicKeyInfo, which RFC 6376 requires, and
+// bare PKCS#1 keys, which some signers publish anyway.
+func rsaKeyBits(der []byte) (int, error) {
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if rsaKey, ok := pub.(*rsa.PublicKey); ok {
+			return rsaKey.N.BitLen(), nil
+		}
+
+		return 0, errors.New("k=rsa but the key is not RSA")
+	}
+
+	rsaKey, err := x509.ParsePKCS1PublicKey(der)
+
+	if err != nil {
+		return 0, errors.New("key is not an RSA public key")
+	}
+
+	return rsaKey.N.BitLen(), nil
+}
+
+// parseTagList splits a "tag=value; tag=value" record, the format shared
+// by DKIM, TLS-RPT, BIMI and the MTA-STS TXT record. Tag names are
+// lowercased.
+func parseTagList(record string) (map[string]string, error) {
+	tags := map[string]string{}
+
+	for _, raw := range strings.Split(record, ";") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		tag, ok := splitTag(raw)
+
+		if !ok || tag.name == "" {
+			return nil, fmt.Errorf("%q is not a tag", strings.TrimSpace(raw))
+		}
+
+		if _, dup := tags[tag.name]; dup {
+			return nil, fmt.Errorf("%s given twice", tag.name)
+		}
+
+		tags[tag.name] = tag.value
+	}
+
+	return tags, nil
+}
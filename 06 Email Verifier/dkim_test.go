@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// weakRSAKey is a 512-bit RSA public key. It is fixed because current Go
+// versions refuse to generate keys this small.
+const weakRSAKey = "MFwwDQYJKoZIhvcNAQEBBQADSwAwSAJBAOlnEURQoDyjFxxAyDlXFcWy7TGhhsgNSZlGhX4/HAmZcGIwNEKCL2kbzUy6neMb5fCq0H1tbZiVNK8I10yl44ECAwEAAQ=="
+
+func rsaKeyRecord(t *testing.T, bits int, pkcs1 bool) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	der := x509.MarshalPKCS1PublicKey(&key.PublicKey)
+
+	if !pkcs1 {
+		der, err = x509.MarshalPKIXPublicKey(&key.PublicKey)
+
+		if err != nil {
+			t.Fatalf("encoding key: %v", err)
+		}
+	}
+
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+}
+
+func TestCheckDKIM(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	r := &fakeResolver{
+		txt: map[string][]string{
+			"selector1._domainkey.example.com": {rsaKeyRecord(t, 2048, false)},
+			"selector2._domainkey.example.com": {rsaKeyRecord(t, 1024, true)},
+			"old._domainkey.example.com":       {"v=DKIM1; k=rsa; p="},
+			"ed._domainkey.example.com":        {"v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)},
+			"tiny._domainkey.example.com":      {"v=DKIM1; k=rsa; p=" + weakRSAKey},
+			"junk._domainkey.example.com":      {"v=DKIM1; p=not*base64"},
+			"dup._domainkey.example.com":       {"v=DKIM1; p=", "v=DKIM1; p="},
+		},
+		errs: map[string]error{
+			"slow._domainkey.example.com": &net.DNSError{Err: "i/o timeout", Name: "slow._domainkey.example.com", IsTimeout: true},
+		},
+	}
+
+	selectors := []string{"selector1", "selector2", "missing", "old", "ed", "tiny", "junk", "dup", "slow"}
+
+	var got []string
+
+	for _, key := range checkDKIM(context.Background(), r, "example.com", selectors) {
+		got = append(got, key.String())
+	}
+
+	want := []string{
+		"selector1 rsa 2048",
+		"selector2 rsa 1024",
+		"old revoked",
+		"ed ed25519 256",
+		"tiny rsa 512 (weak)",
+		"junk error: key is not valid base64",
+		"dup error: multiple key records",
+		"slow error: lookup slow._domainkey.example.com: i/o timeout",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("checkDKIM =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseDKIMKeyErrors(t *testing.T) {
+	for _, record := range []string{
+		"v=DKIM2; p=AAAA",
+		"v=DKIM1; k=rsa",
+		"v=DKIM1; k=dsa; p=AAAA",
+		"v=DKIM1; k=ed25519; p=AAAA",
+		"v=DKIM1; k=rsa; p=AAAA",
+		"v=DKIM1; garbage",
+	} {
+		if key := parseDKIMKey(record); key.Err == nil {
+			t.Errorf("parseDKIMKey(%q) = %+v, want an error", record, key)
+		}
+	}
+}
+
+func TestParseTagList(t *testing.T) {
+	tags, err := parseTagList(" v=TLSRPTv1 ; RUA = mailto:tls@example.com ;")
+
+	if err != nil {
+		t.Fatalf("parseTagList: %v", err)
+	}
+
+	want := map[string]string{"v": "TLSRPTv1", "rua": "mailto:tls@example.com"}
+
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("parseTagList = %v, want %v", tags, want)
+	}
+
+	if _, err := parseTagList("v=1; v=2"); err == nil {
+		t.Fatalf("duplicate tags were accepted")
+	}
+}
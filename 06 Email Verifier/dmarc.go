@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+var (
+	errDMARCMultiple = errors.New("multiple DMARC records")
+	errDMARCSyntax   = errors.New("invalid DMARC record")
+)
+
+// dmarcPolicy holds the tags of a DMARC record, with the RFC 7489 defaults
+// filled in for the optional ones.
+type dmarcPolicy struct {
+	P     string
+	SP    string
+	Pct   int
+	RUA   []string
+	RUF   []string
+	ADKIM string
+	ASPF  string
+}
+
+// parseDMARC parses a "v=DMARC1; ..." record. Unknown tags are ignored;
+// known tags with bad values are errors wrapping errDMARCSyntax.
+func parseDMARC(record string) (*dmarcPolicy, error) {
+	tags := strings.Split(record, ";")
+
+	if v, ok := splitTag(tags[0]); !ok || v.name != "v" || v.value != "DMARC1" {
+		return nil, fmt.Errorf("%w: must start with v=DMARC1", errDMARCSyntax)
+	}
+
+	p := &dmarcPolicy{Pct: 100, ADKIM: "r", ASPF: "r"}
+	seen := map[string]bool{}
+
+	for _, raw := range tags[1:] {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		tag, ok := splitTag(raw)
+
+		if !ok {
+			return nil, fmt.Errorf("%w: %q is not a tag", errDMARCSyntax, strings.TrimSpace(raw))
+		}
+
+		if seen[tag.name] {
+			return nil, fmt.Errorf("%w: %s given twice", errDMARCSyntax, tag.name)
+		}
+
+		seen[tag.name] = true
+
+		var err error
+
+		switch tag.name {
+		case "p":
+			p.P, err = policyValue(tag.value)
+		case "sp":
+			p.SP, err = policyValue(tag.value)
+		case "pct":
+			p.Pct, err = strconv.Atoi(tag.value)
+
+			if err == nil && (p.Pct < 0 || p.Pct > 100) {
+				err = errors.New("out of range")
+			}
+		case "rua":
+			p.RUA, err = reportURIs(tag.value)
+		case "ruf":
+			p.RUF, err = reportURIs(tag.value)
+		case "adkim":
+			p.ADKIM, err = alignmentValue(tag.value)
+		case "aspf":
+			p.ASPF, err = alignmentValue(tag.value)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s=%s: %v", errDMARCSyntax, tag.name, tag.value, err)
+		}
+	}
+
+	if p.P == "" {
+		return nil, fmt.Errorf("%w: missing p tag", errDMARCSyntax)
+	}
+
+	if p.SP == "" {
+		p.SP = p.P
+	}
+
+	return p, nil
+}
+
+type dmarcTag struct {
+	name  string
+	value string
+}
+
+func splitTag(s string) (dmarcTag, bool) {
+	name, value, ok := strings.Cut(s, "=")
+
+	if !ok {
+		return dmarcTag{}, false
+	}
+
+	return dmarcTag{name: strings.ToLower(strings.TrimSpace(name)), value: strings.TrimSpace(value)}, true
+}
+
+func policyValue(v string) (string, error) {
+	switch v = strings.ToLower(v); v {
+	case "none", "quarantine", "reject":
+		return v, nil
+	}
+
+	return "", errors.New("must be none, quarantine or reject")
+}
+
+func alignmentValue(v string) (string, error) {
+	switch v = strings.ToLower(v); v {
+	case "r", "s":
+		return v, nil
+	}
+
+	return "", errors.New("must be r or s")
+}
+
+// reportURIs splits a rua or ruf list. Each entry is a URI with an
+// optional "!size" suffix, which is dropped.
+func reportURIs(v string) ([]string, error) {
+	var uris []string
+
+	for _, entry := range strings.Split(v, ",") {
+		entry = strings.TrimSpace(entry)
+
+		if i := strings.LastIndexByte(entry, '!'); i >= 0 {
+			entry = entry[:i]
+		}
+
+		u, err := url.Parse(entry)
+
+		if err != nil || u.Scheme == "" {
+			return nil, fmt.Errorf("%q is not a URI", entry)
+		}
+
+		uris = append(uris, entry)
+	}
+
+	return uris, nil
+}
+
+// dmarcReport is the outcome of looking up and judging a domain's DMARC
+// policy.
+type dmarcReport struct {
+	Record string
+	Policy *dmarcPolicy
+	// Inherited is set when the record came from the organizational
+	// domain, in which case its sp tag is the policy that applies.
+	Inherited bool
+	// Issues lists weaknesses in an otherwise valid policy.
+	Issues []string
+	Err    error
+}
+
+// Effective returns the policy that applies to the checked domain.
+func (r dmarcReport) Effective() string {
+	if r.Policy == nil {
+		return ""
+	}
+
+	if r.Inherited {
+		return r.Policy.SP
+	}
+
+	return r.Policy.P
+}
+
+// checkDMARC finds the DMARC record for domain, falling back to the
+// organizational domain as receivers do, and lists weaknesses.
+func checkDMARC(ctx context.Context, resolver Resolver, domain string) dmarcReport {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	record, err := fetchDMARC(ctx, resolver, domain)
+
+	report := dmarcReport{Record: record, Err: err}
+
+	if record == "" && err == nil {
+		org, orgErr := publicsuffix.EffectiveTLDPlusOne(domain)
+
+		if orgErr == nil && org != domain {
+			record, err = fetchDMARC(ctx, resolver, org)
+			report = dmarcReport{Record: record, Err: err, Inherited: record != ""}
+			domain = org
+		}
+	}
+
+	if record == "" || err != nil {
+		return report
+	}
+
+	report.Policy, report.Err = parseDMARC(record)
+
+	if report.Err != nil {
+		return report
+	}
+
+	p := report.Policy
+
+	if report.Effective() == "none" {
+		report.Issues = append(report.Issues, "p=none only monitors")
+	}
+
+	if !report.Inherited && p.P != "none" && p.SP == "none" {
+		report.Issues = append(report.Issues, "sp=none leaves subdomains unprotected")
+	}
+
+	if p.Pct < 100 {
+		report.Issues = append(report.Issues, fmt.Sprintf("pct=%d applies the policy to only part of the mail", p.Pct))
+	}
+
+	if len(p.RUA) == 0 {
+		report.Issues = append(report.Issues, "no rua, so no aggregate reports are sent")
+	}
+
+	checked := map[string]bool{}
+
+	for _, uri := range append(append([]string(nil), p.RUA...), p.RUF...) {
+		issue := checkReportAuthorization(ctx, resolver, domain, uri)
+
+		if issue != "" && !checked[issue] {
+			checked[issue] = true
+			report.Issues = append(report.Issues, issue)
+		}
+	}
+
+	return report
+}
+
+// fetchDMARC returns the single v=DMARC1 record at _dmarc.<domain>, or ""
+// when there is none.
+func fetchDMARC(ctx context.Context, resolver Resolver, domain string) (string, error) {
+	txts, err := resolver.LookupTXT(ctx, "_dmarc."+domain)
+
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	var found []string
+
+	for _, txt := range txts {
+		if isDMARC(txt) {
+			found = append(found, txt)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+
+	return found[0], errDMARCMultiple
+}
+
+func isDMARC(txt string) bool {
+	v, ok := splitTag(strings.SplitN(txt, ";", 2)[0])
+	return ok && v.name == "v" && v.value == "DMARC1"
+}
+
+// checkReportAuthorization applies RFC 7489 section 7.1: a report address
+// outside the policy's organizational domain must publish
+// <domain>._report._dmarc.<report domain> agreeing to receive reports.
+func checkReportAuthorization(ctx context.Context, resolver Resolver, domain, uri string) string {
+	u, err := url.Parse(uri)
+
+	if err != nil || !strings.EqualFold(u.Scheme, "mailto") {
+		return ""
+	}
+
+	_, host, ok := strings.Cut(u.Opaque, "@")
+
+	if !ok {
+		return fmt.Sprintf("report address %s has no domain", uri)
+	}
+
+	host = strings.ToLower(host)
+
+	if sameOrganization(domain, host) {
+		return ""
+	}
+
+	txts, err := resolver.LookupTXT(ctx, domain+"._report._dmarc."+host)
+
+	var dnsErr *net.DNSError
+
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return fmt.Sprintf("could not check report authorization for %s: %v", host, err)
+	}
+
+	for _, txt := range txts {
+		if isDMARC(txt) {
+			return ""
+		}
+	}
+
+	return fmt.Sprintf("%s has not authorized reports for %s", host, domain)
+}
+
+func sameOrganization(a, b string) bool {
+	orgA, errA := publicsuffix.EffectiveTLDPlusOne(a)
+	orgB, errB := publicsuffix.EffectiveTLDPlusOne(b)
+
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	return orgA == orgB
+}
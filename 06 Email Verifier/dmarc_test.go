@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDMARC(t *testing.T) {
+	got, err := parseDMARC("v=DMARC1; p=Quarantine; pct=50; rua=mailto:a@example.com!10m, mailto:b@example.net; ruf=mailto:f@example.com; adkim=s; fo=1")
+
+	if err != nil {
+		t.Fatalf("parseDMARC: %v", err)
+	}
+
+	want := &dmarcPolicy{
+		P:     "quarantine",
+		SP:    "quarantine",
+		Pct:   50,
+		RUA:   []string{"mailto:a@example.com", "mailto:b@example.net"},
+		RUF:   []string{"mailto:f@example.com"},
+		ADKIM: "s",
+		ASPF:  "r",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("parseDMARC = %+v, want %+v", got, want)
+	}
+
+	for _, record := range []string{
+		"v=DMARC1",
+		"p=reject; v=DMARC1",
+		"v=DMARC1; p=block",
+		"v=DMARC1; p=reject; pct=150",
+		"v=DMARC1; p=reject; adkim=x",
+		"v=DMARC1; p=reject; p=none",
+		"v=DMARC1; p=reject; rua=example.com",
+		"v=DMARC1; p=reject; junk",
+	} {
+		if _, err := parseDMARC(record); !errors.Is(err, errDMARCSyntax) {
+			t.Errorf("parseDMARC(%q) error = %v, want a syntax error", record, err)
+		}
+	}
+}
+
+func dmarcFixture() *fakeResolver {
+	return &fakeResolver{
+		txt: map[string][]string{
+			"_dmarc.strict.example.com":   {"v=DMARC1; p=reject; rua=mailto:dmarc@strict.example.com"},
+			"_dmarc.monitor.example.com":  {"v=DMARC1; p=none; rua=mailto:dmarc@monitor.example.com"},
+			"_dmarc.partial.example.com":  {"v=DMARC1; p=quarantine; pct=25; sp=none"},
+			"_dmarc.external.example.com": {"v=DMARC1; p=reject; rua=mailto:r@vendor.example.net,mailto:r@other.example.org"},
+			"_dmarc.twice.example.com":    {"v=DMARC1; p=reject", "v=DMARC1; p=none"},
+			"_dmarc.bad.example.com":      {"v=DMARC1; p=maybe"},
+			"_dmarc.example.co.uk":        {"v=DMARC1; p=reject; sp=quarantine; rua=mailto:d@example.co.uk"},
+			// vendor.example.net accepts reports for external.example.com;
+			// other.example.org does not.
+			"external.example.com._report._dmarc.vendor.example.net": {"v=DMARC1"},
+		},
+	}
+}
+
+func TestCheckDMARC(t *testing.T) {
+	r := dmarcFixture()
+
+	tests := []struct {
+		domain     string
+		wantPolicy string
+		wantIssues []string
+		wantErr    error
+	}{
+		{domain: "strict.example.com", wantPolicy: "reject"},
+		{
+			domain:     "monitor.example.com",
+			wantPolicy: "none",
+			wantIssues: []string{"p=none only monitors"},
+		},
+		{
+			domain:     "partial.example.com",
+			wantPolicy: "quarantine",
+			wantIssues: []string{
+				"sp=none leaves subdomains unprotected",
+				"pct=25 applies the policy to only part of the mail",
+				"no rua, so no aggregate reports are sent",
+			},
+		},
+		{
+			domain:     "external.example.com",
+			wantPolicy: "reject",
+			wantIssues: []string{"other.example.org has not authorized reports for external.example.com"},
+		},
+		// No record of its own, so the organizational domain's sp applies.
+		{domain: "mail.example.co.uk", wantPolicy: "quarantine"},
+		{domain: "twice.example.com", wantErr: errDMARCMultiple},
+		{domain: "bad.example.com", wantErr: errDMARCSyntax},
+		{domain: "missing.example.com"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			report := checkDMARC(context.Background(), r, tc.domain)
+
+			if !errors.Is(report.Err, tc.wantErr) {
+				t.Fatalf("Err = %v, want %v", report.Err, tc.wantErr)
+			}
+
+			if got := report.Effective(); got != tc.wantPolicy {
+				t.Errorf("Effective() = %q, want %q", got, tc.wantPolicy)
+			}
+
+			if !reflect.DeepEqual(report.Issues, tc.wantIssues) {
+				t.Errorf("Issues = %q, want %q", report.Issues, tc.wantIssues)
+			}
+		})
+	}
+}
+
+func TestCheckDMARCReportAuthorizationLookupFails(t *testing.T) {
+	r := dmarcFixture()
+	r.errs = map[string]error{
+		"external.example.com._report._dmarc.other.example.org": &net.DNSError{Err: "i/o timeout", IsTimeout: true},
+	}
+
+	report := checkDMARC(context.Background(), r, "external.example.com")
+
+	if len(report.Issues) != 1 || !strings.HasPrefix(report.Issues[0], "could not check report authorization for other.example.org") {
+		t.Fatalf("Issues = %q", report.Issues)
+	}
+}
+
+func TestGradeDomain(t *testing.T) {
+	r := dmarcFixture()
+
+	strongSPF := spfReport{Record: "v=spf1 mx -all"}
+
+	tests := []struct {
+		domain string
+		spf    spfReport
+		want   string
+	}{
+		{"strict.example.com", strongSPF, "A"},
+		{"strict.example.com", spfReport{Record: "v=spf1 mx ~all"}, "A"},
+		{"strict.example.com", spfReport{Record: "v=spf1 mx ?all"}, "B"},
+		{"strict.example.com", spfReport{}, "B"},
+		{"strict.example.com", spfReport{Record: "v=spf1 +all"}, "B"},
+		{"strict.example.com", spfReport{Record: "v=spf1 mx"}, "B"},
+		{"strict.example.com", spfReport{Record: "v=spf1 redirect=_spf.example.com"}, "A"},
+		{"strict.example.com", spfReport{Record: "v=spf1 -all", Err: errSPFLookupLimit}, "B"},
+		{"mail.example.co.uk", strongSPF, "B"},
+		{"monitor.example.com", strongSPF, "D"},
+		{"monitor.example.com", spfReport{}, "F"},
+		{"partial.example.com", strongSPF, "F"},
+		{"external.example.com", strongSPF, "B"},
+		{"twice.example.com", strongSPF, "F"},
+		{"missing.example.com", strongSPF, "F"},
+	}
+
+	for _, tc := range tests {
+		dmarc := checkDMARC(context.Background(), r, tc.domain)
+
+		if got := gradeDomain(dmarc, tc.spf); got != tc.want {
+			t.Errorf("gradeDomain(%s, %q) = %s, want %s", tc.domain, tc.spf.Record, got, tc.want)
+		}
+	}
+}
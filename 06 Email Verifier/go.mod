@@ -1,3 +1,5 @@
 module github.om/fbdaf/email-verifier
 
 go 1.21.4
+
+require golang.org/x/net v0.35.0
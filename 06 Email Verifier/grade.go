@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+)
+
+var grades = []string{"A", "B", "C", "D", "F"}
+
+// gradeDomain rates how well a domain is protected against spoofing.
+//
+// The DMARC policy sets the best grade reachable: reject allows A and
+// quarantine B. p=none starts at C, but is also one of the DMARC issues,
+// so it ends at D at best. A domain without a valid DMARC record gets F.
+// Every DMARC issue and every SPF weakness costs one grade.
+func gradeDomain(dmarc dmarcReport, spf spfReport) string {
+	if dmarc.Policy == nil || dmarc.Err != nil {
+		return "F"
+	}
+
+	best := map[string]int{"reject": 0, "quarantine": 1, "none": 2}
+	grade := best[dmarc.Effective()] + len(dmarc.Issues) + len(spfWeaknesses(spf))
+
+	if grade >= len(grades) {
+		grade = len(grades) - 1
+	}
+
+	return grades[grade]
+}
+
+// spfWeaknesses lists what is wrong with an SPF policy from the point of
+// view of spoofing protection.
+func spfWeaknesses(spf spfReport) []string {
+	if spf.Record == "" {
+		return []string{"no SPF record"}
+	}
+
+	if spf.Err != nil {
+		return []string{"SPF record is invalid"}
+	}
+
+	rec, err := parseSPF(spf.Record)
+
+	if err != nil {
+		return []string{"SPF record is invalid"}
+	}
+
+	for _, m := range rec.Mechanisms {
+		if m.Name != "all" {
+			continue
+		}
+
+		switch m.Qualifier {
+		case '+':
+			return []string{"SPF +all allows any sender"}
+		case '?':
+			return []string{"SPF ?all does not reject anyone"}
+		}
+
+		return nil
+	}
+
+	// Without "all" the policy ends in neutral unless a redirect decides.
+	if rec.Redirect == "" || strings.Contains(rec.Redirect, "%") {
+		return []string{"SPF record has no all mechanism"}
+	}
+
+	return nil
+}
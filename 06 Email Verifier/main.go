@@ -1,63 +1,349 @@
 package main
 
 import (
-	"bufio"
-	"fmt"
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
+// domainResult is one output row. Each check has an error field of its
+// own, so a failed lookup is reported next to the domain it belongs to.
+type domainResult struct {
+	Domain    string `json:"domain"`
+	HasMX     bool   `json:"hasMX"`
+	MXError   string `json:"mxError,omitempty"`
+	HasSPF    bool   `json:"hasSPF"`
+	SPFRecord string `json:"spfRecord,omitempty"`
+	// SPFLookups counts the DNS lookups the SPF policy needs in the worst
+	// case; more than ten makes it invalid.
+	SPFLookups int    `json:"spfLookups"`
+	SPFError   string `json:"spfError,omitempty"`
+	// SPFResult is what SPF would say for the -ip address, if one is set.
+	SPFResult      spfResult `json:"spfResult,omitempty"`
+	SPFResultError string    `json:"spfResultError,omitempty"`
+	HasDMARC       bool      `json:"hasDMARC"`
+	DMARCRecord    string    `json:"dmarcRecord,omitempty"`
+	// DMARCPolicy is the policy that applies to the domain, which comes
+	// from sp= when the record is inherited from the parent domain.
+	DMARCPolicy string   `json:"dmarcPolicy,omitempty"`
+	DMARCIssues []string `json:"dmarcIssues,omitempty"`
+	DMARCError  string   `json:"dmarcError,omitempty"`
+	Grade       string   `json:"grade"`
+	// DKIM lists the keys found under the selectors that were tried.
+	DKIM        []dkimKey `json:"dkim,omitempty"`
+	MTASTS      string    `json:"mtaSts,omitempty"`
+	MTASTSError string    `json:"mtaStsError,omitempty"`
+	TLSRPT      string    `json:"tlsRpt,omitempty"`
+	TLSRPTError string    `json:"tlsRptError,omitempty"`
+	BIMI        string    `json:"bimi,omitempty"`
+	BIMIError   string    `json:"bimiError,omitempty"`
+	// Failed is set when a lookup could not be completed, for example
+	// because of a DNS timeout. The other fields may then be incomplete.
+	Failed bool `json:"failed"`
+}
+
+// exitIncomplete is the exit status when at least one check could not be
+// completed. Fatal errors exit with 1 and flag errors with 2.
+const exitIncomplete = 3
+
+type verifier struct {
+	resolver Resolver
+	timeout  time.Duration
+	// spfIP, when set, is evaluated against every domain's SPF policy.
+	spfIP net.IP
+	// selectors are the DKIM selectors looked up for each domain.
+	selectors []string
+	// httpClient fetches MTA-STS policies.
+	httpClient *http.Client
+}
+
 func main() {
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Printf("domain, hasMX, hasSPF, spfRecord, hasDMARC, dmarcRecord\n")
+	workers := flag.Int("workers", 16, "number of domains checked concurrently")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for each DNS lookup")
+	rate := flag.Float64("rate", 0, "maximum number of domains started per second (0 means no limit)")
+	nameservers := flag.String("nameservers", "", "comma-separated nameservers to query directly instead of the system resolver")
+	retries := flag.Int("retries", 2, "extra attempts over the nameserver list when -nameservers is set")
+	ip := flag.String("ip", "", "check whether mail from this IP address would pass each domain's SPF policy")
+	selectors := flag.String("selectors", strings.Join(defaultSelectors, ","), "comma-separated DKIM selectors to look up")
+	mode := flag.String("mode", "domain", "domain or address to check input lines, or serve to run the HTTP API")
+	helo := flag.String("helo", "", "name to send in EHLO in address mode (default: this host's name)")
+	from := flag.String("from", "", "MAIL FROM address in address mode (default: postmaster@<helo>)")
+	smtpTimeout := flag.Duration("smtp-timeout", 15*time.Second, "timeout for each SMTP conversation in address mode")
+	format := flag.String("format", formatCSV, "output format: csv, json or ndjson")
+	listen := flag.String("listen", ":8080", "address the HTTP API listens on in serve mode")
+	clientRate := flag.Float64("client-rate", 10, "domains per second each client may ask for in serve mode (0 means no limit)")
+	maxBatch := flag.Int("max-batch", 100, "most domains in one batch request, and the burst each client may use, in serve mode")
+	cacheTTL := flag.Duration("cache-ttl", time.Hour, "longest time serve mode keeps a result, whatever the DNS TTLs")
+	flag.Parse()
 
-	for scanner.Scan() {
-		checkDomain(scanner.Text())
+	if *workers < 1 {
+		log.Fatalf("Error: -workers must be at least 1")
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("Error: could not read from input: %v\n", err)
+	if *retries < 0 {
+		log.Fatalf("Error: -retries cannot be negative")
+	}
+
+	if *mode != "domain" && *mode != "address" && *mode != "serve" {
+		log.Fatalf("Error: -mode must be domain, address or serve")
+	}
+
+	if *maxBatch < 1 {
+		log.Fatalf("Error: -max-batch must be at least 1")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	v := &verifier{
+		resolver:   net.DefaultResolver,
+		timeout:    *timeout,
+		selectors:  splitList(*selectors),
+		httpClient: newPolicyClient(),
+	}
+
+	if *ip != "" {
+		v.spfIP = net.ParseIP(*ip)
+
+		if v.spfIP == nil {
+			log.Fatalf("Error: -ip %q is not an IP address", *ip)
+		}
+	}
+
+	servers := splitList(*nameservers)
+
+	if *nameservers != "" && len(servers) == 0 {
+		log.Fatalf("Error: -nameservers has no addresses")
+	}
+
+	// Serve mode caches results for as long as their DNS records live,
+	// and only the direct resolver knows the TTLs.
+	if *mode == "serve" && len(servers) == 0 {
+		servers = systemNameservers("/etc/resolv.conf")
+	}
+
+	if len(servers) > 0 {
+
+		// -timeout bounds the whole lookup, so every attempt against a
+		// single server gets an equal share of it.
+		attempts := (*retries + 1) * len(servers)
+
+		v.resolver = newDNSResolver(servers, *timeout/time.Duration(attempts), *retries)
+	}
+
+	if *mode == "serve" {
+		m := newMetrics()
+		v.resolver = meteredResolver{Resolver: v.resolver, metrics: m}
+
+		s := &server{
+			verifier: v,
+			cache:    newResultCache(*cacheTTL, 100000),
+			clients:  newClientLimiter(*clientRate, *maxBatch),
+			metrics:  m,
+			limiter:  newRateLimiter(*rate),
+			workers:  *workers,
+			maxBatch: *maxBatch,
+		}
+
+		log.Printf("Listening on %s", *listen)
+
+		if err := serve(ctx, *listen, s); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+
+		return
+	}
+
+	var out *resultWriter
+	var err error
+
+	if *mode == "address" {
+		p := &prober{
+			resolver: v.bounded(),
+			helo:     *helo,
+			from:     *from,
+			timeout:  *smtpTimeout,
+			port:     "25",
+		}
+
+		if p.helo == "" {
+			p.helo, err = os.Hostname()
+
+			if err != nil {
+				log.Fatalf("Error: -helo is not set and the host name is unknown: %v", err)
+			}
+		}
+
+		if p.from == "" {
+			p.from = "postmaster@" + p.helo
+		}
+
+		if _, _, err := parseAddress(p.from); err != nil {
+			log.Fatalf("Error: -from %q: %v", p.from, err)
+		}
+
+		out, err = newResultWriter(os.Stdout, *format, addressColumns)
+
+		if err != nil {
+			log.Fatalf("Error: -format: %v", err)
+		}
+
+		err = checkAll(ctx, os.Stdin, *workers, newRateLimiter(*rate), p.verifyAddress, func(r addressResult) { out.write(r) })
+	} else {
+		out, err = newResultWriter(os.Stdout, *format, domainColumns)
+
+		if err != nil {
+			log.Fatalf("Error: -format: %v", err)
+		}
+
+		err = checkAll(ctx, os.Stdin, *workers, newRateLimiter(*rate), v.checkDomain, func(r domainResult) { out.write(r) })
 	}
-}
 
-func checkDomain(domain string) {
-	var hasMX, hasSPF, hasDMARC bool
-	var spfRecord, dmarcRecord string
+	// The JSON array is closed even after an interrupt, so the rows that
+	// were written can still be parsed.
+	if closeErr := out.close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
 
-	mxRecords, err := net.LookupMX(domain)
 	if err != nil {
-		log.Printf("Error: %v\n", err)
+		log.Fatalf("Error: %v\n", err)
+	}
+
+	if out.failures > 0 {
+		log.Printf("%d of %d checks could not be completed", out.failures, out.count)
+		os.Exit(exitIncomplete)
+	}
+}
+
+func (v *verifier) checkDomain(ctx context.Context, domain string) domainResult {
+	r := domainResult{Domain: domain}
+
+	mxRecords, err := v.lookupMX(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		r.MXError = err.Error()
+		r.Failed = r.Failed || lookupFailed(err)
 	}
 	if len(mxRecords) > 0 {
-		hasMX = true
+		r.HasMX = true
 	}
 
-	txtRecords, err := net.LookupTXT(domain)
-	if err != nil {
-		log.Printf("Error: %v\n", err)
+	spf := analyzeSPF(ctx, v.bounded(), domain)
+	if spf.Err != nil {
+		r.SPFError = spf.Err.Error()
+		r.Failed = r.Failed || errorResult(spf.Err) == spfTempError
 	}
-	for _, record := range txtRecords {
-		if strings.HasPrefix(record, "v=spf1") {
-			hasSPF = true
-			spfRecord = record
-			break
+	r.HasSPF = spf.Record != ""
+	r.SPFRecord = spf.Record
+	r.SPFLookups = spf.Lookups
+
+	if v.spfIP != nil {
+		r.SPFResult, err = evaluateSPF(ctx, v.bounded(), domain, v.spfIP)
+		if err != nil {
+			r.SPFResultError = err.Error()
+			r.Failed = r.Failed || r.SPFResult == spfTempError
 		}
 	}
 
-	dmarcRecords, err := net.LookupTXT("_dmarc." + domain)
-	if err != nil {
-		log.Printf("Error: %v\n", err)
+	dmarc := checkDMARC(ctx, v.bounded(), domain)
+	if dmarc.Err != nil {
+		r.DMARCError = dmarc.Err.Error()
+		r.Failed = r.Failed || lookupFailed(dmarc.Err)
+	}
+	r.HasDMARC = dmarc.Record != ""
+	r.DMARCRecord = dmarc.Record
+	r.DMARCPolicy = dmarc.Effective()
+	r.DMARCIssues = dmarc.Issues
+	r.Grade = gradeDomain(dmarc, spf)
+
+	r.DKIM = checkDKIM(ctx, v.bounded(), domain, v.selectors)
+
+	for _, key := range r.DKIM {
+		r.Failed = r.Failed || lookupFailed(key.Err)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, v.timeout)
+	mtaSTS := checkMTASTS(fetchCtx, v.bounded(), v.httpClient, domain, mxRecords)
+	cancel()
+
+	if mtaSTS.Err != nil {
+		r.MTASTSError = mtaSTS.Err.Error()
+		r.Failed = r.Failed || lookupFailed(mtaSTS.Err)
+	} else {
+		r.MTASTS = mtaSTS.String()
+	}
+
+	tlsRPT := checkTLSRPT(ctx, v.bounded(), domain)
+
+	if tlsRPT.Err != nil {
+		r.TLSRPTError = tlsRPT.Err.Error()
+		r.Failed = r.Failed || lookupFailed(tlsRPT.Err)
+	} else {
+		r.TLSRPT = tlsRPT.String()
+	}
+
+	bimi := checkBIMI(ctx, v.bounded(), domain, dmarc)
+
+	if bimi.Err != nil {
+		r.BIMIError = bimi.Err.Error()
+		r.Failed = r.Failed || lookupFailed(bimi.Err)
+	} else {
+		r.BIMI = bimi.String()
 	}
-	for _, record := range dmarcRecords {
-		if strings.HasPrefix(record, "v=DMARC1") {
-			hasDMARC = true
-			dmarcRecord = record
-			break
+
+	return r
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// lookupFailed reports whether err means a lookup could not be completed,
+// as opposed to a record that is missing or invalid.
+func lookupFailed(err error) bool {
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
+// lookupMX bounds the query by v.timeout so a single unresponsive
+// nameserver cannot hold a worker indefinitely.
+func (v *verifier) lookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	return v.resolver.LookupMX(ctx, name)
+}
+
+// bounded returns the resolver with v.timeout applied to each lookup, for
+// checks such as SPF that make many queries of their own.
+func (v *verifier) bounded() Resolver {
+	return timeoutResolver{Resolver: v.resolver, timeout: v.timeout}
+}
+
+func splitList(s string) []string {
+	var out []string
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
 		}
 	}
 
-	fmt.Printf("%v, %v, %v, %v, %v, %v\n", domain, hasMX, hasSPF, spfRecord, hasDMARC, dmarcRecord)
-	
+	return out
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckDomain(t *testing.T) {
+	fake := &fakeResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx.example.com.", Pref: 10}},
+		},
+		txt: map[string][]string{
+			"example.com":        {"google-site-verification=abc", "v=spf1 include:_spf.example.net -all"},
+			"_dmarc.example.com": {"v=DMARC1; p=reject"},
+			"nomail.example":     {"hello"},
+		},
+		errs: map[string]error{
+			"broken.example": errors.New("timeout"),
+		},
+	}
+
+	v := &verifier{resolver: fake, timeout: time.Second}
+
+	tests := []struct {
+		domain string
+		want   domainResult
+	}{
+		{
+			domain: "example.com",
+			want: domainResult{
+				Domain:      "example.com",
+				HasMX:       true,
+				HasSPF:      true,
+				SPFRecord:   "v=spf1 include:_spf.example.net -all",
+				HasDMARC:    true,
+				DMARCRecord: "v=DMARC1; p=reject",
+				SPFLookups:  1,
+				SPFError:    "_spf.example.net has no SPF record",
+				DMARCPolicy: "reject",
+				DMARCIssues: []string{"no rua, so no aggregate reports are sent"},
+				Grade:       "C",
+			},
+		},
+		{domain: "nomail.example", want: domainResult{Domain: "nomail.example", Grade: "F"}},
+		{
+			domain: "broken.example",
+			want: domainResult{
+				Domain:   "broken.example",
+				MXError:  "timeout",
+				SPFError: "timeout",
+				Grade:    "F",
+				Failed:   true,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			got := v.checkDomain(context.Background(), tc.domain)
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("checkDomain(%q) = %+v, want %+v", tc.domain, got, tc.want)
+			}
+		})
+	}
+}
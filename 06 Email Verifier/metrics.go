@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the histogram bounds, in seconds, for DNS lookups and
+// whole domain checks.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += seconds
+	h.count++
+}
+
+// metrics collects what serve mode exposes on /metrics, in the Prometheus
+// text format.
+type metrics struct {
+	mu sync.Mutex
+	// lookups and lookupFailures are keyed by record type: MX, TXT or IP.
+	lookups        map[string]*histogram
+	lookupFailures map[string]uint64
+	checks         histogram
+	checkFailures  uint64
+	cacheHits      uint64
+	cacheMisses    uint64
+	rateLimited    uint64
+	// requests is keyed by HTTP status code.
+	requests map[int]uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		lookups:        map[string]*histogram{},
+		lookupFailures: map[string]uint64{},
+		requests:       map[int]uint64{},
+	}
+}
+
+func (m *metrics) observeLookup(qtype string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.lookups[qtype]
+
+	if !ok {
+		h = &histogram{}
+		m.lookups[qtype] = h
+	}
+
+	h.observe(d.Seconds())
+
+	if lookupFailed(err) {
+		m.lookupFailures[qtype]++
+	}
+}
+
+func (m *metrics) observeCheck(d time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.checks.observe(d.Seconds())
+
+	if failed {
+		m.checkFailures++
+	}
+}
+
+func (m *metrics) count(counter *uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	*counter++
+}
+
+func (m *metrics) observeRequest(code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[code]++
+}
+
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP email_verifier_lookup_duration_seconds Time taken by DNS lookups.")
+	fmt.Fprintln(w, "# TYPE email_verifier_lookup_duration_seconds histogram")
+
+	for _, qtype := range sortedKeys(m.lookups) {
+		writeHistogram(w, "email_verifier_lookup_duration_seconds", fmt.Sprintf("type=%q", qtype), m.lookups[qtype])
+	}
+
+	fmt.Fprintln(w, "# HELP email_verifier_lookup_failures_total DNS lookups that could not be completed.")
+	fmt.Fprintln(w, "# TYPE email_verifier_lookup_failures_total counter")
+
+	for _, qtype := range sortedKeys(m.lookups) {
+		fmt.Fprintf(w, "email_verifier_lookup_failures_total{type=%q} %d\n", qtype, m.lookupFailures[qtype])
+	}
+
+	fmt.Fprintln(w, "# HELP email_verifier_check_duration_seconds Time taken to check a domain, for cache misses.")
+	fmt.Fprintln(w, "# TYPE email_verifier_check_duration_seconds histogram")
+	writeHistogram(w, "email_verifier_check_duration_seconds", "", &m.checks)
+
+	counters := []struct {
+		name, help string
+		value      uint64
+	}{
+		{"email_verifier_check_failures_total", "Domain checks with at least one lookup that could not be completed.", m.checkFailures},
+		{"email_verifier_cache_hits_total", "Domain results served from the cache.", m.cacheHits},
+		{"email_verifier_cache_misses_total", "Domain results that had to be looked up.", m.cacheMisses},
+		{"email_verifier_rate_limited_total", "Requests rejected by the per-client rate limit.", m.rateLimited},
+	}
+
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value)
+	}
+
+	fmt.Fprintln(w, "# HELP email_verifier_http_requests_total API requests by status code.")
+	fmt.Fprintln(w, "# TYPE email_verifier_http_requests_total counter")
+
+	codes := make([]int, 0, len(m.requests))
+
+	for code := range m.requests {
+		codes = append(codes, code)
+	}
+
+	sort.Ints(codes)
+
+	for _, code := range codes {
+		fmt.Fprintf(w, "email_verifier_http_requests_total{code=\"%d\"} %d\n", code, m.requests[code])
+	}
+}
+
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	sep := ""
+
+	if labels != "" {
+		sep = ","
+	}
+
+	for i, le := range latencyBuckets {
+		var n uint64
+
+		if h.counts != nil {
+			n = h.counts[i]
+		}
+
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, strconv.FormatFloat(le, 'g', -1, 64), n)
+	}
+
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// meteredResolver records the latency and failures of every lookup.
+type meteredResolver struct {
+	Resolver
+	metrics *metrics
+}
+
+func (r meteredResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	start := time.Now()
+	mxs, err := r.Resolver.LookupMX(ctx, name)
+	r.metrics.observeLookup("MX", time.Since(start), err)
+
+	return mxs, err
+}
+
+func (r meteredResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	start := time.Now()
+	txts, err := r.Resolver.LookupTXT(ctx, name)
+	r.metrics.observeLookup("TXT", time.Since(start), err)
+
+	return txts, err
+}
+
+func (r meteredResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	start := time.Now()
+	ips, err := r.Resolver.LookupIP(ctx, network, host)
+	r.metrics.observeLookup("IP", time.Since(start), err)
+
+	return ips, err
+}
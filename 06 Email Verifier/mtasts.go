@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxPolicySize caps the MTA-STS policy body, as RFC 8461 suggests.
+const maxPolicySize = 64 << 10
+
+// maxPolicyAge is the largest max_age RFC 8461 allows, about a year.
+const maxPolicyAge = 31557600
+
+// newPolicyClient returns the HTTP client used for MTA-STS policies.
+// Policy fetches must not follow redirects.
+func newPolicyClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+type mtaSTSReport struct {
+	// ID is the id= tag of the _mta-sts TXT record.
+	ID     string
+	Mode   string
+	MaxAge int
+	MX     []string
+	Err    error
+}
+
+// checkMTASTS looks for the _mta-sts TXT record and, when there is one,
+// fetches https://mta-sts.<domain>/.well-known/mta-sts.txt and checks that
+// it covers every MX host. A domain without the TXT record gets an empty
+// report.
+func checkMTASTS(ctx context.Context, resolver Resolver, client *http.Client, domain string, mxs []*net.MX) mtaSTSReport {
+	record, err := singleRecord(ctx, resolver, "_mta-sts."+domain, "v=STSv1")
+
+	if record == "" || err != nil {
+		return mtaSTSReport{Err: err}
+	}
+
+	var report mtaSTSReport
+
+	tags, err := parseTagList(record)
+
+	if err != nil {
+		report.Err = fmt.Errorf("TXT record: %w", err)
+		return report
+	}
+
+	report.ID = tags["id"]
+
+	if report.ID == "" {
+		report.Err = errors.New("TXT record has no id")
+		return report
+	}
+
+	policy, err := fetchMTASTSPolicy(ctx, client, domain)
+
+	if err != nil {
+		report.Err = fmt.Errorf("policy: %w", err)
+		return report
+	}
+
+	report.Mode, report.MaxAge, report.MX = policy.Mode, policy.MaxAge, policy.MX
+
+	if report.Mode == "none" {
+		return report
+	}
+
+	for _, mx := range mxs {
+		host := strings.TrimSuffix(mx.Host, ".")
+
+		if host != "" && !policyCoversMX(report.MX, host) {
+			report.Err = fmt.Errorf("MX %s is not listed in the policy", host)
+			return report
+		}
+	}
+
+	return report
+}
+
+func (r mtaSTSReport) String() string {
+	if r.Err != nil {
+		return "error: " + r.Err.Error()
+	}
+
+	return r.Mode
+}
+
+func fetchMTASTSPolicy(ctx context.Context, client *http.Client, domain string) (mtaSTSReport, error) {
+	u := url.URL{Scheme: "https", Host: "mta-sts." + domain, Path: "/.well-known/mta-sts.txt"}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+
+	if err != nil {
+		return mtaSTSReport{}, err
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return mtaSTSReport{}, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return mtaSTSReport{}, fmt.Errorf("%s returned %s", u.String(), resp.Status)
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/plain" {
+		return mtaSTSReport{}, fmt.Errorf("content type is %q, not text/plain", mediaType)
+	}
+
+	return parseMTASTSPolicy(io.LimitReader(resp.Body, maxPolicySize))
+}
+
+// parseMTASTSPolicy reads the "key: value" lines of a policy file.
+func parseMTASTSPolicy(body io.Reader) (mtaSTSReport, error) {
+	var p mtaSTSReport
+
+	scanner := bufio.NewScanner(body)
+	version := ""
+	hasMaxAge := false
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+
+		if !ok {
+			return p, fmt.Errorf("line %q is not key: value", line)
+		}
+
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "version":
+			version = value
+		case "mode":
+			p.Mode = value
+		case "max_age":
+			age, err := strconv.Atoi(value)
+
+			if err != nil || age < 0 || age > maxPolicyAge {
+				return p, fmt.Errorf("max_age %q is invalid", value)
+			}
+
+			p.MaxAge = age
+			hasMaxAge = true
+		case "mx":
+			p.MX = append(p.MX, strings.ToLower(value))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return p, err
+	}
+
+	switch {
+	case version != "STSv1":
+		return p, errors.New("version is not STSv1")
+	case p.Mode != "enforce" && p.Mode != "testing" && p.Mode != "none":
+		return p, fmt.Errorf("mode %q is invalid", p.Mode)
+	case !hasMaxAge:
+		return p, errors.New("max_age is missing")
+	case p.Mode != "none" && len(p.MX) == 0:
+		return p, errors.New("no mx patterns")
+	}
+
+	return p, nil
+}
+
+// policyCoversMX matches a host against mx patterns. A leading "*." stands
+// for exactly one label.
+func policyCoversMX(patterns []string, host string) bool {
+	host = strings.ToLower(host)
+
+	for _, pattern := range patterns {
+		if pattern == host {
+			return true
+		}
+
+		if rest, ok := strings.CutPrefix(pattern, "*."); ok {
+			if label, parent, found := strings.Cut(host, "."); found && label != "" && parent == rest {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+type tlsRPTReport struct {
+	RUA []string
+	Err error
+}
+
+// checkTLSRPT reads the TLS reporting address from _smtp._tls.<domain>.
+func checkTLSRPT(ctx context.Context, resolver Resolver, domain string) tlsRPTReport {
+	record, err := singleRecord(ctx, resolver, "_smtp._tls."+domain, "v=TLSRPTv1")
+
+	if record == "" || err != nil {
+		return tlsRPTReport{Err: err}
+	}
+
+	tags, err := parseTagList(record)
+
+	if err != nil {
+		return tlsRPTReport{Err: err}
+	}
+
+	if tags["rua"] == "" {
+		return tlsRPTReport{Err: errors.New("rua is missing")}
+	}
+
+	var report tlsRPTReport
+
+	for _, uri := range strings.Split(tags["rua"], ",") {
+		uri = strings.TrimSpace(uri)
+		u, err := url.Parse(uri)
+
+		if err != nil || (u.Scheme != "mailto" && u.Scheme != "https") {
+			return tlsRPTReport{Err: fmt.Errorf("rua %q must be a mailto: or https: URI", uri)}
+		}
+
+		report.RUA = append(report.RUA, uri)
+	}
+
+	return report
+}
+
+func (r tlsRPTReport) String() string {
+	if r.Err != nil {
+		return "error: " + r.Err.Error()
+	}
+
+	return strings.Join(r.RUA, " ")
+}
+
+// singleRecord returns the one TXT record at name that starts with the
+// given version tag, "" if there is none, or an error if there are more.
+func singleRecord(ctx context.Context, resolver Resolver, name, version string) (string, error) {
+	txts, err := resolver.LookupTXT(ctx, name)
+
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	var found []string
+
+	for _, txt := range txts {
+		v, _, _ := strings.Cut(txt, ";")
+
+		if strings.TrimSpace(v) == version {
+			found = append(found, txt)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return found[0], nil
+	}
+
+	return "", fmt.Errorf("multiple %s records", version)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// policyServer serves MTA-STS policies by Host header. The returned client
+// sends every request to it over plain HTTP, so the code under test can
+// keep using https://mta-sts.<domain>/ URLs.
+func policyServer(t *testing.T, handler http.HandlerFunc) *http.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	target, _ := url.Parse(srv.URL)
+
+	client := newPolicyClient()
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.Host = req.URL.Host
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	return client
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestCheckMTASTS(t *testing.T) {
+	policies := map[string]string{
+		"mta-sts.example.com":  "version: STSv1\nmode: enforce\nmx: mx1.example.com\nmx: *.mail.example.com\nmax_age: 86400\n",
+		"mta-sts.testing.test": "version: STSv1\r\nmode: testing\r\nmx: mx.testing.test\r\nmax_age: 600\r\n",
+		"mta-sts.partial.test": "version: STSv1\nmode: enforce\nmx: mx1.partial.test\nmax_age: 600\n",
+		"mta-sts.badmode.test": "version: STSv1\nmode: strict\nmx: mx.badmode.test\nmax_age: 600\n",
+		"mta-sts.noage.test":   "version: STSv1\nmode: enforce\nmx: mx.noage.test\n",
+	}
+
+	client := policyServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/.well-known/mta-sts.txt":
+			http.NotFound(w, r)
+		case r.Host == "mta-sts.redirect.test":
+			http.Redirect(w, r, "https://elsewhere.test/policy", http.StatusFound)
+		case r.Host == "mta-sts.html.test":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("version: STSv1\n"))
+		case policies[r.Host] != "":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(policies[r.Host]))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	r := &fakeResolver{txt: map[string][]string{}}
+
+	for _, domain := range []string{"example.com", "testing.test", "partial.test", "badmode.test", "noage.test", "redirect.test", "html.test", "nopolicy.test"} {
+		r.txt["_mta-sts."+domain] = []string{"v=STSv1; id=20240101T000000"}
+	}
+
+	r.txt["_mta-sts.noid.test"] = []string{"v=STSv1;"}
+	r.txt["_mta-sts.twice.test"] = []string{"v=STSv1; id=1", "v=STSv1; id=2"}
+
+	mx := func(hosts ...string) []*net.MX {
+		var out []*net.MX
+
+		for _, h := range hosts {
+			out = append(out, &net.MX{Host: h + "."})
+		}
+
+		return out
+	}
+
+	tests := []struct {
+		domain  string
+		mx      []*net.MX
+		want    string
+		wantErr string
+	}{
+		{domain: "example.com", mx: mx("mx1.example.com", "a.mail.example.com"), want: "enforce"},
+		{domain: "testing.test", mx: mx("mx.testing.test"), want: "testing"},
+		{domain: "none.test", want: ""},
+		{domain: "partial.test", mx: mx("mx1.partial.test", "mx2.partial.test"), wantErr: "MX mx2.partial.test is not listed"},
+		{domain: "example.com", mx: mx("a.b.mail.example.com"), wantErr: "not listed"},
+		{domain: "badmode.test", wantErr: `mode "strict" is invalid`},
+		{domain: "noage.test", wantErr: "max_age is missing"},
+		{domain: "redirect.test", wantErr: "302 Found"},
+		{domain: "html.test", wantErr: "not text/plain"},
+		{domain: "nopolicy.test", wantErr: "404 Not Found"},
+		{domain: "noid.test", wantErr: "TXT record has no id"},
+		{domain: "twice.test", wantErr: "multiple v=STSv1 records"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			report := checkMTASTS(context.Background(), r, client, tc.domain, tc.mx)
+
+			if tc.wantErr != "" {
+				if report.Err == nil || !strings.Contains(report.Err.Error(), tc.wantErr) {
+					t.Fatalf("Err = %v, want it to mention %q", report.Err, tc.wantErr)
+				}
+
+				return
+			}
+
+			if report.Err != nil || report.String() != tc.want {
+				t.Fatalf("report = %q (%v), want %q", report.String(), report.Err, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckTLSRPT(t *testing.T) {
+	r := &fakeResolver{txt: map[string][]string{
+		"_smtp._tls.example.com": {"v=TLSRPTv1; rua=mailto:tls@example.com,https://reports.example.com/tls"},
+		"_smtp._tls.norua.test":  {"v=TLSRPTv1;"},
+		"_smtp._tls.ftp.test":    {"v=TLSRPTv1; rua=ftp://example.com"},
+		"_smtp._tls.other.test":  {"something else"},
+	}}
+
+	tests := map[string]string{
+		"example.com":  "mailto:tls@example.com https://reports.example.com/tls",
+		"norua.test":   "error: rua is missing",
+		"ftp.test":     `error: rua "ftp://example.com" must be a mailto: or https: URI`,
+		"other.test":   "",
+		"missing.test": "",
+	}
+
+	for domain, want := range tests {
+		if got := checkTLSRPT(context.Background(), r, domain).String(); got != want {
+			t.Errorf("checkTLSRPT(%s) = %q, want %q", domain, got, want)
+		}
+	}
+}
+
+func TestCheckBIMI(t *testing.T) {
+	r := &fakeResolver{txt: map[string][]string{
+		"default._bimi.example.com":   {"v=BIMI1; l=https://example.com/logo.svg; a=https://example.com/vmc.pem"},
+		"default._bimi.declined.test": {"v=BIMI1; l=;"},
+		"default._bimi.http.test":     {"v=BIMI1; l=http://example.com/logo.svg"},
+		"default._bimi.png.test":      {"v=BIMI1; l=https://example.com/logo.png"},
+	}}
+
+	enforced := dmarcReport{Policy: &dmarcPolicy{P: "reject", SP: "reject", Pct: 100}}
+	monitoring := dmarcReport{Policy: &dmarcPolicy{P: "none", SP: "none", Pct: 100}}
+	sampled := dmarcReport{Policy: &dmarcPolicy{P: "quarantine", SP: "quarantine", Pct: 50}}
+
+	tests := []struct {
+		domain string
+		dmarc  dmarcReport
+		want   string
+	}{
+		{"example.com", enforced, "https://example.com/logo.svg"},
+		{"example.com", monitoring, "error: BIMI requires a DMARC policy of quarantine or reject at pct=100"},
+		{"example.com", sampled, "error: BIMI requires a DMARC policy of quarantine or reject at pct=100"},
+		{"example.com", dmarcReport{}, "error: BIMI requires a DMARC policy of quarantine or reject at pct=100"},
+		{"declined.test", monitoring, "declined"},
+		{"http.test", enforced, `error: "http://example.com/logo.svg" is not an https URL`},
+		{"png.test", enforced, "error: logo must be an SVG file"},
+		{"missing.test", enforced, ""},
+	}
+
+	for _, tc := range tests {
+		if got := checkBIMI(context.Background(), r, tc.domain, tc.dmarc).String(); got != tc.want {
+			t.Errorf("checkBIMI(%s, p=%s) = %q, want %q", tc.domain, tc.dmarc.Effective(), got, tc.want)
+		}
+	}
+}
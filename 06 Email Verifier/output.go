@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Output formats accepted by -format.
+const (
+	formatCSV    = "csv"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+// record is a result that can be written as one CSV row.
+type record interface {
+	csvRow() []string
+	// failed reports whether the checks behind the result could not be
+	// completed.
+	failed() bool
+}
+
+var domainColumns = []string{
+	"domain", "hasMX", "hasSPF", "spfRecord", "hasDMARC", "dmarcRecord", "spfLookups", "spfError", "spfResult",
+	"dmarcPolicy", "dmarcIssues", "grade", "dkim", "mtaSts", "tlsRpt", "bimi",
+	"mxError", "spfResultError", "dmarcError", "mtaStsError", "tlsRptError", "bimiError", "failed",
+}
+
+func (r domainResult) csvRow() []string {
+	dkim := make([]string, len(r.DKIM))
+
+	for i, key := range r.DKIM {
+		dkim[i] = key.String()
+	}
+
+	return []string{
+		r.Domain, strconv.FormatBool(r.HasMX), strconv.FormatBool(r.HasSPF), r.SPFRecord, strconv.FormatBool(r.HasDMARC),
+		r.DMARCRecord, strconv.Itoa(r.SPFLookups), r.SPFError, string(r.SPFResult),
+		r.DMARCPolicy, strings.Join(r.DMARCIssues, "; "), r.Grade, strings.Join(dkim, "; "), r.MTASTS, r.TLSRPT, r.BIMI,
+		r.MXError, r.SPFResultError, r.DMARCError, r.MTASTSError, r.TLSRPTError, r.BIMIError, strconv.FormatBool(r.Failed),
+	}
+}
+
+func (r domainResult) failed() bool {
+	return r.Failed
+}
+
+var addressColumns = []string{"address", "status", "reason", "mx", "code", "catchAll"}
+
+func (r addressResult) csvRow() []string {
+	return []string{r.Address, r.Status, r.Reason, r.MX, strconv.Itoa(r.Code), strconv.FormatBool(r.CatchAll)}
+}
+
+func (r addressResult) failed() bool {
+	return r.Status == statusUnknown
+}
+
+// resultWriter writes results as CSV with a header row, as a single JSON
+// array, or as newline-delimited JSON. Every result is written as soon as
+// it arrives so output can be piped into other tools while a long run is
+// still going.
+type resultWriter struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+	count  int
+	// failures counts the results whose checks could not be completed.
+	failures int
+	err      error
+}
+
+func newResultWriter(w io.Writer, format string, columns []string) (*resultWriter, error) {
+	rw := &resultWriter{w: w, format: format}
+
+	switch format {
+	case formatCSV:
+		rw.csv = csv.NewWriter(w)
+		rw.csv.Write(columns)
+		rw.csv.Flush()
+		rw.err = rw.csv.Error()
+	case formatJSON:
+		_, rw.err = io.WriteString(w, "[")
+	case formatNDJSON:
+	default:
+		return nil, fmt.Errorf("unknown format %q (want csv, json or ndjson)", format)
+	}
+
+	return rw, nil
+}
+
+// write adds r to the output. After the first error nothing more is
+// written; the error is returned by close.
+func (rw *resultWriter) write(r record) {
+	if r.failed() {
+		rw.failures++
+	}
+
+	if rw.err != nil {
+		return
+	}
+
+	switch rw.format {
+	case formatCSV:
+		rw.csv.Write(r.csvRow())
+		rw.csv.Flush()
+		rw.err = rw.csv.Error()
+	case formatJSON:
+		sep := ",\n  "
+
+		if rw.count == 0 {
+			sep = "\n  "
+		}
+
+		b, err := json.MarshalIndent(r, "  ", "  ")
+
+		if err != nil {
+			rw.err = err
+			return
+		}
+
+		_, rw.err = fmt.Fprintf(rw.w, "%s%s", sep, b)
+	case formatNDJSON:
+		b, err := json.Marshal(r)
+
+		if err != nil {
+			rw.err = err
+			return
+		}
+
+		_, rw.err = fmt.Fprintf(rw.w, "%s\n", b)
+	}
+
+	rw.count++
+}
+
+// close finishes the JSON array and returns the first write error.
+func (rw *resultWriter) close() error {
+	if rw.err != nil || rw.format != formatJSON {
+		return rw.err
+	}
+
+	end := "\n]\n"
+
+	if rw.count == 0 {
+		end = "]\n"
+	}
+
+	_, rw.err = io.WriteString(rw.w, end)
+
+	return rw.err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sampleResults() []domainResult {
+	return []domainResult{
+		{
+			Domain:      "example.com",
+			HasMX:       true,
+			HasSPF:      true,
+			SPFRecord:   `v=spf1 include:_spf.example.net exp=%{d},"quoted" -all`,
+			HasDMARC:    true,
+			DMARCRecord: "v=DMARC1; p=reject",
+			DMARCPolicy: "reject",
+			DMARCIssues: []string{"pct=50 applies the policy to only part of the mail", "no rua, so no aggregate reports are sent"},
+			Grade:       "C",
+			DKIM: []dkimKey{
+				{Selector: "s1", KeyType: "rsa", Bits: 512},
+				{Selector: "s2", Err: errors.New("key is not valid base64")},
+			},
+		},
+		{Domain: "broken.example", MXError: "lookup broken.example: i/o timeout", Grade: "F", Failed: true},
+	}
+}
+
+func TestResultWriterCSV(t *testing.T) {
+	var buf bytes.Buffer
+
+	rw, err := newResultWriter(&buf, formatCSV, domainColumns)
+
+	if err != nil {
+		t.Fatalf("newResultWriter: %v", err)
+	}
+
+	for _, r := range sampleResults() {
+		rw.write(r)
+	}
+
+	if err := rw.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v\n%s", err, buf.String())
+	}
+
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], domainColumns) {
+		t.Fatalf("rows = %q", rows)
+	}
+
+	for _, row := range rows[1:] {
+		if len(row) != len(domainColumns) {
+			t.Fatalf("row has %d fields, want %d: %q", len(row), len(domainColumns), row)
+		}
+	}
+
+	got := map[string]string{}
+
+	for i, col := range domainColumns {
+		got[col] = rows[1][i]
+	}
+
+	if got["spfRecord"] != sampleResults()[0].SPFRecord {
+		t.Errorf("spfRecord = %q, commas and quotes were not preserved", got["spfRecord"])
+	}
+
+	if got["dkim"] != "s1 rsa 512 (weak); s2 error: key is not valid base64" {
+		t.Errorf("dkim = %q", got["dkim"])
+	}
+
+	if rows[2][len(rows[2])-1] != "true" || rw.failures != 1 {
+		t.Errorf("failed column = %q, failures = %d", rows[2][len(rows[2])-1], rw.failures)
+	}
+}
+
+func TestResultWriterJSON(t *testing.T) {
+	for _, n := range []int{0, 1, 2} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			var buf bytes.Buffer
+
+			rw, _ := newResultWriter(&buf, formatJSON, domainColumns)
+
+			for _, r := range sampleResults()[:n] {
+				rw.write(r)
+			}
+
+			if err := rw.close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			var got []map[string]interface{}
+
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("output is not a JSON array: %v\n%s", err, buf.String())
+			}
+
+			if len(got) != n {
+				t.Fatalf("got %d results, want %d", len(got), n)
+			}
+		})
+	}
+}
+
+func TestResultWriterNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	rw, _ := newResultWriter(&buf, formatNDJSON, domainColumns)
+
+	for _, r := range sampleResults() {
+		rw.write(r)
+	}
+
+	rw.close()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), buf.String())
+	}
+
+	var first struct {
+		Domain string `json:"domain"`
+		DKIM   []struct {
+			Selector string `json:"selector"`
+			Weak     bool   `json:"weak"`
+			Error    string `json:"error"`
+		} `json:"dkim"`
+	}
+
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("line 1: %v", err)
+	}
+
+	if first.Domain != "example.com" || len(first.DKIM) != 2 || !first.DKIM[0].Weak || first.DKIM[1].Error != "key is not valid base64" {
+		t.Fatalf("first result = %+v", first)
+	}
+
+	var second map[string]interface{}
+
+	if err := json.Unmarshal([]byte(lines[1]), &second); err != nil {
+		t.Fatalf("line 2: %v", err)
+	}
+
+	if second["mxError"] != "lookup broken.example: i/o timeout" || second["failed"] != true {
+		t.Fatalf("second result = %v", second)
+	}
+}
+
+func TestResultWriterUnknownFormat(t *testing.T) {
+	if _, err := newResultWriter(&bytes.Buffer{}, "xml", domainColumns); err == nil {
+		t.Fatal("unknown format was accepted")
+	}
+}
+
+func TestLookupFailed(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("multiple key records"), false},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&net.DNSError{Err: "i/o timeout", IsTimeout: true}, true},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+		{fmt.Errorf("TXT record: %w", context.DeadlineExceeded), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+	}
+
+	for _, tc := range tests {
+		if got := lookupFailed(tc.err); got != tc.want {
+			t.Errorf("lookupFailed(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type job struct {
+	index  int
+	domain string
+}
+
+type jobResult[T any] struct {
+	index  int
+	result T
+}
+
+// checkAll reads one domain (or address) per line from in and runs check
+// on them with the given number of workers. Results are passed to emit in
+// input order.
+//
+// At most workers*4 domains are in flight or waiting to be emitted, so a
+// slow lookup at the head of the input cannot make memory grow with the
+// size of the input. When ctx is cancelled no new domains are started,
+// results already in order are still emitted and ctx.Err() is returned.
+// The limiter is stopped when checkAll returns.
+func checkAll[T any](ctx context.Context, in io.Reader, workers int, limiter *rateLimiter, check func(context.Context, string) T, emit func(T)) error {
+	jobs := make(chan job)
+	results := make(chan jobResult[T])
+	window := make(chan struct{}, workers*4)
+
+	defer limiter.Stop()
+
+	var readErr error
+
+	go func() {
+		defer close(jobs)
+
+		scanner := bufio.NewScanner(in)
+		index := 0
+
+		for scanner.Scan() {
+			domain := strings.TrimSpace(scanner.Text())
+
+			if domain == "" {
+				continue
+			}
+
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- job{index: index, domain: domain}:
+				index++
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		readErr = scanner.Err()
+	}()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range jobs {
+				if err := limiter.Wait(ctx); err != nil {
+					continue
+				}
+
+				results <- jobResult[T]{index: j.index, result: check(ctx, j.domain)}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := map[int]T{}
+	next := 0
+
+	for r := range results {
+		pending[r.index] = r.result
+
+		for {
+			res, ok := pending[next]
+
+			if !ok {
+				break
+			}
+
+			if ctx.Err() == nil {
+				emit(res)
+			}
+
+			delete(pending, next)
+			next++
+			<-window
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return readErr
+}
+
+// rateLimiter hands out at most one start per interval. A nil limiter
+// never waits.
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	interval := time.Duration(float64(time.Second) / perSecond)
+
+	// From a billion a second the interval rounds down to zero, which
+	// time.NewTicker panics on. One tick a nanosecond is no limit anyway.
+	if interval < time.Nanosecond {
+		interval = time.Nanosecond
+	}
+
+	return &rateLimiter{ticker: time.NewTicker(interval)}
+}
+
+// Stop releases the ticker. The limiter must not be used afterwards.
+func (l *rateLimiter) Stop() {
+	if l != nil {
+		l.ticker.Stop()
+	}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// domains returns n numbered domains, one per line.
+func domains(n int) (string, []string) {
+	var list []string
+
+	for i := 0; i < n; i++ {
+		list = append(list, fmt.Sprintf("d%02d.example", i))
+	}
+
+	return strings.Join(list, "\n") + "\n", list
+}
+
+func TestCheckAllKeepsInputOrder(t *testing.T) {
+	const n = 20
+
+	input, want := domains(n)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+
+	// Earlier domains take longer, so workers finish them last.
+	check := func(ctx context.Context, domain string) domainResult {
+		var index int
+		fmt.Sscanf(domain, "d%d.example", &index)
+
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+
+		time.Sleep(time.Duration(n-index) * 2 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		return domainResult{Domain: domain}
+	}
+
+	var got []string
+
+	err := checkAll(context.Background(), strings.NewReader(input+"\n  \n"), 8, nil, check, func(r domainResult) {
+		got = append(got, r.Domain)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("emitted %v, want %v", got, want)
+	}
+
+	if maxInFlight < 2 {
+		t.Errorf("at most %d checks ran at once, want them to overlap", maxInFlight)
+	}
+}
+
+func TestCheckAllCancel(t *testing.T) {
+	input, want := domains(1000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	started := 0
+
+	check := func(ctx context.Context, domain string) domainResult {
+		mu.Lock()
+		started++
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		return domainResult{Domain: domain}
+	}
+
+	var got []string
+
+	done := make(chan error)
+
+	go func() {
+		done <- checkAll(ctx, strings.NewReader(input), 4, nil, check, func(r domainResult) {
+			got = append(got, r.Domain)
+
+			if len(got) == 5 {
+				cancel()
+			}
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("checkAll = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("checkAll did not return after cancel")
+	}
+
+	if !reflect.DeepEqual(got, want[:5]) {
+		t.Errorf("emitted %v, want the first 5 domains", got)
+	}
+
+	// Only the window of domains in flight when cancel was called may
+	// still have started.
+	if started > 5+4*4 {
+		t.Errorf("started %d checks after cancelling at 5", started)
+	}
+}
+
+func TestCheckAllRateLimit(t *testing.T) {
+	const (
+		n        = 6
+		interval = 20 * time.Millisecond
+	)
+
+	input, _ := domains(n)
+
+	var mu sync.Mutex
+	var starts []time.Time
+
+	check := func(ctx context.Context, domain string) domainResult {
+		mu.Lock()
+		starts = append(starts, time.Now())
+		mu.Unlock()
+
+		return domainResult{Domain: domain}
+	}
+
+	begin := time.Now()
+
+	err := checkAll(context.Background(), strings.NewReader(input), 4, newRateLimiter(float64(time.Second/interval)), check, func(domainResult) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(starts) != n {
+		t.Fatalf("started %d checks, want %d", len(starts), n)
+	}
+
+	// Ticks may be delivered late but never early, and each start takes
+	// its own tick, so n starts need at least n intervals.
+	if elapsed := time.Since(begin); elapsed < n*interval-interval/2 {
+		t.Errorf("%d checks took %s, want at least %s", n, elapsed, n*interval)
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	if l := newRateLimiter(0); l != nil {
+		t.Fatalf("newRateLimiter(0) = %+v, want nil", l)
+	}
+
+	var l *rateLimiter
+
+	if err := l.Wait(context.Background()); err != nil {
+		t.Errorf("nil limiter Wait = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := newRateLimiter(1).Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait after cancel = %v, want context.Canceled", err)
+	}
+}
+
+func TestRateLimiterHighRate(t *testing.T) {
+	for _, rate := range []float64{1e9, 1e12, math.Inf(1)} {
+		l := newRateLimiter(rate)
+
+		if err := l.Wait(context.Background()); err != nil {
+			t.Errorf("newRateLimiter(%g).Wait = %v", rate, err)
+		}
+
+		l.Stop()
+	}
+
+	var l *rateLimiter
+	l.Stop()
+}
+
+func TestCheckAllStopsLimiter(t *testing.T) {
+	l := newRateLimiter(1e9)
+
+	err := checkAll(context.Background(), strings.NewReader("a.example\nb.example\n"), 2, l, func(ctx context.Context, domain string) domainResult {
+		return domainResult{Domain: domain}
+	}, func(domainResult) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tick sent before the ticker was stopped may still be buffered.
+	select {
+	case <-l.ticker.C:
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait after checkAll = %v, want the ticker stopped", err)
+	}
+}
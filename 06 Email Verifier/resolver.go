@@ -0,0 +1,589 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolver is the set of DNS lookups the verifier needs. *net.Resolver
+// implements it, so net.DefaultResolver is the system implementation.
+// Lookups that find no records return a *net.DNSError with IsNotFound set,
+// whichever implementation is used.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+var _ Resolver = net.DefaultResolver
+
+// timeoutResolver bounds each lookup made through it by timeout.
+type timeoutResolver struct {
+	Resolver
+	timeout time.Duration
+}
+
+func (r timeoutResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.Resolver.LookupMX(ctx, name)
+}
+
+func (r timeoutResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.Resolver.LookupTXT(ctx, name)
+}
+
+func (r timeoutResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.Resolver.LookupIP(ctx, network, host)
+}
+
+// maxUDPSize is the EDNS0 payload size advertised to servers. 1232 bytes
+// avoids IP fragmentation on practically every path.
+const maxUDPSize = 1232
+
+// negativeTTL is how long NXDOMAIN and empty answers are cached when the
+// server does not send an SOA record to take the TTL from.
+const negativeTTL = time.Minute
+
+// maxDNSCacheEntries bounds the answers a resolver keeps, so that a
+// long-running server does not grow with every name it has looked up.
+const maxDNSCacheEntries = 100000
+
+// dnsResolver talks to chosen nameservers directly over UDP, falling back
+// to TCP when a reply is truncated. Each server is tried in turn; the whole
+// list is retried up to retries more times before giving up. Answers,
+// including negative ones, are cached for their TTL.
+type dnsResolver struct {
+	servers []string
+	timeout time.Duration
+	retries int
+	dialer  net.Dialer
+	cache   *dnsCache
+}
+
+func newDNSResolver(servers []string, timeout time.Duration, retries int) *dnsResolver {
+	addrs := make([]string, len(servers))
+
+	for i, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+
+		addrs[i] = s
+	}
+
+	return &dnsResolver{
+		servers: addrs,
+		timeout: timeout,
+		retries: retries,
+		cache:   newDNSCache(maxDNSCacheEntries),
+	}
+}
+
+func (r *dnsResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	answers, err := r.query(ctx, name, dnsmessage.TypeMX)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var mx []*net.MX
+
+	for _, a := range answers {
+		if rec, ok := a.Body.(*dnsmessage.MXResource); ok {
+			mx = append(mx, &net.MX{Host: rec.MX.String(), Pref: rec.Pref})
+		}
+	}
+
+	return mx, nil
+}
+
+func (r *dnsResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	answers, err := r.query(ctx, name, dnsmessage.TypeTXT)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var txt []string
+
+	for _, a := range answers {
+		if rec, ok := a.Body.(*dnsmessage.TXTResource); ok {
+			// Like net.LookupTXT, the character-strings of one record
+			// are joined without a separator.
+			txt = append(txt, strings.Join(rec.TXT, ""))
+		}
+	}
+
+	return txt, nil
+}
+
+func (r *dnsResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	var types []dnsmessage.Type
+
+	switch network {
+	case "ip4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+
+	var ips []net.IP
+	var lastErr error
+
+	for _, t := range types {
+		answers, err := r.query(ctx, host, t)
+
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		for _, a := range answers {
+			switch rec := a.Body.(type) {
+			case *dnsmessage.AResource:
+				ips = append(ips, net.IP(rec.A[:]))
+			case *dnsmessage.AAAAResource:
+				ips = append(ips, net.IP(rec.AAAA[:]))
+			}
+		}
+	}
+
+	if len(ips) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	return ips, nil
+}
+
+func (r *dnsResolver) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	fqdn := name
+
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+
+	key := cacheKey{name: strings.ToLower(fqdn), qtype: qtype}
+
+	if answers, err, ttl, ok := r.cache.get(key); ok {
+		recordTTL(ctx, ttl)
+		return answers, err
+	}
+
+	q, err := dnsmessage.NewName(fqdn)
+
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: name}
+	}
+
+	question := dnsmessage.Question{Name: q, Type: qtype, Class: dnsmessage.ClassINET}
+
+	var lastErr error
+
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		for _, server := range r.servers {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			msg, err := r.exchange(ctx, server, question)
+
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			answers, ttl, err := r.interpret(msg, name, server, qtype)
+
+			var dnsErr *net.DNSError
+
+			if err != nil && errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+				lastErr = err
+				continue
+			}
+
+			r.cache.put(key, answers, err, ttl)
+			recordTTL(ctx, ttl)
+
+			return answers, err
+		}
+	}
+
+	return nil, lastErr
+}
+
+// exchange sends one query over UDP and repeats it over TCP if the answer
+// comes back truncated.
+func (r *dnsResolver) exchange(ctx context.Context, server string, question dnsmessage.Question) (*dnsmessage.Message, error) {
+	msg, err := r.exchangeOver(ctx, "udp", server, question)
+
+	if err == nil && msg.Truncated {
+		return r.exchangeOver(ctx, "tcp", server, question)
+	}
+
+	return msg, err
+}
+
+func (r *dnsResolver) exchangeOver(ctx context.Context, network, server string, question dnsmessage.Question) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	id, err := randomID()
+
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := buildQuery(id, question)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := r.dialer.DialContext(ctx, network, server)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var reply []byte
+
+	if network == "tcp" {
+		reply, err = roundTripTCP(conn, query)
+	} else {
+		reply, err = roundTripUDP(conn, query, id, question)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var msg dnsmessage.Message
+
+	if err := msg.Unpack(reply); err != nil {
+		return nil, err
+	}
+
+	if msg.ID != id || !msg.Response || len(msg.Questions) != 1 || !sameQuestion(msg.Questions[0], question) {
+		return nil, fmt.Errorf("dns: mismatched reply from %s", server)
+	}
+
+	return &msg, nil
+}
+
+func roundTripUDP(conn net.Conn, query []byte, id uint16, question dnsmessage.Question) ([]byte, error) {
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, maxUDPSize)
+
+	// Stray datagrams (late replies to earlier queries, spoofing
+	// attempts) are skipped until the deadline.
+	for {
+		n, err := conn.Read(buf)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var p dnsmessage.Parser
+
+		h, err := p.Start(buf[:n])
+
+		if err != nil || h.ID != id {
+			continue
+		}
+
+		q, err := p.Question()
+
+		if err != nil || !sameQuestion(q, question) {
+			continue
+		}
+
+		return append([]byte(nil), buf[:n]...), nil
+	}
+}
+
+func roundTripTCP(conn net.Conn, query []byte) ([]byte, error) {
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+
+	var size [2]byte
+
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, binary.BigEndian.Uint16(size[:]))
+
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+// interpret turns a reply into answers or a *net.DNSError and works out
+// how long the outcome may be cached.
+func (r *dnsResolver) interpret(msg *dnsmessage.Message, name, server string, qtype dnsmessage.Type) ([]dnsmessage.Resource, time.Duration, error) {
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, negativeCacheTTL(msg), &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+	default:
+		return nil, 0, &net.DNSError{Err: "server failure: " + msg.RCode.String(), Name: name, Server: server, IsTemporary: true}
+	}
+
+	var answers []dnsmessage.Resource
+	var ttl uint32
+
+	// CNAME records in the answer section are followed implicitly: every
+	// record of the requested type is returned, whatever its owner name.
+	for _, a := range msg.Answers {
+		if a.Header.Type != qtype {
+			continue
+		}
+
+		if len(answers) == 0 || a.Header.TTL < ttl {
+			ttl = a.Header.TTL
+		}
+
+		answers = append(answers, a)
+	}
+
+	if len(answers) == 0 {
+		return nil, negativeCacheTTL(msg), &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+	}
+
+	return answers, time.Duration(ttl) * time.Second, nil
+}
+
+// negativeCacheTTL follows RFC 2308: the SOA in the authority section
+// bounds how long a negative answer may be cached.
+func negativeCacheTTL(msg *dnsmessage.Message) time.Duration {
+	for _, a := range msg.Authorities {
+		if soa, ok := a.Body.(*dnsmessage.SOAResource); ok {
+			ttl := a.Header.TTL
+
+			if soa.MinTTL < ttl {
+				ttl = soa.MinTTL
+			}
+
+			return time.Duration(ttl) * time.Second
+		}
+	}
+
+	return negativeTTL
+}
+
+func buildQuery(id uint16, question dnsmessage.Question) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+
+	if err := b.Question(question); err != nil {
+		return nil, err
+	}
+
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+
+	var opt dnsmessage.ResourceHeader
+
+	if err := opt.SetEDNS0(maxUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+func sameQuestion(a, b dnsmessage.Question) bool {
+	return a.Type == b.Type && a.Class == b.Class && strings.EqualFold(a.Name.String(), b.Name.String())
+}
+
+func randomID() (uint16, error) {
+	var b [2]byte
+
+	if _, err := rand.Read(b[:]); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(b[:]), nil
+}
+
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+}
+
+type cacheEntry struct {
+	answers []dnsmessage.Resource
+	err     error
+	expires time.Time
+}
+
+// dnsCache holds answers until their TTL runs out. Expired entries are
+// dropped when they are next read, and all of them whenever the cache
+// reaches maxEntries. A full cache of live answers takes no new ones.
+type dnsCache struct {
+	mu         sync.Mutex
+	entries    map[cacheKey]cacheEntry
+	maxEntries int
+	now        func() time.Time
+}
+
+func newDNSCache(maxEntries int) *dnsCache {
+	return &dnsCache{
+		entries:    map[cacheKey]cacheEntry{},
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// get returns the cached answer for key and how much longer it is valid.
+func (c *dnsCache) get(key cacheKey) ([]dnsmessage.Resource, error, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+
+	if !ok {
+		return nil, nil, 0, false
+	}
+
+	ttl := e.expires.Sub(c.now())
+
+	if ttl <= 0 {
+		delete(c.entries, key)
+		return nil, nil, 0, false
+	}
+
+	return e.answers, e.err, ttl, true
+}
+
+func (c *dnsCache) put(key cacheKey, answers []dnsmessage.Resource, err error, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+
+	c.entries[key] = cacheEntry{answers: answers, err: err, expires: now.Add(ttl)}
+}
+
+type ttlKey struct{}
+
+// ttlRecorder keeps the shortest TTL of the answers returned to lookups
+// made with its context, so that a result built from them can be cached
+// for as long as all of them stay valid. Only dnsResolver knows TTLs; with
+// the system resolver nothing is recorded.
+type ttlRecorder struct {
+	mu   sync.Mutex
+	min  time.Duration
+	seen bool
+}
+
+func withTTLRecorder(ctx context.Context) (context.Context, *ttlRecorder) {
+	rec := &ttlRecorder{}
+
+	return context.WithValue(ctx, ttlKey{}, rec), rec
+}
+
+func recordTTL(ctx context.Context, ttl time.Duration) {
+	rec, ok := ctx.Value(ttlKey{}).(*ttlRecorder)
+
+	if !ok {
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if !rec.seen || ttl < rec.min {
+		rec.min, rec.seen = ttl, true
+	}
+}
+
+// TTL returns the shortest TTL recorded, and false if there was none.
+func (rec *ttlRecorder) TTL() (time.Duration, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.min, rec.seen
+}
+
+// systemNameservers returns the nameserver lines of a resolv.conf file.
+func systemNameservers(path string) []string {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil
+	}
+
+	var servers []string
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+
+	return servers
+}
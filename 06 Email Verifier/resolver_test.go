@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeResolver answers from fixed tables. Names missing from a table are
+// reported as not found, the way both real resolvers do.
+type fakeResolver struct {
+	mx  map[string][]*net.MX
+	txt map[string][]string
+	ip  map[string][]net.IP
+	// errs makes every lookup of a name fail with the given error.
+	errs map[string]error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *fakeResolver) record(kind, name string) error {
+	f.mu.Lock()
+	f.calls = append(f.calls, kind+" "+name)
+	f.mu.Unlock()
+
+	return f.errs[strings.ToLower(name)]
+}
+
+func (f *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if err := f.record("MX", name); err != nil {
+		return nil, err
+	}
+
+	if mx, ok := f.mx[strings.ToLower(name)]; ok {
+		return mx, nil
+	}
+
+	return nil, notFound(name)
+}
+
+func (f *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err := f.record("TXT", name); err != nil {
+		return nil, err
+	}
+
+	if txt, ok := f.txt[strings.ToLower(name)]; ok {
+		return txt, nil
+	}
+
+	return nil, notFound(name)
+}
+
+func (f *fakeResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if err := f.record("IP", host); err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+
+	for _, ip := range f.ip[strings.ToLower(host)] {
+		is4 := ip.To4() != nil
+
+		if network == "ip" || (network == "ip4" && is4) || (network == "ip6" && !is4) {
+			ips = append(ips, ip)
+		}
+	}
+
+	if len(ips) == 0 {
+		return nil, notFound(host)
+	}
+
+	return ips, nil
+}
+
+func notFound(name string) error {
+	return &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// testDNSServer serves canned answers over UDP and TCP on the same port.
+type testDNSServer struct {
+	addr string
+	// answer builds the reply for one query. tcp tells it which transport
+	// the query arrived on.
+	answer func(q dnsmessage.Question, tcp bool) dnsmessage.Message
+
+	queries atomic.Int32
+}
+
+func startDNSServer(t *testing.T, answer func(q dnsmessage.Question, tcp bool) dnsmessage.Message) *testDNSServer {
+	t.Helper()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+
+	if err != nil {
+		udp.Close()
+		t.Skipf("cannot listen on tcp port matching udp: %v", err)
+	}
+
+	s := &testDNSServer{addr: udp.LocalAddr().String(), answer: answer}
+
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	go s.serveUDP(udp)
+	go s.serveTCP(tcp)
+
+	return s
+}
+
+func (s *testDNSServer) reply(query []byte, tcp bool) []byte {
+	var req dnsmessage.Message
+
+	if err := req.Unpack(query); err != nil || len(req.Questions) != 1 {
+		return nil
+	}
+
+	s.queries.Add(1)
+
+	msg := s.answer(req.Questions[0], tcp)
+	msg.ID = req.ID
+	msg.Response = true
+	msg.Questions = req.Questions
+
+	out, err := msg.Pack()
+
+	if err != nil {
+		return nil
+	}
+
+	return out
+}
+
+func (s *testDNSServer) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, 4096)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+
+		if err != nil {
+			return
+		}
+
+		if out := s.reply(buf[:n], false); out != nil {
+			conn.WriteTo(out, addr)
+		}
+	}
+}
+
+func (s *testDNSServer) serveTCP(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+
+			var size [2]byte
+
+			if _, err := io.ReadFull(conn, size[:]); err != nil {
+				return
+			}
+
+			query := make([]byte, binary.BigEndian.Uint16(size[:]))
+
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+
+			out := s.reply(query, true)
+			framed := make([]byte, 2+len(out))
+			binary.BigEndian.PutUint16(framed, uint16(len(out)))
+			copy(framed[2:], out)
+			conn.Write(framed)
+		}()
+	}
+}
+
+func rrHeader(name string, t dnsmessage.Type, ttl uint32) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  dnsmessage.MustNewName(name),
+		Type:  t,
+		Class: dnsmessage.ClassINET,
+		TTL:   ttl,
+	}
+}
+
+func TestDNSResolverLookups(t *testing.T) {
+	srv := startDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		var msg dnsmessage.Message
+
+		switch {
+		case q.Type == dnsmessage.TypeMX && q.Name.String() == "example.com.":
+			msg.Answers = []dnsmessage.Resource{
+				{Header: rrHeader("example.com.", dnsmessage.TypeMX, 300), Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx1.example.com.")}},
+				{Header: rrHeader("example.com.", dnsmessage.TypeMX, 300), Body: &dnsmessage.MXResource{Pref: 20, MX: dnsmessage.MustNewName("mx2.example.com.")}},
+			}
+		case q.Type == dnsmessage.TypeTXT && q.Name.String() == "example.com.":
+			msg.Answers = []dnsmessage.Resource{
+				{Header: rrHeader("example.com.", dnsmessage.TypeTXT, 300), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 ", "-all"}}},
+			}
+		case q.Type == dnsmessage.TypeA && q.Name.String() == "www.example.com.":
+			msg.Answers = []dnsmessage.Resource{
+				{Header: rrHeader("www.example.com.", dnsmessage.TypeCNAME, 300), Body: &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("web.example.com.")}},
+				{Header: rrHeader("web.example.com.", dnsmessage.TypeA, 300), Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}},
+			}
+		case q.Name.String() == "missing.example.com.":
+			msg.RCode = dnsmessage.RCodeNameError
+		}
+
+		return msg
+	})
+
+	r := newDNSResolver([]string{srv.addr}, time.Second, 0)
+	ctx := context.Background()
+
+	mx, err := r.LookupMX(ctx, "example.com")
+
+	if err != nil {
+		t.Fatalf("LookupMX: %v", err)
+	}
+
+	if len(mx) != 2 || mx[0].Host != "mx1.example.com." || mx[0].Pref != 10 {
+		t.Fatalf("LookupMX = %+v", mx)
+	}
+
+	txt, err := r.LookupTXT(ctx, "example.com")
+
+	if err != nil || len(txt) != 1 || txt[0] != "v=spf1 -all" {
+		t.Fatalf("LookupTXT = %q, %v; want segments joined", txt, err)
+	}
+
+	ips, err := r.LookupIP(ctx, "ip4", "www.example.com")
+
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("LookupIP through CNAME = %v, %v", ips, err)
+	}
+
+	if _, err := r.LookupMX(ctx, "example.com."); err != nil {
+		t.Fatalf("LookupMX with a trailing dot: %v", err)
+	}
+
+	// NXDOMAIN and an empty answer both look like net's "not found".
+	for _, name := range []string{"missing.example.com", "nodata.example.com"} {
+		_, err := r.LookupTXT(ctx, name)
+
+		var dnsErr *net.DNSError
+
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Fatalf("LookupTXT(%q) error = %v, want IsNotFound", name, err)
+		}
+	}
+}
+
+func TestDNSResolverTruncationFallsBackToTCP(t *testing.T) {
+	var overTCP atomic.Bool
+
+	srv := startDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		if !tcp {
+			return dnsmessage.Message{Header: dnsmessage.Header{Truncated: true}}
+		}
+
+		overTCP.Store(true)
+
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: rrHeader("big.example.com.", dnsmessage.TypeTXT, 60), Body: &dnsmessage.TXTResource{TXT: []string{strings.Repeat("x", 200)}}},
+		}}
+	})
+
+	r := newDNSResolver([]string{srv.addr}, time.Second, 0)
+
+	txt, err := r.LookupTXT(context.Background(), "big.example.com")
+
+	if err != nil {
+		t.Fatalf("LookupTXT: %v", err)
+	}
+
+	if !overTCP.Load() || len(txt) != 1 || len(txt[0]) != 200 {
+		t.Fatalf("LookupTXT = %d records, tcp used = %v", len(txt), overTCP.Load())
+	}
+}
+
+func TestDNSResolverRetriesNextServer(t *testing.T) {
+	failing := startDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		return dnsmessage.Message{Header: dnsmessage.Header{RCode: dnsmessage.RCodeServerFailure}}
+	})
+
+	working := startDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: rrHeader("example.com.", dnsmessage.TypeTXT, 60), Body: &dnsmessage.TXTResource{TXT: []string{"ok"}}},
+		}}
+	})
+
+	r := newDNSResolver([]string{failing.addr, working.addr}, time.Second, 0)
+
+	txt, err := r.LookupTXT(context.Background(), "example.com")
+
+	if err != nil || len(txt) != 1 || txt[0] != "ok" {
+		t.Fatalf("LookupTXT = %q, %v", txt, err)
+	}
+
+	only := newDNSResolver([]string{failing.addr}, time.Second, 2)
+	before := failing.queries.Load()
+
+	_, err = only.LookupTXT(context.Background(), "example.com")
+
+	var dnsErr *net.DNSError
+
+	if !errors.As(err, &dnsErr) || !dnsErr.IsTemporary {
+		t.Fatalf("error = %v, want a temporary DNS error", err)
+	}
+
+	if n := failing.queries.Load() - before; n != 3 {
+		t.Fatalf("failing server saw %d queries, want 3 (1 + 2 retries)", n)
+	}
+}
+
+func TestDNSResolverCachesByTTL(t *testing.T) {
+	srv := startDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		if q.Name.String() == "gone.example.com." {
+			return dnsmessage.Message{
+				Header: dnsmessage.Header{RCode: dnsmessage.RCodeNameError},
+				Authorities: []dnsmessage.Resource{{
+					Header: rrHeader("example.com.", dnsmessage.TypeSOA, 3600),
+					Body: &dnsmessage.SOAResource{
+						NS:     dnsmessage.MustNewName("ns.example.com."),
+						MBox:   dnsmessage.MustNewName("hostmaster.example.com."),
+						MinTTL: 30,
+					},
+				}},
+			}
+		}
+
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: rrHeader("example.com.", dnsmessage.TypeTXT, 120), Body: &dnsmessage.TXTResource{TXT: []string{"cached"}}},
+		}}
+	})
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := newDNSResolver([]string{srv.addr}, time.Second, 0)
+	r.cache.now = func() time.Time { return now }
+
+	ctx := context.Background()
+
+	lookup := func(name string) {
+		t.Helper()
+		r.LookupTXT(ctx, name)
+	}
+
+	lookup("example.com")
+	lookup("EXAMPLE.com.")
+
+	if n := srv.queries.Load(); n != 1 {
+		t.Fatalf("server saw %d queries, want 1 while the answer is fresh", n)
+	}
+
+	now = now.Add(121 * time.Second)
+	lookup("example.com")
+
+	if n := srv.queries.Load(); n != 2 {
+		t.Fatalf("server saw %d queries, want 2 after the TTL expired", n)
+	}
+
+	lookup("gone.example.com")
+	now = now.Add(29 * time.Second)
+	lookup("gone.example.com")
+
+	if n := srv.queries.Load(); n != 3 {
+		t.Fatalf("server saw %d queries, want NXDOMAIN cached for the SOA minimum", n)
+	}
+
+	now = now.Add(2 * time.Second)
+	lookup("gone.example.com")
+
+	if n := srv.queries.Load(); n != 4 {
+		t.Fatalf("server saw %d queries, want the negative entry to expire", n)
+	}
+}
+
+func TestDNSResolverRecordsTTL(t *testing.T) {
+	srv := startDNSServer(t, func(q dnsmessage.Question, tcp bool) dnsmessage.Message {
+		ttl := uint32(300)
+
+		if q.Name.String() == "short.example.com." {
+			ttl = 45
+		}
+
+		return dnsmessage.Message{Answers: []dnsmessage.Resource{
+			{Header: rrHeader(q.Name.String(), dnsmessage.TypeTXT, ttl), Body: &dnsmessage.TXTResource{TXT: []string{"v=spf1 -all"}}},
+		}}
+	})
+
+	r := newDNSResolver([]string{srv.addr}, time.Second, 0)
+
+	ctx, rec := withTTLRecorder(context.Background())
+
+	if _, ok := rec.TTL(); ok {
+		t.Fatal("TTL recorded before any lookup")
+	}
+
+	r.LookupTXT(ctx, "example.com")
+	r.LookupTXT(ctx, "short.example.com")
+
+	if ttl, ok := rec.TTL(); !ok || ttl != 45*time.Second {
+		t.Fatalf("TTL = %v, %v; want 45s", ttl, ok)
+	}
+
+	// A cached answer reports what is left of its TTL.
+	ctx, rec = withTTLRecorder(context.Background())
+	r.cache.now = func() time.Time { return time.Now().Add(100 * time.Second) }
+
+	r.LookupTXT(ctx, "example.com")
+
+	if ttl, ok := rec.TTL(); !ok || ttl > 200*time.Second || ttl < 190*time.Second {
+		t.Fatalf("TTL from cache = %v, %v; want about 200s", ttl, ok)
+	}
+}
+
+func TestSystemNameservers(t *testing.T) {
+	path := t.TempDir() + "/resolv.conf"
+
+	os.WriteFile(path, []byte("# generated\nsearch example.com\nnameserver 192.0.2.53\nnameserver  2001:db8::53\noptions ndots:1\n"), 0o644)
+
+	got := systemNameservers(path)
+
+	if len(got) != 2 || got[0] != "192.0.2.53" || got[1] != "2001:db8::53" {
+		t.Fatalf("systemNameservers = %q", got)
+	}
+
+	if got := systemNameservers(path + ".missing"); got != nil {
+		t.Fatalf("missing file gave %q", got)
+	}
+}
+
+func TestDNSCacheIsBounded(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	c := newDNSCache(2)
+	c.now = func() time.Time { return now }
+
+	key := func(name string) cacheKey { return cacheKey{name: name, qtype: dnsmessage.TypeTXT} }
+	cached := func(name string) bool {
+		_, _, _, ok := c.get(key(name))
+		return ok
+	}
+
+	c.put(key("a."), nil, nil, time.Minute)
+	c.put(key("b."), nil, nil, 2*time.Minute)
+	c.put(key("c."), nil, nil, time.Minute)
+
+	if cached("c.") || len(c.entries) != 2 {
+		t.Fatalf("full cache took a new entry: %d entries", len(c.entries))
+	}
+
+	// Replacing an entry already cached still works when full.
+	c.put(key("a."), nil, nil, 3*time.Minute)
+
+	now = now.Add(150 * time.Second)
+
+	// b has expired but is never read again; the next put clears it.
+	c.put(key("c."), nil, nil, time.Minute)
+
+	if !cached("a.") || !cached("c.") || len(c.entries) != 2 {
+		t.Fatalf("entries = %v, want a and c after b expired", c.entries)
+	}
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// server answers the HTTP API of serve mode:
+//
+//	GET  /v1/domains/{domain}
+//	POST /v1/domains:batch   {"domains": ["a.example", "b.example"]}
+//	GET  /metrics
+type server struct {
+	verifier *verifier
+	cache    *resultCache
+	clients  *clientLimiter
+	metrics  *metrics
+	// limiter caps the domains looked up per second across all clients.
+	limiter *rateLimiter
+	// workers is the number of domains of one batch checked at once.
+	workers  int
+	maxBatch int
+}
+
+// maxBodyBytes bounds the size of a batch request.
+const maxBodyBytes = 1 << 20
+
+type batchRequest struct {
+	Domains []string `json:"domains"`
+}
+
+type batchResponse struct {
+	Results []domainResult `json:"results"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+
+	switch {
+	case r.URL.Path == "/metrics":
+		s.handleMetrics(rec, r)
+	case r.URL.Path == "/v1/domains:batch":
+		s.handleBatch(rec, r)
+	case strings.HasPrefix(r.URL.Path, "/v1/domains/"):
+		s.handleDomain(rec, r, strings.TrimPrefix(r.URL.Path, "/v1/domains/"))
+	default:
+		writeError(rec, http.StatusNotFound, "not found")
+	}
+
+	if r.URL.Path != "/metrics" {
+		s.metrics.observeRequest(rec.code)
+	}
+}
+
+func (s *server) handleDomain(w http.ResponseWriter, r *http.Request, domain string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	domain, err := normalizeDomain(domain)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !s.allow(w, r, 1) {
+		return
+	}
+
+	result, ttl := s.check(r.Context(), domain)
+
+	if r.Context().Err() != nil {
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(ttl.Seconds())))
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var req batchRequest
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+
+	if len(req.Domains) == 0 {
+		writeError(w, http.StatusBadRequest, "domains is empty")
+		return
+	}
+
+	if len(req.Domains) > s.maxBatch {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("at most %d domains can be checked in one request", s.maxBatch))
+		return
+	}
+
+	for i, d := range req.Domains {
+		domain, err := normalizeDomain(d)
+
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		req.Domains[i] = domain
+	}
+
+	if !s.allow(w, r, len(req.Domains)) {
+		return
+	}
+
+	resp := batchResponse{Results: make([]domainResult, 0, len(req.Domains))}
+
+	check := func(ctx context.Context, domain string) domainResult {
+		result, _ := s.check(ctx, domain)
+		return result
+	}
+
+	in := strings.NewReader(strings.Join(req.Domains, "\n"))
+
+	err := checkAll(r.Context(), in, s.workers, nil, check, func(result domainResult) {
+		resp.Results = append(resp.Results, result)
+	})
+
+	if err != nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.writeTo(w)
+}
+
+// allow takes n tokens from the client's bucket, or answers 429.
+func (s *server) allow(w http.ResponseWriter, r *http.Request, n int) bool {
+	ok, wait := s.clients.allow(clientAddr(r), n)
+
+	if ok {
+		return true
+	}
+
+	s.metrics.count(&s.metrics.rateLimited)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+
+	return false
+}
+
+// check returns the result for domain from the cache, or looks it up and
+// caches it for the shortest TTL of the records it depends on. Results
+// with failed lookups are not cached.
+func (s *server) check(ctx context.Context, domain string) (domainResult, time.Duration) {
+	if result, ttl, ok := s.cache.get(domain); ok {
+		s.metrics.count(&s.metrics.cacheHits)
+		return result, ttl
+	}
+
+	s.metrics.count(&s.metrics.cacheMisses)
+
+	if err := s.limiter.Wait(ctx); err != nil {
+		return domainResult{Domain: domain, Failed: true}, 0
+	}
+
+	ctx, rec := withTTLRecorder(ctx)
+
+	start := time.Now()
+	result := s.verifier.checkDomain(ctx, domain)
+	s.metrics.observeCheck(time.Since(start), result.Failed)
+
+	if result.Failed {
+		return result, 0
+	}
+
+	ttl, ok := rec.TTL()
+
+	if !ok || ttl > s.cache.maxTTL {
+		ttl = s.cache.maxTTL
+	}
+
+	s.cache.put(domain, result, ttl)
+
+	return result, ttl
+}
+
+// normalizeDomain lower-cases a domain name, drops a trailing dot and
+// checks that it is a syntactically valid host name.
+func normalizeDomain(s string) (string, error) {
+	domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "."))
+
+	if domain == "" || len(domain) > 253 || !strings.Contains(domain, ".") {
+		return "", fmt.Errorf("%q is not a valid domain name", s)
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("%q is not a valid domain name", s)
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", fmt.Errorf("%q is not a valid domain name", s)
+			}
+		}
+	}
+
+	return domain, nil
+}
+
+// clientAddr identifies the client by IP address. X-Forwarded-For is not
+// trusted, since any client can set it.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, errorResponse{Error: msg})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// resultCache holds domain results until the DNS records they were built
+// from expire. Expired entries are dropped when they are next read, and
+// all of them whenever the cache reaches maxEntries.
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+	// maxTTL bounds how long a result is kept, and is used as its TTL
+	// when the resolver does not report one.
+	maxTTL     time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+type cachedResult struct {
+	result  domainResult
+	expires time.Time
+}
+
+func newResultCache(maxTTL time.Duration, maxEntries int) *resultCache {
+	return &resultCache{
+		entries:    map[string]cachedResult{},
+		maxTTL:     maxTTL,
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+func (c *resultCache) get(domain string) (domainResult, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[domain]
+
+	if !ok {
+		return domainResult{}, 0, false
+	}
+
+	ttl := e.expires.Sub(c.now())
+
+	if ttl <= 0 {
+		delete(c.entries, domain)
+		return domainResult{}, 0, false
+	}
+
+	return e.result, ttl, true
+}
+
+func (c *resultCache) put(domain string, result domainResult, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+
+	if len(c.entries) >= c.maxEntries {
+		for d, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, d)
+			}
+		}
+	}
+
+	if len(c.entries) >= c.maxEntries {
+		return
+	}
+
+	c.entries[domain] = cachedResult{result: result, expires: now.Add(ttl)}
+}
+
+// clientLimiter is a token bucket per client. Each domain asked for costs
+// one token; buckets refill at rate tokens per second up to burst.
+type clientLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxClients bounds the number of buckets kept. Full buckets carry no
+// state, so they are dropped first when the limit is reached.
+const maxClients = 10000
+
+func newClientLimiter(rate float64, burst int) *clientLimiter {
+	return &clientLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// allow takes n tokens for client if it has them. Otherwise it reports how
+// long the client has to wait. A limiter with a rate of 0 allows anything.
+func (l *clientLimiter) allow(client string, n int) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[client]
+
+	if !ok {
+		if len(l.buckets) >= maxClients {
+			l.prune(now)
+		}
+
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if need := float64(n); b.tokens < need {
+		return false, time.Duration((need - b.tokens) / l.rate * float64(time.Second))
+	}
+
+	b.tokens -= float64(n)
+
+	return true, 0
+}
+
+func (l *clientLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// serve runs the HTTP API until ctx is cancelled, then lets requests in
+// progress finish. It stops the server's limiter when it returns.
+func serve(ctx context.Context, addr string, s *server) error {
+	defer s.limiter.Stop()
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, clientRate float64, maxBatch int) (*httptest.Server, *fakeResolver, *server) {
+	t.Helper()
+
+	fake := &fakeResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx.example.com.", Pref: 10}},
+		},
+		txt: map[string][]string{
+			"example.com":        {"v=spf1 -all"},
+			"_dmarc.example.com": {"v=DMARC1; p=reject; rua=mailto:d@example.com"},
+			"other.example":      {"v=spf1 ~all"},
+		},
+		errs: map[string]error{
+			"broken.example": &net.DNSError{Err: "i/o timeout", Name: "broken.example", IsTimeout: true},
+		},
+	}
+
+	m := newMetrics()
+
+	s := &server{
+		verifier: &verifier{resolver: meteredResolver{Resolver: fake, metrics: m}, timeout: time.Second, httpClient: newPolicyClient()},
+		cache:    newResultCache(time.Hour, 100),
+		clients:  newClientLimiter(clientRate, maxBatch),
+		metrics:  m,
+		workers:  4,
+		maxBatch: maxBatch,
+	}
+
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	return srv, fake, s
+}
+
+func getJSON(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+}
+
+func TestServerGetDomain(t *testing.T) {
+	srv, fake, _ := newTestServer(t, 0, 10)
+
+	resp, err := http.Get(srv.URL + "/v1/domains/Example.COM.")
+
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	var got domainResult
+	getJSON(t, resp, &got)
+
+	if got.Domain != "example.com" || !got.HasMX || got.DMARCPolicy != "reject" || got.Grade != "A" {
+		t.Fatalf("result = %+v", got)
+	}
+
+	// No TTL is known from the fake resolver, so the result is kept for
+	// the maximum.
+	if cc := resp.Header.Get("Cache-Control"); cc != "max-age=3599" && cc != "max-age=3600" {
+		t.Fatalf("Cache-Control = %q", cc)
+	}
+
+	calls := len(fake.calls)
+
+	resp, _ = http.Get(srv.URL + "/v1/domains/example.com")
+	resp.Body.Close()
+
+	if len(fake.calls) != calls {
+		t.Fatalf("second request made %d more lookups, want it served from the cache", len(fake.calls)-calls)
+	}
+}
+
+func TestServerDoesNotCacheFailures(t *testing.T) {
+	srv, fake, _ := newTestServer(t, 0, 10)
+
+	for i := 0; i < 2; i++ {
+		resp, _ := http.Get(srv.URL + "/v1/domains/broken.example")
+
+		var got domainResult
+		getJSON(t, resp, &got)
+
+		if !got.Failed || got.MXError == "" {
+			t.Fatalf("result = %+v, want a failed lookup", got)
+		}
+	}
+
+	n := 0
+
+	for _, c := range fake.calls {
+		if c == "MX broken.example" {
+			n++
+		}
+	}
+
+	if n != 2 {
+		t.Fatalf("MX looked up %d times, want 2", n)
+	}
+}
+
+func TestServerBatch(t *testing.T) {
+	srv, _, _ := newTestServer(t, 0, 3)
+
+	resp, err := http.Post(srv.URL+"/v1/domains:batch", "application/json", strings.NewReader(`{"domains": ["other.example", "EXAMPLE.com", "broken.example"]}`))
+
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+
+	var got batchResponse
+	getJSON(t, resp, &got)
+
+	if len(got.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(got.Results))
+	}
+
+	for i, want := range []string{"other.example", "example.com", "broken.example"} {
+		if got.Results[i].Domain != want {
+			t.Fatalf("result %d is %q, want %q: results must keep the request order", i, got.Results[i].Domain, want)
+		}
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	srv, _, _ := newTestServer(t, 0, 2)
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "/v1/domains/not_a..domain", "", http.StatusBadRequest},
+		{"GET", "/v1/domains/localhost", "", http.StatusBadRequest},
+		{"DELETE", "/v1/domains/example.com", "", http.StatusMethodNotAllowed},
+		{"GET", "/v1/domains:batch", "", http.StatusMethodNotAllowed},
+		{"POST", "/v1/domains:batch", `{"domains": []}`, http.StatusBadRequest},
+		{"POST", "/v1/domains:batch", `{"domains": ["a.example", "b.example", "c.example"]}`, http.StatusBadRequest},
+		{"POST", "/v1/domains:batch", `{"domains": ["a.example", "-bad.example"]}`, http.StatusBadRequest},
+		{"POST", "/v1/domains:batch", `{"domain": "a.example"}`, http.StatusBadRequest},
+		{"POST", "/v1/domains:batch", `not json`, http.StatusBadRequest},
+		{"GET", "/v2/anything", "", http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		req, _ := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+		resp, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatalf("%s %s: %v", tc.method, tc.path, err)
+		}
+
+		var body errorResponse
+		getJSON(t, resp, &body)
+
+		if resp.StatusCode != tc.want || body.Error == "" {
+			t.Errorf("%s %s %s = %d %q, want %d with an error message", tc.method, tc.path, tc.body, resp.StatusCode, body.Error, tc.want)
+		}
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	srv, _, _ := newTestServer(t, 1, 2)
+
+	post := func(body string) *http.Response {
+		resp, err := http.Post(srv.URL+"/v1/domains:batch", "application/json", strings.NewReader(body))
+
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		return resp
+	}
+
+	if resp := post(`{"domains": ["example.com", "other.example"]}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first batch: status %d", resp.StatusCode)
+	}
+
+	resp := post(`{"domains": ["example.com", "other.example"]}`)
+
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "2" {
+		t.Fatalf("second batch: status %d, Retry-After %q; want 429 and 2", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+}
+
+func TestClientLimiterRefills(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := newClientLimiter(2, 4)
+	l.now = func() time.Time { return now }
+
+	if ok, _ := l.allow("a", 4); !ok {
+		t.Fatal("full bucket refused its burst")
+	}
+
+	if ok, wait := l.allow("a", 1); ok || wait != 500*time.Millisecond {
+		t.Fatalf("empty bucket: allowed %v, wait %v; want refused for 500ms", ok, wait)
+	}
+
+	if ok, _ := l.allow("b", 1); !ok {
+		t.Fatal("clients share a bucket")
+	}
+
+	now = now.Add(time.Second)
+
+	if ok, _ := l.allow("a", 2); !ok {
+		t.Fatal("bucket did not refill")
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	srv, _, _ := newTestServer(t, 0, 10)
+
+	for _, domain := range []string{"example.com", "example.com", "broken.example"} {
+		resp, _ := http.Get(srv.URL + "/v1/domains/" + domain)
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	text := string(body)
+
+	for _, want := range []string{
+		"# TYPE email_verifier_lookup_duration_seconds histogram",
+		`email_verifier_lookup_duration_seconds_bucket{type="MX",le="+Inf"} 2`,
+		`email_verifier_lookup_duration_seconds_count{type="TXT"}`,
+		`email_verifier_lookup_failures_total{type="MX"} 1`,
+		"email_verifier_check_duration_seconds_count 2",
+		"email_verifier_check_failures_total 1",
+		"email_verifier_cache_hits_total 1",
+		"email_verifier_cache_misses_total 2",
+		`email_verifier_http_requests_total{code="200"} 3`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics do not contain %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	valid := map[string]string{
+		"Example.COM":           "example.com",
+		"example.com.":          "example.com",
+		"_dmarc.example.com":    "_dmarc.example.com",
+		"xn--bcher-kva.example": "xn--bcher-kva.example",
+	}
+
+	for in, want := range valid {
+		if got, err := normalizeDomain(in); err != nil || got != want {
+			t.Errorf("normalizeDomain(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "com", "a..example", "-a.example", "a-.example", "a b.example", "a/b.example", strings.Repeat("a", 64) + ".example"} {
+		if _, err := normalizeDomain(in); err == nil {
+			t.Errorf("normalizeDomain(%q) accepted an invalid name", in)
+		}
+	}
+}
@@ -0,0 +1,913 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// spfResult is one of the check_host() results from RFC 7208 section 2.6.
+type spfResult string
+
+const (
+	spfNone      spfResult = "none"
+	spfNeutral   spfResult = "neutral"
+	spfPass      spfResult = "pass"
+	spfFail      spfResult = "fail"
+	spfSoftFail  spfResult = "softfail"
+	spfTempError spfResult = "temperror"
+	spfPermError spfResult = "permerror"
+)
+
+const (
+	// spfLookupLimit caps the mechanisms and modifiers that cause DNS
+	// queries (include, a, mx, ptr, exists, redirect) in one evaluation.
+	spfLookupLimit = 10
+	// spfVoidLookupLimit caps lookups that return no records.
+	spfVoidLookupLimit = 2
+	// spfMXLimit caps the MX hosts an mx mechanism may resolve.
+	spfMXLimit = 10
+)
+
+var (
+	errSPFMultiple    = errors.New("multiple SPF records")
+	errSPFSyntax      = errors.New("invalid SPF record")
+	errSPFLookupLimit = fmt.Errorf("more than %d DNS lookups", spfLookupLimit)
+)
+
+// spfError carries the result an error turns into: temperror for DNS
+// failures, permerror for everything the domain owner has to fix.
+type spfError struct {
+	Result spfResult
+	Err    error
+}
+
+func (e *spfError) Error() string { return e.Err.Error() }
+
+func (e *spfError) Unwrap() error { return e.Err }
+
+func permError(format string, args ...interface{}) error {
+	return &spfError{Result: spfPermError, Err: fmt.Errorf(format, args...)}
+}
+
+func tempError(err error) error {
+	return &spfError{Result: spfTempError, Err: err}
+}
+
+func errorResult(err error) spfResult {
+	var se *spfError
+
+	if errors.As(err, &se) {
+		return se.Result
+	}
+
+	return spfTempError
+}
+
+type spfMechanism struct {
+	// Qualifier is one of + - ~ ?.
+	Qualifier byte
+	Name      string
+	// Domain is the domain-spec, possibly with macros. Empty means the
+	// domain being checked.
+	Domain string
+	// Net is set for ip4 and ip6.
+	Net *net.IPNet
+	// Prefix4 and Prefix6 are the dual CIDR lengths of a and mx.
+	Prefix4 int
+	Prefix6 int
+}
+
+func (m spfMechanism) result() spfResult {
+	switch m.Qualifier {
+	case '-':
+		return spfFail
+	case '~':
+		return spfSoftFail
+	case '?':
+		return spfNeutral
+	}
+
+	return spfPass
+}
+
+type spfRecord struct {
+	Mechanisms []spfMechanism
+	Redirect   string
+	Exp        string
+}
+
+// isSPF reports whether a TXT record is an SPF version 1 record.
+func isSPF(txt string) bool {
+	if len(txt) < 6 || !strings.EqualFold(txt[:6], "v=spf1") {
+		return false
+	}
+
+	return len(txt) == 6 || txt[6] == ' '
+}
+
+// parseSPF parses a record that starts with "v=spf1". Unknown modifiers
+// are ignored as the RFC requires; anything else that does not parse is
+// an error wrapping errSPFSyntax.
+func parseSPF(txt string) (*spfRecord, error) {
+	if !isSPF(txt) {
+		return nil, fmt.Errorf("%w: missing v=spf1", errSPFSyntax)
+	}
+
+	rec := &spfRecord{}
+
+	for _, term := range strings.Fields(txt[6:]) {
+		if name, value, ok := splitModifier(term); ok {
+			switch strings.ToLower(name) {
+			case "redirect":
+				if rec.Redirect != "" {
+					return nil, fmt.Errorf("%w: redirect given twice", errSPFSyntax)
+				}
+
+				rec.Redirect = value
+			case "exp":
+				if rec.Exp != "" {
+					return nil, fmt.Errorf("%w: exp given twice", errSPFSyntax)
+				}
+
+				rec.Exp = value
+			default:
+				continue
+			}
+
+			if err := checkMacroString(value); err != nil {
+				return nil, fmt.Errorf("%w: %q: %v", errSPFSyntax, term, err)
+			}
+
+			continue
+		}
+
+		m, err := parseMechanism(term)
+
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %v", errSPFSyntax, term, err)
+		}
+
+		rec.Mechanisms = append(rec.Mechanisms, m)
+	}
+
+	return rec, nil
+}
+
+// splitModifier recognises name=value terms. Mechanism arguments use ':'
+// and '/', so an '=' after a valid name always means a modifier.
+func splitModifier(term string) (string, string, bool) {
+	i := strings.IndexByte(term, '=')
+
+	if i <= 0 {
+		return "", "", false
+	}
+
+	name := term[:i]
+
+	for j, c := range name {
+		alpha := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+
+		if j == 0 && !alpha {
+			return "", "", false
+		}
+
+		if !alpha && !(c >= '0' && c <= '9') && c != '-' && c != '_' && c != '.' {
+			return "", "", false
+		}
+	}
+
+	return name, term[i+1:], true
+}
+
+func parseMechanism(term string) (spfMechanism, error) {
+	m := spfMechanism{Qualifier: '+', Prefix4: 32, Prefix6: 128}
+
+	if strings.IndexByte("+-~?", term[0]) >= 0 {
+		m.Qualifier = term[0]
+		term = term[1:]
+	}
+
+	name := term
+	arg := ""
+
+	if i := strings.IndexAny(term, ":/"); i >= 0 {
+		name, arg = term[:i], term[i:]
+	}
+
+	m.Name = strings.ToLower(name)
+
+	switch m.Name {
+	case "all":
+		if arg != "" {
+			return m, errors.New("all takes no arguments")
+		}
+	case "include", "exists":
+		if !strings.HasPrefix(arg, ":") || len(arg) == 1 {
+			return m, fmt.Errorf("%s requires a domain", m.Name)
+		}
+
+		m.Domain = arg[1:]
+	case "ptr":
+		if arg != "" {
+			if !strings.HasPrefix(arg, ":") || len(arg) == 1 {
+				return m, errors.New("ptr takes only a domain")
+			}
+
+			m.Domain = arg[1:]
+		}
+	case "a", "mx":
+		domain, cidr := arg, ""
+
+		if i := strings.IndexByte(arg, '/'); i >= 0 {
+			domain, cidr = arg[:i], arg[i:]
+		}
+
+		if domain != "" {
+			if !strings.HasPrefix(domain, ":") || len(domain) == 1 {
+				return m, fmt.Errorf("%s has an empty domain", m.Name)
+			}
+
+			m.Domain = domain[1:]
+		}
+
+		if err := parseDualCIDR(cidr, &m); err != nil {
+			return m, err
+		}
+	case "ip4", "ip6":
+		if !strings.HasPrefix(arg, ":") {
+			return m, fmt.Errorf("%s requires an address", m.Name)
+		}
+
+		addr := arg[1:]
+
+		if !strings.Contains(addr, "/") {
+			if m.Name == "ip4" {
+				addr += "/32"
+			} else {
+				addr += "/128"
+			}
+		}
+
+		_, ipnet, err := net.ParseCIDR(addr)
+
+		if err != nil {
+			return m, fmt.Errorf("bad %s network", m.Name)
+		}
+
+		if strings.Contains(addr, ":") != (m.Name == "ip6") {
+			return m, fmt.Errorf("address family does not match %s", m.Name)
+		}
+
+		m.Net = ipnet
+	default:
+		return m, errors.New("unknown mechanism")
+	}
+
+	if m.Domain != "" {
+		if err := checkMacroString(m.Domain); err != nil {
+			return m, err
+		}
+	}
+
+	return m, nil
+}
+
+// parseDualCIDR reads "/n", "//m" or "/n//m" after an a or mx mechanism.
+func parseDualCIDR(s string, m *spfMechanism) error {
+	if s == "" {
+		return nil
+	}
+
+	v4, v6 := s, ""
+
+	if i := strings.Index(s, "//"); i >= 0 {
+		v4, v6 = s[:i], s[i+2:]
+	}
+
+	if v4 != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(v4, "/"))
+
+		if err != nil || !strings.HasPrefix(v4, "/") || n < 0 || n > 32 {
+			return errors.New("bad ip4 prefix length")
+		}
+
+		m.Prefix4 = n
+	}
+
+	if strings.Contains(s, "//") {
+		n, err := strconv.Atoi(v6)
+
+		if err != nil || n < 0 || n > 128 {
+			return errors.New("bad ip6 prefix length")
+		}
+
+		m.Prefix6 = n
+	}
+
+	return nil
+}
+
+// macroEnv holds the values macros expand to. The verifier has no real
+// SMTP session, so the sender defaults to postmaster@<domain>.
+type macroEnv struct {
+	ip     net.IP
+	sender string
+	domain string
+}
+
+func checkMacroString(s string) error {
+	_, err := expandMacros(s, nil)
+	return err
+}
+
+// expandMacros implements the macro language of RFC 7208 section 7. With a
+// nil env it only checks the syntax.
+func expandMacros(s string, env *macroEnv) (string, error) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if c != '%' {
+			if c < 0x21 || c > 0x7e {
+				return "", errors.New("invalid character")
+			}
+
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+1 >= len(s) {
+			return "", errors.New("'%' at end of string")
+		}
+
+		i++
+
+		switch s[i] {
+		case '%':
+			b.WriteByte('%')
+			continue
+		case '_':
+			b.WriteByte(' ')
+			continue
+		case '-':
+			b.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", fmt.Errorf("invalid macro %%%c", s[i])
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+
+		if end < 0 {
+			return "", errors.New("unterminated macro")
+		}
+
+		body := s[i+1 : i+end]
+		i += end
+
+		value, err := expandMacro(body, env)
+
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(value)
+	}
+
+	return b.String(), nil
+}
+
+func expandMacro(body string, env *macroEnv) (string, error) {
+	if body == "" {
+		return "", errors.New("empty macro")
+	}
+
+	letter := body[0]
+	rest := body[1:]
+
+	digits := 0
+
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+
+	keep := 0
+
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+
+		if keep == 0 {
+			return "", errors.New("macro keeps zero labels")
+		}
+	}
+
+	rest = rest[digits:]
+	reverse := false
+
+	if strings.HasPrefix(rest, "r") || strings.HasPrefix(rest, "R") {
+		reverse = true
+		rest = rest[1:]
+	}
+
+	delims := rest
+
+	if strings.Trim(delims, ".-+,/_=") != "" {
+		return "", fmt.Errorf("invalid macro %%{%s}", body)
+	}
+
+	if delims == "" {
+		delims = "."
+	}
+
+	lower := letter | 0x20
+
+	if strings.IndexByte("slodiphv", lower) < 0 {
+		return "", fmt.Errorf("invalid macro letter %q", letter)
+	}
+
+	if env == nil {
+		return "", nil
+	}
+
+	var value string
+
+	local, senderDomain, _ := strings.Cut(env.sender, "@")
+
+	switch lower {
+	case 's':
+		value = env.sender
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = env.domain
+	case 'i':
+		value = macroIP(env.ip)
+	case 'p':
+		value = "unknown"
+	case 'h':
+		value = senderDomain
+	case 'v':
+		value = "in-addr"
+
+		if env.ip.To4() == nil {
+			value = "ip6"
+		}
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(delims, r)
+	})
+
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+
+	value = strings.Join(parts, ".")
+
+	if letter != lower {
+		value = url.PathEscape(value)
+	}
+
+	return value, nil
+}
+
+// macroIP renders %{i}: dotted quad for IPv4, dot-separated nibbles for
+// IPv6.
+func macroIP(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String()
+	}
+
+	const hex = "0123456789abcdef"
+
+	nibbles := make([]string, 0, 32)
+
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, string(hex[b>>4]), string(hex[b&0xf]))
+	}
+
+	return strings.Join(nibbles, ".")
+}
+
+// fetchSPF returns the domain's SPF record. raw is the record text, or the
+// first record when there are several. A missing record is not an error.
+func fetchSPF(ctx context.Context, resolver Resolver, domain string) (rec *spfRecord, raw string, err error) {
+	txts, err := resolver.LookupTXT(ctx, domain)
+
+	var dnsErr *net.DNSError
+
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, "", nil
+	}
+
+	if err != nil {
+		return nil, "", tempError(err)
+	}
+
+	var found []string
+
+	for _, txt := range txts {
+		if isSPF(txt) {
+			found = append(found, txt)
+		}
+	}
+
+	if len(found) == 0 {
+		return nil, "", nil
+	}
+
+	if len(found) > 1 {
+		return nil, found[0], &spfError{Result: spfPermError, Err: fmt.Errorf("%s: %w", domain, errSPFMultiple)}
+	}
+
+	rec, err = parseSPF(found[0])
+
+	if err != nil {
+		return nil, found[0], &spfError{Result: spfPermError, Err: fmt.Errorf("%s: %w", domain, err)}
+	}
+
+	return rec, found[0], nil
+}
+
+// spfChecker runs check_host() for one IP address. A checker is good for a
+// single evaluation, since it counts lookups across the whole include tree.
+type spfChecker struct {
+	resolver Resolver
+	ip       net.IP
+	sender   string
+	lookups  int
+	voids    int
+}
+
+// evaluateSPF answers whether mail from ip would pass SPF for domain.
+func evaluateSPF(ctx context.Context, resolver Resolver, domain string, ip net.IP) (spfResult, error) {
+	c := &spfChecker{resolver: resolver, ip: ip, sender: "postmaster@" + domain}
+	return c.checkHost(ctx, domain)
+}
+
+func (c *spfChecker) checkHost(ctx context.Context, domain string) (spfResult, error) {
+	rec, raw, err := fetchSPF(ctx, c.resolver, domain)
+
+	if err != nil {
+		return errorResult(err), err
+	}
+
+	if raw == "" {
+		return spfNone, nil
+	}
+
+	for _, m := range rec.Mechanisms {
+		matched, err := c.match(ctx, m, domain)
+
+		if err != nil {
+			return errorResult(err), err
+		}
+
+		if matched {
+			return m.result(), nil
+		}
+	}
+
+	if rec.Redirect == "" {
+		return spfNeutral, nil
+	}
+
+	if err := c.countLookup(); err != nil {
+		return spfPermError, err
+	}
+
+	target, err := c.expand(rec.Redirect, domain)
+
+	if err != nil {
+		return spfPermError, err
+	}
+
+	result, err := c.checkHost(ctx, target)
+
+	if result == spfNone {
+		return spfPermError, permError("redirect target %s has no SPF record", target)
+	}
+
+	return result, err
+}
+
+func (c *spfChecker) match(ctx context.Context, m spfMechanism, domain string) (bool, error) {
+	switch m.Name {
+	case "all":
+		return true, nil
+	case "ip4", "ip6":
+		if m.Name == "ip4" && c.ip.To4() == nil {
+			return false, nil
+		}
+
+		return m.Net.Contains(c.ip), nil
+	}
+
+	if err := c.countLookup(); err != nil {
+		return false, err
+	}
+
+	target, err := c.expand(m.Domain, domain)
+
+	if err != nil {
+		return false, err
+	}
+
+	switch m.Name {
+	case "include":
+		result, err := c.checkHost(ctx, target)
+
+		switch result {
+		case spfPass:
+			return true, nil
+		case spfFail, spfSoftFail, spfNeutral:
+			return false, nil
+		case spfNone:
+			return false, permError("include target %s has no SPF record", target)
+		}
+
+		return false, err
+	case "a":
+		ips, err := c.lookupIP(ctx, target)
+
+		if err != nil {
+			return false, err
+		}
+
+		return c.matchAny(ips, m), nil
+	case "mx":
+		mxs, err := c.resolver.LookupMX(ctx, target)
+
+		if err := c.checkLookup(err); err != nil {
+			return false, err
+		}
+
+		if len(mxs) > spfMXLimit {
+			return false, permError("%s has more than %d MX records", target, spfMXLimit)
+		}
+
+		for _, mx := range mxs {
+			if mx.Host == "." {
+				continue
+			}
+
+			ips, err := c.lookupIP(ctx, mx.Host)
+
+			if err != nil {
+				return false, err
+			}
+
+			if c.matchAny(ips, m) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	case "exists":
+		ips, err := c.resolver.LookupIP(ctx, "ip4", target)
+
+		if err := c.checkLookup(err); err != nil {
+			return false, err
+		}
+
+		return len(ips) > 0, nil
+	}
+
+	// ptr is deprecated and needs reverse lookups the Resolver does not
+	// offer. It still counts toward the limit but never matches.
+	return false, nil
+}
+
+func (c *spfChecker) lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	network := "ip6"
+
+	if c.ip.To4() != nil {
+		network = "ip4"
+	}
+
+	ips, err := c.resolver.LookupIP(ctx, network, host)
+
+	return ips, c.checkLookup(err)
+}
+
+// checkLookup turns "not found" into a void lookup and any other failure
+// into a temperror.
+func (c *spfChecker) checkLookup(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var dnsErr *net.DNSError
+
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		return tempError(err)
+	}
+
+	c.voids++
+
+	if c.voids > spfVoidLookupLimit {
+		return permError("more than %d void DNS lookups", spfVoidLookupLimit)
+	}
+
+	return nil
+}
+
+func (c *spfChecker) matchAny(ips []net.IP, m spfMechanism) bool {
+	for _, ip := range ips {
+		bits, prefix := 128, m.Prefix6
+
+		if ip.To4() != nil {
+			bits, prefix = 32, m.Prefix4
+		}
+
+		n := net.IPNet{IP: ip, Mask: net.CIDRMask(prefix, bits)}
+
+		if (ip.To4() != nil) == (c.ip.To4() != nil) && n.Contains(c.ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *spfChecker) countLookup() error {
+	c.lookups++
+
+	if c.lookups > spfLookupLimit {
+		return &spfError{Result: spfPermError, Err: errSPFLookupLimit}
+	}
+
+	return nil
+}
+
+func (c *spfChecker) expand(spec, domain string) (string, error) {
+	if spec == "" {
+		return domain, nil
+	}
+
+	target, err := expandMacros(spec, &macroEnv{ip: c.ip, sender: c.sender, domain: domain})
+
+	if err != nil {
+		return "", permError("%s: %v", spec, err)
+	}
+
+	// Long expansions lose labels from the left until they fit.
+	for len(target) > 253 {
+		i := strings.IndexByte(target, '.')
+
+		if i < 0 {
+			break
+		}
+
+		target = target[i+1:]
+	}
+
+	return target, nil
+}
+
+// spfReport summarises a domain's SPF policy without a client IP.
+type spfReport struct {
+	Record string
+	// Lookups is the worst-case number of DNS-querying terms across the
+	// whole include tree, which is what the ten-lookup limit applies to.
+	// The walk stops once it is over the limit, so past ten it is a lower
+	// bound.
+	Lookups int
+	// Err explains why the policy is broken, if it is.
+	Err error
+}
+
+// analyzeSPF walks the include and redirect tree of domain, counting every
+// term that would need a DNS lookup and collecting problems along the way.
+func analyzeSPF(ctx context.Context, resolver Resolver, domain string) spfReport {
+	a := &spfAnalysis{resolver: resolver, path: map[string]bool{}, fetched: map[string]fetchedSPF{}}
+
+	rec, raw, err := fetchSPF(ctx, resolver, domain)
+
+	report := spfReport{Record: raw, Err: err}
+
+	if rec == nil {
+		return report
+	}
+
+	a.walk(ctx, domain, rec)
+
+	report.Lookups = a.lookups
+	report.Err = a.err
+
+	if report.Err == nil && a.lookups > spfLookupLimit {
+		report.Err = fmt.Errorf("%w (%d)", errSPFLookupLimit, a.lookups)
+	}
+
+	return report
+}
+
+type spfAnalysis struct {
+	resolver Resolver
+	lookups  int
+	err      error
+	// path holds the domains on the current include chain, to stop loops.
+	path map[string]bool
+	// fetched holds the records already looked up, so a domain included
+	// from several places is fetched once.
+	fetched map[string]fetchedSPF
+}
+
+type fetchedSPF struct {
+	rec *spfRecord
+	raw string
+	err error
+}
+
+func (a *spfAnalysis) fetch(ctx context.Context, domain string) (*spfRecord, string, error) {
+	key := strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	f, ok := a.fetched[key]
+
+	if !ok {
+		f.rec, f.raw, f.err = fetchSPF(ctx, a.resolver, domain)
+		a.fetched[key] = f
+	}
+
+	return f.rec, f.raw, f.err
+}
+
+func (a *spfAnalysis) fail(err error) {
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+func (a *spfAnalysis) walk(ctx context.Context, domain string, rec *spfRecord) {
+	key := strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	if a.path[key] {
+		a.fail(permError("include loop through %s", domain))
+		return
+	}
+
+	a.path[key] = true
+	defer delete(a.path, key)
+
+	var targets []string
+
+	for _, m := range rec.Mechanisms {
+		switch m.Name {
+		case "all", "ip4", "ip6":
+			continue
+		case "include":
+			targets = append(targets, m.Domain)
+		}
+
+		a.lookups++
+	}
+
+	if rec.Redirect != "" {
+		a.lookups++
+		targets = append(targets, rec.Redirect)
+	}
+
+	for _, target := range targets {
+		// Past the limit the record already fails (RFC 7208 section
+		// 4.6.4), and going on would let a record that includes the same
+		// domains many times over make an unbounded number of queries.
+		if a.lookups > spfLookupLimit {
+			return
+		}
+
+		// Targets built from macros depend on the client, so they cannot
+		// be followed without one.
+		if strings.Contains(target, "%") {
+			continue
+		}
+
+		child, raw, err := a.fetch(ctx, target)
+
+		if err != nil {
+			a.fail(err)
+			continue
+		}
+
+		if raw == "" {
+			a.fail(permError("%s has no SPF record", target))
+			continue
+		}
+
+		a.walk(ctx, target, child)
+	}
+}
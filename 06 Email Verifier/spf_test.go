@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseSPF(t *testing.T) {
+	tests := []struct {
+		record  string
+		wantErr bool
+		check   func(t *testing.T, rec *spfRecord)
+	}{
+		{
+			record: "v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 a mx:mail.example.com/28//64 include:_spf.example.net ~all",
+			check: func(t *testing.T, rec *spfRecord) {
+				if len(rec.Mechanisms) != 6 {
+					t.Fatalf("got %d mechanisms, want 6", len(rec.Mechanisms))
+				}
+
+				mx := rec.Mechanisms[3]
+
+				if mx.Domain != "mail.example.com" || mx.Prefix4 != 28 || mx.Prefix6 != 64 {
+					t.Fatalf("mx = %+v", mx)
+				}
+
+				if rec.Mechanisms[5].result() != spfSoftFail {
+					t.Fatalf("~all result = %s", rec.Mechanisms[5].result())
+				}
+			},
+		},
+		{
+			record: "V=SPF1 -IP4:192.0.2.1 redirect=_spf.example.com unknown=ignored",
+			check: func(t *testing.T, rec *spfRecord) {
+				if rec.Redirect != "_spf.example.com" || rec.Mechanisms[0].result() != spfFail {
+					t.Fatalf("rec = %+v", rec)
+				}
+			},
+		},
+		{record: "v=spf1 exists:%{ir}.%{v}._spf.%{d2} -all"},
+		{record: "v=spf1"},
+		{record: "v=spf10 -all", wantErr: true},
+		{record: "v=spf1 ip4:192.0.2.300", wantErr: true},
+		{record: "v=spf1 ip4:2001:db8::1", wantErr: true},
+		{record: "v=spf1 ip6:192.0.2.1", wantErr: true},
+		{record: "v=spf1 include", wantErr: true},
+		{record: "v=spf1 a/33", wantErr: true},
+		{record: "v=spf1 all:example.com", wantErr: true},
+		{record: "v=spf1 foo -all", wantErr: true},
+		{record: "v=spf1 redirect=a.example redirect=b.example", wantErr: true},
+		{record: "v=spf1 exists:%{x}.example", wantErr: true},
+		{record: "v=spf1 include:%{d", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.record, func(t *testing.T) {
+			rec, err := parseSPF(tc.record)
+
+			if tc.wantErr {
+				if !errors.Is(err, errSPFSyntax) {
+					t.Fatalf("parseSPF(%q) error = %v, want a syntax error", tc.record, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseSPF(%q): %v", tc.record, err)
+			}
+
+			if tc.check != nil {
+				tc.check(t, rec)
+			}
+		})
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	env := &macroEnv{
+		ip:     net.ParseIP("192.0.2.3"),
+		sender: "strong-bad@email.example.com",
+		domain: "email.example.com",
+	}
+
+	// Examples from RFC 7208 section 7.4.
+	tests := map[string]string{
+		"%{s}":                  "strong-bad@email.example.com",
+		"%{o}":                  "email.example.com",
+		"%{d4}":                 "email.example.com",
+		"%{d2}":                 "example.com",
+		"%{d1}":                 "com",
+		"%{dr}":                 "com.example.email",
+		"%{d2r}":                "example.email",
+		"%{l}":                  "strong-bad",
+		"%{l-}":                 "strong.bad",
+		"%{lr-}":                "bad.strong",
+		"%{l1r-}":               "strong",
+		"%{ir}.%{v}._spf.%{d2}": "3.2.0.192.in-addr._spf.example.com",
+		"%{lr-}.lp._spf.%{d2}":  "bad.strong.lp._spf.example.com",
+		"%%%_%-":                "% %20",
+	}
+
+	for in, want := range tests {
+		got, err := expandMacros(in, env)
+
+		if err != nil || got != want {
+			t.Errorf("expandMacros(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+
+	env.ip = net.ParseIP("2001:db8::cb01")
+
+	got, _ := expandMacros("%{ir}.%{v}._spf.%{d2}", env)
+	want := "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"
+
+	if got != want {
+		t.Errorf("IPv6 expansion = %q, want %q", got, want)
+	}
+}
+
+func spfFixture() *fakeResolver {
+	return &fakeResolver{
+		txt: map[string][]string{
+			"example.com":          {"v=spf1 ip4:192.0.2.0/24 include:_spf.example.net mx -all"},
+			"_spf.example.net":     {"v=spf1 ip6:2001:db8::/32 a:relay.example.net ~all"},
+			"soft.example":         {"v=spf1 ?ip4:198.51.100.1 redirect=example.com"},
+			"neutral.example":      {"v=spf1 ip4:192.0.2.1"},
+			"twice.example":        {"v=spf1 -all", "v=spf1 +all"},
+			"broken.example":       {"v=spf1 ip4:nonsense -all"},
+			"dangling.example":     {"v=spf1 include:nothing.example -all"},
+			"badredirect.example":  {"v=spf1 redirect=nothing.example"},
+			"loop.example":         {"v=spf1 include:loop2.example -all"},
+			"loop2.example":        {"v=spf1 include:loop.example -all"},
+			"exists.example":       {"v=spf1 exists:%{ir}.allow.exists.example -all"},
+			"voids.example":        {"v=spf1 a:v1.example a:v2.example a:v3.example -all"},
+			"dualcidr.example":     {"v=spf1 a/24 -all"},
+			"unreachable.example":  {"v=spf1 include:timeout.example -all"},
+			"google-style.example": {"google-site-verification=x", "v=spf1 -all"},
+		},
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx.example.com.", Pref: 10}},
+		},
+		ip: map[string][]net.IP{
+			"mx.example.com.":                  {net.ParseIP("203.0.113.5")},
+			"relay.example.net":                {net.ParseIP("198.51.100.7")},
+			"dualcidr.example":                 {net.ParseIP("203.0.113.1")},
+			"7.113.0.203.allow.exists.example": {net.ParseIP("127.0.0.2")},
+		},
+		errs: map[string]error{
+			"timeout.example": &net.DNSError{Err: "i/o timeout", Name: "timeout.example", IsTimeout: true},
+		},
+	}
+}
+
+func TestEvaluateSPF(t *testing.T) {
+	tests := []struct {
+		domain string
+		ip     string
+		want   spfResult
+	}{
+		{"example.com", "192.0.2.77", spfPass},
+		{"example.com", "2001:db8::1", spfPass},
+		{"example.com", "203.0.113.5", spfPass},
+		{"example.com", "203.0.113.6", spfFail},
+		{"example.com", "198.51.100.7", spfPass},
+		{"example.com", "198.51.100.8", spfFail},
+		{"soft.example", "198.51.100.1", spfNeutral},
+		{"soft.example", "192.0.2.1", spfPass},
+		{"neutral.example", "10.0.0.1", spfNeutral},
+		{"nothing.example", "192.0.2.1", spfNone},
+		{"twice.example", "192.0.2.1", spfPermError},
+		{"broken.example", "192.0.2.1", spfPermError},
+		{"dangling.example", "192.0.2.1", spfPermError},
+		{"badredirect.example", "192.0.2.1", spfPermError},
+		{"loop.example", "192.0.2.1", spfPermError},
+		{"exists.example", "203.0.113.7", spfPass},
+		{"exists.example", "203.0.113.8", spfFail},
+		{"voids.example", "192.0.2.1", spfPermError},
+		{"dualcidr.example", "203.0.113.200", spfPass},
+		{"dualcidr.example", "203.0.114.1", spfFail},
+		{"unreachable.example", "192.0.2.1", spfTempError},
+		{"google-style.example", "192.0.2.1", spfFail},
+	}
+
+	r := spfFixture()
+
+	for _, tc := range tests {
+		t.Run(tc.domain+"/"+tc.ip, func(t *testing.T) {
+			got, err := evaluateSPF(context.Background(), r, tc.domain, net.ParseIP(tc.ip))
+
+			if got != tc.want {
+				t.Fatalf("evaluateSPF(%s, %s) = %s (%v), want %s", tc.domain, tc.ip, got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestSPFLookupLimit(t *testing.T) {
+	r := &fakeResolver{txt: map[string][]string{}}
+
+	// A chain of includes, each adding one lookup plus an a mechanism.
+	var parts []string
+
+	for i := 0; i < 6; i++ {
+		parts = append(parts, fmt.Sprintf("include:inc%d.example", i))
+		r.txt[fmt.Sprintf("inc%d.example", i)] = []string{"v=spf1 a:relay.example ?all"}
+	}
+
+	r.txt["heavy.example"] = []string{"v=spf1 " + strings.Join(parts, " ") + " -all"}
+	r.ip = map[string][]net.IP{"relay.example": {net.ParseIP("192.0.2.1")}}
+
+	got, err := evaluateSPF(context.Background(), r, "heavy.example", net.ParseIP("10.0.0.1"))
+
+	if got != spfPermError || !errors.Is(err, errSPFLookupLimit) {
+		t.Fatalf("evaluateSPF = %s, %v; want permerror for the lookup limit", got, err)
+	}
+
+	// The same address matching early stays under the limit.
+	got, _ = evaluateSPF(context.Background(), r, "heavy.example", net.ParseIP("192.0.2.1"))
+
+	if got != spfPass {
+		t.Fatalf("early match = %s, want pass from the first include", got)
+	}
+
+	report := analyzeSPF(context.Background(), r, "heavy.example")
+
+	// The walk stops at inc4, the first include to take it over ten.
+	if report.Lookups != 11 || !errors.Is(report.Err, errSPFLookupLimit) {
+		t.Fatalf("analyzeSPF = %d lookups, %v; want 11 and the limit error", report.Lookups, report.Err)
+	}
+}
+
+// countingResolver counts the TXT queries made for each domain.
+type countingResolver struct {
+	*fakeResolver
+	queries map[string]int
+}
+
+func (r *countingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.queries[name]++
+	return r.fakeResolver.LookupTXT(ctx, name)
+}
+
+func TestAnalyzeSPFStopsAtLookupLimit(t *testing.T) {
+	r := &countingResolver{fakeResolver: &fakeResolver{txt: map[string][]string{}}, queries: map[string]int{}}
+
+	// A diamond 38 levels deep: every level includes the next one twice,
+	// which is 2^38 includes when each is followed in full.
+	const depth = 38
+
+	for i := 0; i < depth; i++ {
+		next := fmt.Sprintf("d%d.example", i+1)
+		r.txt[fmt.Sprintf("d%d.example", i)] = []string{fmt.Sprintf("v=spf1 include:%s include:%s", next, next)}
+	}
+
+	r.txt[fmt.Sprintf("d%d.example", depth)] = []string{"v=spf1 -all"}
+
+	// And one record that is wide instead of deep.
+	var wide []string
+
+	for i := 0; i < 1000; i++ {
+		wide = append(wide, "include:d37.example")
+	}
+
+	r.txt["wide.example"] = []string{"v=spf1 " + strings.Join(wide, " ") + " -all"}
+
+	for _, domain := range []string{"d0.example", "wide.example"} {
+		report := analyzeSPF(context.Background(), r, domain)
+
+		if !errors.Is(report.Err, errSPFLookupLimit) {
+			t.Errorf("analyzeSPF(%s) = %v, want the limit error", domain, report.Err)
+		}
+	}
+
+	total := 0
+
+	for name, n := range r.queries {
+		total += n
+
+		if n > 2 {
+			t.Errorf("%s queried %d times, want it fetched once per analysis", name, n)
+		}
+	}
+
+	if total > 2*(spfLookupLimit+2) {
+		t.Errorf("made %d TXT queries, want the walk to stop at the limit", total)
+	}
+}
+
+func TestAnalyzeSPF(t *testing.T) {
+	r := spfFixture()
+
+	tests := []struct {
+		domain      string
+		wantLookups int
+		wantErr     error
+		wantRecord  bool
+	}{
+		{domain: "example.com", wantLookups: 3, wantRecord: true},
+		{domain: "soft.example", wantLookups: 4, wantRecord: true},
+		{domain: "nothing.example"},
+		{domain: "twice.example", wantErr: errSPFMultiple, wantRecord: true},
+		{domain: "broken.example", wantErr: errSPFSyntax, wantRecord: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.domain, func(t *testing.T) {
+			report := analyzeSPF(context.Background(), r, tc.domain)
+
+			if report.Lookups != tc.wantLookups {
+				t.Errorf("Lookups = %d, want %d", report.Lookups, tc.wantLookups)
+			}
+
+			if (report.Record != "") != tc.wantRecord {
+				t.Errorf("Record = %q", report.Record)
+			}
+
+			if tc.wantErr == nil && report.Err != nil || tc.wantErr != nil && !errors.Is(report.Err, tc.wantErr) {
+				t.Errorf("Err = %v, want %v", report.Err, tc.wantErr)
+			}
+		})
+	}
+
+	for _, domain := range []string{"dangling.example", "loop.example", "unreachable.example"} {
+		if report := analyzeSPF(context.Background(), r, domain); report.Err == nil {
+			t.Errorf("analyzeSPF(%s) reported no problem", domain)
+		}
+	}
+}
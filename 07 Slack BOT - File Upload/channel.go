@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// channelIDPattern matches Slack conversation IDs: public (C), private (G)
+// and direct message (D) channels.
+var channelIDPattern = regexp.MustCompile(`^[CGD][A-Z0-9]{8,}$`)
+
+// resolveChannel returns the ID of the channel given by name or ID. Names
+// may start with "#". They are looked up with conversations.list, which
+// only includes private channels the bot is a member of.
+func resolveChannel(ctx context.Context, api slackAPI, channel string) (string, error) {
+	if channelIDPattern.MatchString(channel) {
+		return channel, nil
+	}
+
+	name := strings.ToLower(strings.TrimPrefix(channel, "#"))
+
+	if name == "" {
+		return "", errors.New("channel name is empty")
+	}
+
+	params := &slack.GetConversationsParameters{
+		ExcludeArchived: true,
+		Limit:           1000,
+		Types:           []string{"public_channel", "private_channel"},
+	}
+
+	for {
+		channels, cursor, err := api.GetConversationsContext(ctx, params)
+
+		var rateLimited *slack.RateLimitedError
+
+		if errors.As(err, &rateLimited) {
+			select {
+			case <-time.After(rateLimited.RetryAfter):
+				continue
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}
+
+		if err != nil {
+			return "", fmt.Errorf("listing channels: %w", err)
+		}
+
+		for _, c := range channels {
+			if c.Name == name {
+				return c.ID, nil
+			}
+		}
+
+		if cursor == "" {
+			return "", fmt.Errorf("channel #%s not found; for a private channel, invite the bot first", name)
+		}
+
+		params.Cursor = cursor
+	}
+}
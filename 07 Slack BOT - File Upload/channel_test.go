@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fbdaf/slackbot/slacktest"
+)
+
+func TestResolveChannel(t *testing.T) {
+	channels := []slacktest.Channel{
+		{ID: "C0000000001", Name: "general"},
+		{ID: "C0000000002", Name: "random"},
+		{ID: "C0000000003", Name: "reports"},
+		{ID: "G0000000004", Name: "finance"},
+		{ID: "C0000000005", Name: "support"},
+	}
+
+	tests := []struct {
+		channel   string
+		want      string
+		wantErr   string
+		wantPages int
+	}{
+		{"C0123456789", "C0123456789", "", 0},
+		{"general", "C0000000001", "", 1},
+		{"#Reports", "C0000000003", "", 2},
+		{"support", "C0000000005", "", 3},
+		{"missing", "", "channel #missing not found", 3},
+		{"#", "", "channel name is empty", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			fake := slacktest.NewServer(t)
+			fake.AddChannels(channels...)
+
+			got, err := resolveChannel(context.Background(), fake.Client(), tt.channel)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil || got != tt.want {
+				t.Fatalf("got %q, %v, want %q", got, err, tt.want)
+			}
+
+			if pages := len(fake.CallsTo("conversations.list")); pages != tt.wantPages {
+				t.Errorf("fetched %d pages, want %d", pages, tt.wantPages)
+			}
+		})
+	}
+}
+
+func TestResolveChannelRateLimited(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.AddChannels(slacktest.Channel{ID: "C0000000001", Name: "general"})
+	fake.Fail("conversations.list", slacktest.RateLimited(0), slacktest.RateLimited(0))
+
+	got, err := resolveChannel(context.Background(), fake.Client(), "general")
+
+	if err != nil || got != "C0000000001" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+
+	if calls := len(fake.CallsTo("conversations.list")); calls != 3 {
+		t.Errorf("made %d calls, want 3", calls)
+	}
+}
+
+func TestResolveChannelError(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.Fail("conversations.list", slacktest.SlackError("missing_scope"))
+
+	if _, err := resolveChannel(context.Background(), fake.Client(), "general"); err == nil || !strings.Contains(err.Error(), "missing_scope") {
+		t.Fatalf("error = %v, want missing_scope", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// uploadFile is a file to upload and its size, which Slack needs before
+// the upload starts.
+type uploadFile struct {
+	Path string
+	Size int64
+}
+
+// expandPaths turns the command-line arguments into the files to upload.
+// An argument can be a file, a glob such as "reports/*.csv", or a
+// directory. A directory contributes the regular files directly inside it,
+// or every file below it when recursive is set. Files named more than once
+// are uploaded once. Empty files are returned separately, since Slack does
+// not accept them.
+func expandPaths(args []string, recursive bool) (files []uploadFile, empty []string, err error) {
+	seen := map[string]bool{}
+
+	add := func(path string, info fs.FileInfo) {
+		path = filepath.Clean(path)
+
+		switch {
+		case seen[path]:
+		case info.Size() == 0:
+			empty = append(empty, path)
+		default:
+			files = append(files, uploadFile{Path: path, Size: info.Size()})
+		}
+
+		seen[path] = true
+	}
+
+	for _, arg := range args {
+		paths := []string{arg}
+
+		if strings.ContainsAny(arg, "*?[") {
+			matches, err := filepath.Glob(arg)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", arg, err)
+			}
+
+			if len(matches) == 0 {
+				return nil, nil, fmt.Errorf("%s: no files match", arg)
+			}
+
+			paths = matches
+		}
+
+		for _, path := range paths {
+			info, err := os.Stat(path)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			switch {
+			case info.IsDir():
+				if err := walkDir(path, recursive, add); err != nil {
+					return nil, nil, err
+				}
+			case info.Mode().IsRegular():
+				add(path, info)
+			default:
+				return nil, nil, fmt.Errorf("%s is not a regular file", path)
+			}
+		}
+	}
+
+	return files, empty, nil
+}
+
+// walkDir passes the regular files in dir to add, descending into
+// subdirectories when recursive is set. Hidden files and directories, such
+// as .git, are skipped.
+func walkDir(dir string, recursive bool, add func(string, fs.FileInfo)) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		hidden := path != dir && strings.HasPrefix(d.Name(), ".")
+
+		if d.IsDir() {
+			if path != dir && (hidden || !recursive) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if hidden || !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return err
+		}
+
+		add(path, info)
+
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) uploadFile {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return uploadFile{Path: path, Size: int64(len(content))}
+}
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"a.txt":        "a",
+		"b.csv":        "bb",
+		"empty.csv":    "",
+		".hidden":      "h",
+		"sub/c.csv":    "ccc",
+		".git/config":  "g",
+		"sub/deep/d.x": "dddd",
+	} {
+		writeFile(t, filepath.Join(dir, name), content)
+	}
+
+	p := func(name string) string { return filepath.Join(dir, name) }
+
+	tests := []struct {
+		name      string
+		args      []string
+		recursive bool
+		want      []string
+		wantEmpty []string
+		wantErr   string
+	}{
+		{"file", []string{p("a.txt")}, false, []string{p("a.txt")}, nil, ""},
+		{"directory", []string{dir}, false, []string{p("a.txt"), p("b.csv")}, []string{p("empty.csv")}, ""},
+		{"recursive", []string{dir}, true, []string{p("a.txt"), p("b.csv"), p("sub/c.csv"), p("sub/deep/d.x")}, []string{p("empty.csv")}, ""},
+		{"glob", []string{p("*.csv")}, false, []string{p("b.csv")}, []string{p("empty.csv")}, ""},
+		{"duplicates", []string{p("a.txt"), dir + "/./a.txt", p("*.txt")}, false, []string{p("a.txt")}, nil, ""},
+		{"no match", []string{p("*.pdf")}, false, nil, nil, "no files match"},
+		{"missing", []string{p("nope.txt")}, false, nil, nil, "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, empty, err := expandPaths(tt.args, tt.recursive)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+
+			for _, f := range files {
+				got = append(got, f.Path)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(empty, tt.wantEmpty) {
+				t.Fatalf("got %v and empty %v, want %v and %v", got, empty, tt.want, tt.wantEmpty)
+			}
+		})
+	}
+}
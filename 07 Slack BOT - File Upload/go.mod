@@ -2,8 +2,16 @@ module github.com/fbdaf/slack-bot-file-upload
 
 go 1.21.4
 
+require (
+	github.com/fbdaf/slackbot v0.0.0
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/joho/godotenv v1.5.1
+	github.com/slack-go/slack v0.15.0
+)
+
 require (
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/slack-go/slack v0.15.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
 )
+
+replace github.com/fbdaf/slackbot => ../slackbot
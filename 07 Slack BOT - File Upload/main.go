@@ -1,49 +1,203 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
 	"github.com/joho/godotenv"
 	"github.com/slack-go/slack"
 )
 
+func usage() {
+	name := filepath.Base(os.Args[0])
+
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <file|glob|directory>...\n       %s -watch <directory> [flags]\n\nFlags:\n", name, name)
+	flag.PrintDefaults()
+}
+
 func main() {
-	// Load .env file
+	channel := flag.String("channel", "", "channel name or ID to upload to (default $SLACK_CHANNEL)")
+	recursive := flag.Bool("r", false, "upload the files in subdirectories of directory arguments too")
+	comment := flag.String("comment", "", "message posted with the first file (with every file in watch mode)")
+	title := flag.String("title", "", "title for the uploaded files (default: the file name)")
+	thread := flag.String("thread", "", "timestamp of the message whose thread the files are posted in")
+	workers := flag.Int("workers", 4, "number of files uploaded at the same time")
+	retries := flag.Int("retries", 5, "times a file is tried again after a rate limit or transient error")
+	manifestPath := flag.String("manifest", "", "JSON file recording uploads; files uploaded before with the same contents are skipped")
+	deleteSuperseded := flag.Bool("delete-superseded", false, "delete the earlier Slack upload of a file that has changed (needs -manifest)")
+	watchDir := flag.String("watch", "", "keep running and upload the files that appear in this directory")
+	archive := flag.String("archive", "", "in watch mode, where uploaded files are moved (default <watch>/.archive)")
+	stable := flag.Duration("stable", 5*time.Second, "in watch mode, how long a file's size must stay the same before it is uploaded")
+	var routes routeFlag
+	flag.Var(&routes, "route", "in watch mode, send files under a subdirectory to a channel, as subdir=channel (repeatable)")
+	flag.Usage = usage
+	flag.Parse()
+
+	// Load .env file. It is optional when the variables are set already.
 	err := godotenv.Load()
-	if err != nil {
-		fmt.Printf("Error loading .env file: %v\n", err)
-		return
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Error loading .env file: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *channel == "" {
+		*channel = os.Getenv("SLACK_CHANNEL")
+	}
+
+	if *watchDir != "" && (flag.NArg() > 0 || (*channel == "" && len(routes) == 0)) ||
+		*watchDir == "" && (flag.NArg() == 0 || *channel == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *workers < 1 || *retries < 0 {
+		fmt.Fprintf(os.Stderr, "Error: -workers must be at least 1 and -retries cannot be negative\n")
+		os.Exit(2)
+	}
+
+	if *deleteSuperseded && *manifestPath == "" {
+		fmt.Fprintf(os.Stderr, "Error: -delete-superseded needs -manifest\n")
+		os.Exit(2)
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	api := slack.New(os.Getenv("SLACK_BOT_TOKEN"))
 
 	// Test the API connection first
-	_, err = api.AuthTest()
+	_, err = api.AuthTestContext(ctx)
 	if err != nil {
-		fmt.Printf("Auth Error: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "Auth Error: %v\n", err)
+		os.Exit(1)
 	}
 
-	channelID := "C07U5CH6MFF"
-	fileArr := []string{"sherlock.txt"}
+	var channelID string
 
-	for i := 0; i < len(fileArr); i++ {
-		file, err := api.UploadFileV2(slack.UploadFileV2Parameters{
-			Channel: channelID,
-			File:    fileArr[i],
-			Filename:       fileArr[i],
-			FileSize:       100,
-		})
+	if *channel != "" {
+		channelID, err = resolveChannel(ctx, api, *channel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Channel Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	u := &uploader{
+		api:              api,
+		workers:          *workers,
+		retries:          *retries,
+		backoff:          time.Second,
+		deleteSuperseded: *deleteSuperseded,
+	}
+
+	if *manifestPath != "" {
+		u.manifest, err = loadManifest(*manifestPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *watchDir != "" {
+		for i, r := range routes {
+			routes[i].Channel, err = resolveChannel(ctx, api, r.Channel)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Channel Error: -route %s: %v\n", r.Dir, err)
+				os.Exit(1)
+			}
+		}
+
+		if *archive == "" {
+			*archive = filepath.Join(*watchDir, ".archive")
+		}
+
+		root, err := filepath.Abs(*watchDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		archiveDir, err := filepath.Abs(*archive)
 		if err != nil {
-			// More detailed error handling
-			if rateLimitErr, ok := err.(*slack.RateLimitedError); ok {
-				fmt.Printf("Rate limit error: %s\n", rateLimitErr)
-			} else {
-				fmt.Printf("Upload Error: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		w := newWatcher(root, archiveDir, *stable, routes, channelID, u, func(f uploadFile, channelID string) slack.UploadFileV2Parameters {
+			return slack.UploadFileV2Parameters{
+				Channel:         channelID,
+				File:            f.Path,
+				Filename:        filepath.Base(f.Path),
+				FileSize:        int(f.Size),
+				Title:           *title,
+				InitialComment:  *comment,
+				ThreadTimestamp: *thread,
 			}
-			return
+		})
+
+		log.Printf("Watching %s, archiving to %s", root, archiveDir)
+
+		if err := w.run(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Watch Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	files, empty, err := expandPaths(flag.Args(), *recursive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, path := range empty {
+		fmt.Fprintf(os.Stderr, "Skipping %s: Slack does not accept empty files\n", path)
+	}
+
+	if len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no files to upload\n")
+		os.Exit(1)
+	}
+
+	u.done = func(r uploadResult) {
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(os.Stderr, "Upload Error: %s: %v\n", r.File.Path, r.Err)
+		case r.Unchanged:
+			fmt.Fprintf(os.Stderr, "Unchanged %s\n", r.File.Path)
+		default:
+			fmt.Fprintf(os.Stderr, "Uploaded %s\n", r.File.Path)
 		}
-		fmt.Printf("Name: %s, URL: %s\n", file.ID, file.Title)
-		fmt.Printf("file: %v\n", file)
+	}
+
+	results := u.uploadAll(ctx, files, func(i int) slack.UploadFileV2Parameters {
+		params := slack.UploadFileV2Parameters{
+			Channel:         channelID,
+			File:            files[i].Path,
+			Filename:        filepath.Base(files[i].Path),
+			FileSize:        int(files[i].Size),
+			Title:           *title,
+			ThreadTimestamp: *thread,
+		}
+
+		if i == 0 {
+			params.InitialComment = *comment
+		}
+
+		return params
+	})
+
+	if printSummary(os.Stdout, results) > 0 {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// manifestEntry records the last upload of a file to a channel.
+type manifestEntry struct {
+	// Path is absolute, so runs from different directories agree.
+	Path       string    `json:"path"`
+	Channel    string    `json:"channel"`
+	SHA256     string    `json:"sha256"`
+	Size       int64     `json:"size"`
+	FileID     string    `json:"fileId"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+type manifestKey struct {
+	path    string
+	channel string
+}
+
+// manifest is a JSON file of what has been uploaded where. It is saved
+// after every upload, so an interrupted run can be started again and only
+// uploads what is left.
+type manifest struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[manifestKey]manifestEntry
+}
+
+type manifestFile struct {
+	Version int             `json:"version"`
+	Files   []manifestEntry `json:"files"`
+}
+
+// loadManifest reads the manifest at path. A missing file is an empty
+// manifest.
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{path: path, entries: map[manifestKey]manifestEntry{}}
+
+	data, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f manifestFile
+
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", path, err)
+	}
+
+	if f.Version != 1 {
+		return nil, fmt.Errorf("manifest %s: unsupported version %d", path, f.Version)
+	}
+
+	for _, e := range f.Files {
+		m.entries[manifestKey{e.Path, e.Channel}] = e
+	}
+
+	return m, nil
+}
+
+func (m *manifest) get(path, channel string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[manifestKey{path, channel}]
+
+	return e, ok
+}
+
+// record stores e and saves the manifest.
+func (m *manifest) record(e manifestEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[manifestKey{e.Path, e.Channel}] = e
+
+	return m.save()
+}
+
+// save writes the manifest to a temporary file and renames it into place,
+// so a crash never leaves a half-written manifest. The caller holds m.mu.
+func (m *manifest) save() error {
+	f := manifestFile{Version: 1, Files: make([]manifestEntry, 0, len(m.entries))}
+
+	for _, e := range m.entries {
+		f.Files = append(f.Files, e)
+	}
+
+	sort.Slice(f.Files, func(i, j int) bool {
+		if f.Files[i].Path != f.Files[j].Path {
+			return f.Files[i].Path < f.Files[j].Path
+		}
+
+		return f.Files[i].Channel < f.Files[j].Channel
+	})
+
+	data, err := json.MarshalIndent(f, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.path)
+}
+
+// hashFile returns the hex SHA-256 of the file's contents.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+
+	m, err := loadManifest(path)
+
+	if err != nil {
+		t.Fatalf("missing manifest: %v", err)
+	}
+
+	if _, ok := m.get("/srv/a.csv", "C1"); ok {
+		t.Fatal("empty manifest has an entry")
+	}
+
+	entries := []manifestEntry{
+		{Path: "/srv/b.csv", Channel: "C1", SHA256: "bb", Size: 2, FileID: "F2", UploadedAt: time.Date(2024, 11, 22, 9, 0, 0, 0, time.UTC)},
+		{Path: "/srv/a.csv", Channel: "C2", SHA256: "aa", Size: 1, FileID: "F1", UploadedAt: time.Date(2024, 11, 22, 9, 0, 1, 0, time.UTC)},
+		{Path: "/srv/a.csv", Channel: "C1", SHA256: "aa", Size: 1, FileID: "F3", UploadedAt: time.Date(2024, 11, 22, 9, 0, 2, 0, time.UTC)},
+	}
+
+	for _, e := range entries {
+		if err := m.record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries are sorted by path and channel, so the file diffs well.
+	if a1, a2, b1 := strings.Index(string(data), `"F3"`), strings.Index(string(data), `"F1"`), strings.Index(string(data), `"F2"`); !(a1 < a2 && a2 < b1) {
+		t.Errorf("entries are not sorted:\n%s", data)
+	}
+
+	reloaded, err := loadManifest(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range entries {
+		if got, ok := reloaded.get(want.Path, want.Channel); !ok || got != want {
+			t.Errorf("get(%s, %s) = %+v, %v, want %+v", want.Path, want.Channel, got, ok, want)
+		}
+	}
+
+	if leftovers, _ := filepath.Glob(path + ".*.tmp"); len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestLoadManifestErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		content string
+		wantErr string
+	}{
+		{`{"version": 2, "files": []}`, "unsupported version 2"},
+		{`not json`, "invalid character"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, "manifest.json")
+
+		if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadManifest(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("loadManifest(%s) error = %v, want %q", tt.content, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+
+	"github.com/slack-go/slack"
+)
+
+// slackAPI is the part of the Slack Web API the uploader calls. *slack.Client
+// implements it; tests point one at a fake server with slack.OptionAPIURL.
+type slackAPI interface {
+	AuthTestContext(ctx context.Context) (*slack.AuthTestResponse, error)
+	GetConversationsContext(ctx context.Context, params *slack.GetConversationsParameters) ([]slack.Channel, string, error)
+	UploadFileV2Context(ctx context.Context, params slack.UploadFileV2Parameters) (*slack.FileSummary, error)
+	DeleteFileContext(ctx context.Context, fileID string) error
+}
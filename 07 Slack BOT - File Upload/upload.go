@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// maxBackoff caps the delay between retries of one file.
+const maxBackoff = time.Minute
+
+// uploadResult is the outcome of one file.
+type uploadResult struct {
+	File     uploadFile
+	FileID   string
+	Attempts int
+	Err      error
+	// Unchanged is set when the manifest shows the file was uploaded to
+	// the channel before with the same contents, so it was skipped.
+	Unchanged bool
+	// Superseded is the Slack file ID of an earlier upload of the file that
+	// this one replaces.
+	Superseded string
+	// Deleted is set when Superseded was deleted, and DeleteErr when that
+	// failed.
+	Deleted   bool
+	DeleteErr error
+}
+
+// uploader runs uploads on a bounded pool of workers. A rate-limited
+// request pauses every worker for the time Slack asks for, since the limit
+// applies to the whole app and not to a single upload.
+type uploader struct {
+	api     slackAPI
+	workers int
+	// retries is how many times a file is tried again after a transient
+	// failure.
+	retries int
+	// backoff is the base delay between retries. It doubles with each
+	// attempt, and a random half of it is taken off so that workers that
+	// failed together do not retry together.
+	backoff time.Duration
+	// done, if set, is called as each file finishes.
+	done func(uploadResult)
+	// manifest, if set, is used to skip files that have not changed since
+	// they were last uploaded to the channel, and records every upload.
+	manifest *manifest
+	// deleteSuperseded deletes the Slack file a changed file replaces.
+	deleteSuperseded bool
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+// uploadAll uploads files and returns a result for each, in the same
+// order. A failed file does not stop the others. params builds the upload
+// request for the i-th file.
+func (u *uploader) uploadAll(ctx context.Context, files []uploadFile, params func(i int) slack.UploadFileV2Parameters) []uploadResult {
+	results := make([]uploadResult, len(files))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < u.workers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				results[i] = u.upload(ctx, files[i], params(i))
+
+				if u.done != nil {
+					u.done(results[i])
+				}
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func (u *uploader) upload(ctx context.Context, f uploadFile, params slack.UploadFileV2Parameters) uploadResult {
+	r := uploadResult{File: f}
+
+	var entry manifestEntry
+
+	if u.manifest != nil {
+		path, err := filepath.Abs(f.Path)
+
+		if err != nil {
+			r.Err = err
+			return r
+		}
+
+		hash, err := hashFile(f.Path)
+
+		if err != nil {
+			r.Err = err
+			return r
+		}
+
+		entry = manifestEntry{Path: path, Channel: params.Channel, Size: f.Size}
+
+		if prev, ok := u.manifest.get(path, params.Channel); ok {
+			if prev.SHA256 == hash {
+				r.FileID, r.Unchanged = prev.FileID, true
+				return r
+			}
+
+			r.Superseded = prev.FileID
+		}
+	}
+
+	var sent hash.Hash
+
+	r.Attempts, r.Err = u.retry(ctx, func() error {
+		if u.manifest != nil {
+			// The file may change after it was hashed above. Uploading from
+			// a reader that hashes what it sends makes the manifest record
+			// the contents Slack got, so a later run compares against those.
+			in, err := os.Open(f.Path)
+
+			if err != nil {
+				return err
+			}
+
+			defer in.Close()
+
+			sent = sha256.New()
+			params.File, params.Reader = "", io.TeeReader(in, sent)
+		}
+
+		file, err := u.api.UploadFileV2Context(ctx, params)
+
+		if err == nil {
+			r.FileID = file.ID
+		}
+
+		return err
+	})
+
+	if r.Err != nil || u.manifest == nil {
+		return r
+	}
+
+	entry.SHA256 = hex.EncodeToString(sent.Sum(nil))
+	entry.FileID, entry.UploadedAt = r.FileID, time.Now().UTC()
+
+	if err := u.manifest.record(entry); err != nil {
+		r.Err = fmt.Errorf("uploaded as %s, but the manifest could not be saved: %w", r.FileID, err)
+		return r
+	}
+
+	if u.deleteSuperseded && r.Superseded != "" {
+		_, err := u.retry(ctx, func() error {
+			return u.api.DeleteFileContext(ctx, r.Superseded)
+		})
+
+		var resp slack.SlackErrorResponse
+
+		if errors.As(err, &resp) && (resp.Err == "file_not_found" || resp.Err == "file_deleted") {
+			err = nil
+		}
+
+		r.Deleted, r.DeleteErr = err == nil, err
+	}
+
+	return r
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// retryable, or has been retried u.retries times. It returns the number of
+// attempts and the last error.
+func (u *uploader) retry(ctx context.Context, fn func() error) (int, error) {
+	for attempt := 1; ; attempt++ {
+		if err := u.waitForPause(ctx); err != nil {
+			return attempt - 1, err
+		}
+
+		err := fn()
+
+		if err == nil || !retryable(err) || attempt > u.retries || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		var rateLimited *slack.RateLimitedError
+
+		if errors.As(err, &rateLimited) {
+			u.pause(rateLimited.RetryAfter + jitter(time.Second))
+			continue
+		}
+
+		delay := u.backoff << (attempt - 1)
+
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+
+		if sleep(ctx, delay/2+jitter(delay/2)) != nil {
+			return attempt, err
+		}
+	}
+}
+
+// pause holds back every worker for d.
+func (u *uploader) pause(d time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(u.pauseUntil) {
+		u.pauseUntil = until
+	}
+}
+
+func (u *uploader) waitForPause(ctx context.Context) error {
+	u.mu.Lock()
+	d := time.Until(u.pauseUntil)
+	u.mu.Unlock()
+
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	return sleep(ctx, d)
+}
+
+// retryable reports whether err is worth another attempt: rate limits,
+// server errors and network failures. Errors such as not_in_channel or
+// invalid_auth are not.
+func retryable(err error) bool {
+	var rateLimited *slack.RateLimitedError
+
+	if errors.As(err, &rateLimited) {
+		return true
+	}
+
+	var status slack.StatusCodeError
+
+	if errors.As(err, &status) {
+		return status.Retryable()
+	}
+
+	var resp slack.SlackErrorResponse
+
+	if errors.As(err, &resp) {
+		switch resp.Err {
+		case "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+			return true
+		}
+
+		return false
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr)
+}
+
+// jitter returns a random duration in [0, max).
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// printSummary writes a table with one row per file and returns the
+// number of failures.
+func printSummary(w io.Writer, results []uploadResult) int {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	uploaded, unchanged, failed := 0, 0, 0
+
+	fmt.Fprintln(tw, "FILE\tSIZE\tSTATUS\tATTEMPTS\tFILE ID / ERROR")
+
+	for _, r := range results {
+		status, detail := "uploaded", r.FileID
+
+		switch {
+		case r.Err != nil:
+			status, detail = "failed", r.Err.Error()
+			failed++
+		case r.Unchanged:
+			status = "unchanged"
+			unchanged++
+		case r.Superseded != "":
+			status = "replaced"
+			uploaded++
+
+			switch {
+			case r.Deleted:
+				detail += fmt.Sprintf(" (deleted %s)", r.Superseded)
+			case r.DeleteErr != nil:
+				detail += fmt.Sprintf(" (deleting %s failed: %v)", r.Superseded, r.DeleteErr)
+			default:
+				detail += fmt.Sprintf(" (replaces %s)", r.Superseded)
+			}
+		default:
+			uploaded++
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", r.File.Path, formatSize(r.File.Size), status, r.Attempts, detail)
+	}
+
+	tw.Flush()
+
+	fmt.Fprintf(w, "\n%d uploaded, %d unchanged, %d failed\n", uploaded, unchanged, failed)
+
+	return failed
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/slack-go/slack"
+)
+
+func newTestUploader(fake *slacktest.Server) *uploader {
+	return &uploader{api: fake.Client(), workers: 2, retries: 3, backoff: time.Millisecond}
+}
+
+func paramsFor(files []uploadFile, channel string) func(int) slack.UploadFileV2Parameters {
+	return func(i int) slack.UploadFileV2Parameters {
+		return slack.UploadFileV2Parameters{
+			Channel:  channel,
+			File:     files[i].Path,
+			Filename: filepath.Base(files[i].Path),
+			FileSize: int(files[i].Size),
+		}
+	}
+}
+
+func TestUploadAll(t *testing.T) {
+	dir := t.TempDir()
+	fake := slacktest.NewServer(t)
+
+	files := []uploadFile{
+		writeFile(t, filepath.Join(dir, "a.txt"), "alpha"),
+		writeFile(t, filepath.Join(dir, "b.txt"), "bravo"),
+		writeFile(t, filepath.Join(dir, "c.txt"), "charlie"),
+	}
+
+	var done []string
+
+	u := newTestUploader(fake)
+	u.workers = 1
+	u.done = func(r uploadResult) { done = append(done, r.File.Path) }
+
+	results := u.uploadAll(context.Background(), files, paramsFor(files, "C0001"))
+
+	if len(results) != len(files) || len(done) != len(files) {
+		t.Fatalf("got %d results and %d done calls, want %d", len(results), len(done), len(files))
+	}
+
+	for i, r := range results {
+		if r.Err != nil || r.File != files[i] || r.FileID == "" || r.Attempts != 1 {
+			t.Errorf("result %d = %+v", i, r)
+		}
+	}
+
+	uploads := fake.CallsTo("upload")
+	completes := fake.CallsTo("files.completeUploadExternal")
+
+	if len(uploads) != 3 || len(completes) != 3 {
+		t.Fatalf("got %d uploads and %d completions, want 3", len(uploads), len(completes))
+	}
+
+	for i, want := range []string{"alpha", "bravo", "charlie"} {
+		if uploads[i].Content != want {
+			t.Errorf("upload %d sent %q, want %q", i, uploads[i].Content, want)
+		}
+
+		if got := completes[i].Form.Get("channel_id"); got != "C0001" {
+			t.Errorf("upload %d went to %q, want C0001", i, got)
+		}
+	}
+}
+
+func TestUploadRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     []slacktest.Failure
+		wantAttempts int
+		wantErr      string
+	}{
+		{"rate limited", []slacktest.Failure{slacktest.RateLimited(0)}, 2, ""},
+		{"server error", []slacktest.Failure{slacktest.HTTPStatus(503), slacktest.HTTPStatus(500)}, 3, ""},
+		{"transient slack error", []slacktest.Failure{slacktest.SlackError("internal_error")}, 2, ""},
+		{"permanent slack error", []slacktest.Failure{slacktest.SlackError("not_in_channel")}, 1, "not_in_channel"},
+		{"too many failures", []slacktest.Failure{slacktest.HTTPStatus(500), slacktest.HTTPStatus(500), slacktest.HTTPStatus(500), slacktest.HTTPStatus(500)}, 4, "500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := slacktest.NewServer(t)
+			fake.Fail("files.getUploadURLExternal", tt.failures...)
+
+			files := []uploadFile{writeFile(t, filepath.Join(t.TempDir(), "a.txt"), "alpha")}
+			results := newTestUploader(fake).uploadAll(context.Background(), files, paramsFor(files, "C0001"))
+			r := results[0]
+
+			if r.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", r.Attempts, tt.wantAttempts)
+			}
+
+			switch {
+			case tt.wantErr == "" && r.Err != nil:
+				t.Errorf("unexpected error: %v", r.Err)
+			case tt.wantErr != "" && (r.Err == nil || !strings.Contains(r.Err.Error(), tt.wantErr)):
+				t.Errorf("error = %v, want one containing %q", r.Err, tt.wantErr)
+			}
+
+			if got := len(fake.CallsTo("files.getUploadURLExternal")); got != tt.wantAttempts {
+				t.Errorf("made %d requests, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestUploadRateLimitPausesAllWorkers(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.Fail("files.getUploadURLExternal", slacktest.RateLimited(1))
+
+	dir := t.TempDir()
+	files := []uploadFile{
+		writeFile(t, filepath.Join(dir, "a.txt"), "alpha"),
+		writeFile(t, filepath.Join(dir, "b.txt"), "bravo"),
+	}
+
+	u := newTestUploader(fake)
+	start := time.Now()
+	results := u.uploadAll(context.Background(), files, paramsFor(files, "C0001"))
+
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatalf("%s: %v", r.File.Path, r.Err)
+		}
+	}
+
+	if !u.pauseUntil.After(start.Add(time.Second)) {
+		t.Errorf("pauseUntil = %v, want at least a second after the start", u.pauseUntil)
+	}
+}
+
+func TestUploadCancelled(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.Fail("files.getUploadURLExternal", slacktest.RateLimited(60))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	files := []uploadFile{writeFile(t, filepath.Join(t.TempDir(), "a.txt"), "alpha")}
+	results := newTestUploader(fake).uploadAll(ctx, files, paramsFor(files, "C0001"))
+
+	if !errors.Is(results[0].Err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want the context's", results[0].Err)
+	}
+}
+
+func TestUploadWithManifest(t *testing.T) {
+	dir := t.TempDir()
+	fake := slacktest.NewServer(t)
+
+	m, err := loadManifest(filepath.Join(dir, "manifest.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := newTestUploader(fake)
+	u.manifest = m
+	u.deleteSuperseded = true
+
+	path := filepath.Join(dir, "report.csv")
+	run := func(content string) uploadResult {
+		t.Helper()
+
+		files := []uploadFile{writeFile(t, path, content)}
+
+		return u.uploadAll(context.Background(), files, paramsFor(files, "C0001"))[0]
+	}
+
+	first := run("v1")
+
+	if first.Err != nil || first.Unchanged || first.Superseded != "" {
+		t.Fatalf("first upload = %+v", first)
+	}
+
+	again := run("v1")
+
+	if !again.Unchanged || again.FileID != first.FileID {
+		t.Fatalf("unchanged upload = %+v", again)
+	}
+
+	if got := len(fake.CallsTo("upload")); got != 1 {
+		t.Fatalf("unchanged file was uploaded again: %d uploads", got)
+	}
+
+	changed := run("v2")
+
+	if changed.Err != nil || changed.Superseded != first.FileID || !changed.Deleted {
+		t.Fatalf("changed upload = %+v", changed)
+	}
+
+	deletes := fake.CallsTo("files.delete")
+
+	if len(deletes) != 1 || deletes[0].Form.Get("file") != first.FileID {
+		t.Fatalf("files.delete calls = %+v", deletes)
+	}
+
+	// A file that is already gone counts as deleted; other errors are
+	// reported without failing the upload.
+	fake.Fail("files.delete", slacktest.SlackError("file_not_found"))
+
+	if r := run("v3"); r.Err != nil || !r.Deleted {
+		t.Fatalf("upload after file_not_found = %+v", r)
+	}
+
+	fake.Fail("files.delete", slacktest.SlackError("cant_delete_file"))
+
+	if r := run("v4"); r.Err != nil || r.Deleted || r.DeleteErr == nil {
+		t.Fatalf("upload after cant_delete_file = %+v", r)
+	}
+
+	reloaded, err := loadManifest(m.path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	abs, _ := filepath.Abs(path)
+
+	if e, ok := reloaded.get(abs, "C0001"); !ok || e.Size != 2 || e.FileID == first.FileID {
+		t.Fatalf("manifest entry = %+v, %v", e, ok)
+	}
+}
+
+func TestUploadRecordsContentsSent(t *testing.T) {
+	dir := t.TempDir()
+	fake := slacktest.NewServer(t)
+
+	m, err := loadManifest(filepath.Join(dir, "manifest.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := newTestUploader(fake)
+	u.manifest = m
+
+	path := filepath.Join(dir, "report.csv")
+	files := []uploadFile{writeFile(t, path, "v1")}
+
+	// The file is rewritten after it was hashed, while the upload is
+	// under way.
+	fake.Handle("files.getUploadURLExternal", func(slacktest.Call) interface{} {
+		if err := os.WriteFile(path, []byte("v2"), 0o644); err != nil {
+			t.Error(err)
+		}
+
+		return map[string]interface{}{"ok": true, "file_id": "F0001", "upload_url": fake.URL() + "/upload/F0001"}
+	})
+
+	if r := u.uploadAll(context.Background(), files, paramsFor(files, "C0001"))[0]; r.Err != nil {
+		t.Fatalf("upload = %+v", r)
+	}
+
+	if uploads := fake.CallsTo("upload"); len(uploads) != 1 || uploads[0].Content != "v2" {
+		t.Fatalf("uploads = %+v, want v2 once", uploads)
+	}
+
+	// Slack has v2, so a run over v2 skips the file.
+	if r := u.uploadAll(context.Background(), files, paramsFor(files, "C0001"))[0]; !r.Unchanged {
+		t.Fatalf("second upload = %+v, want it unchanged", r)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&slack.RateLimitedError{RetryAfter: time.Second}, true},
+		{slack.StatusCodeError{Code: 502, Status: "Bad Gateway"}, true},
+		{slack.StatusCodeError{Code: 404, Status: "Not Found"}, false},
+		{slack.SlackErrorResponse{Err: "service_unavailable"}, true},
+		{slack.SlackErrorResponse{Err: "invalid_auth"}, false},
+		{errors.New("file.upload.v2: file size cannot be 0"), false},
+	}
+
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.want {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	results := []uploadResult{
+		{File: uploadFile{Path: "a.csv", Size: 12700}, FileID: "F1", Attempts: 1},
+		{File: uploadFile{Path: "b.csv", Size: 10}, FileID: "F1", Unchanged: true},
+		{File: uploadFile{Path: "c.pdf", Size: 3 << 20}, FileID: "F3", Attempts: 2, Superseded: "F2", Deleted: true},
+		{File: uploadFile{Path: "d.pdf", Size: 1}, Attempts: 1, Err: errors.New("not_in_channel")},
+	}
+
+	var buf bytes.Buffer
+
+	if failed := printSummary(&buf, results); failed != 1 {
+		t.Errorf("failed = %d, want 1", failed)
+	}
+
+	want := `FILE   SIZE      STATUS     ATTEMPTS  FILE ID / ERROR
+a.csv  12.4 KiB  uploaded   1         F1
+b.csv  10 B      unchanged  0         F1
+c.pdf  3.0 MiB   replaced   2         F3 (deleted F2)
+d.pdf  1 B       failed     1         not_in_channel
+
+2 uploaded, 1 unchanged, 1 failed
+`
+
+	if buf.String() != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/slack-go/slack"
+)
+
+// route sends the files under Dir, a path relative to the watched
+// directory, to Channel.
+type route struct {
+	Dir     string
+	Channel string
+}
+
+// routeFlag collects -route dir=channel flags.
+type routeFlag []route
+
+func (f *routeFlag) String() string {
+	parts := make([]string, len(*f))
+
+	for i, r := range *f {
+		parts[i] = r.Dir + "=" + r.Channel
+	}
+
+	return strings.Join(parts, ",")
+}
+
+func (f *routeFlag) Set(value string) error {
+	dir, channel, ok := strings.Cut(value, "=")
+
+	if !ok || channel == "" {
+		return errors.New("want subdirectory=channel")
+	}
+
+	dir = filepath.ToSlash(filepath.Clean(dir))
+
+	if filepath.IsAbs(dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+		return fmt.Errorf("%s is not a subdirectory of the watched directory", dir)
+	}
+
+	*f = append(*f, route{Dir: dir, Channel: channel})
+
+	return nil
+}
+
+// pendingFile is a file that has been written to and is waiting for its
+// size and modification time to stop changing.
+type pendingFile struct {
+	size  int64
+	mod   time.Time
+	since time.Time
+}
+
+// fileState identifies a version of a file, so one that failed to upload
+// is tried again at once when it changes.
+type fileState struct {
+	size int64
+	mod  time.Time
+}
+
+func (s fileState) same(o fileState) bool {
+	return s.size == o.size && s.mod.Equal(o.mod)
+}
+
+// failedFile is a file whose upload failed. The same version is tried
+// again at retryAt.
+type failedFile struct {
+	state    fileState
+	attempts int
+	retryAt  time.Time
+}
+
+// The first retry of a failed file waits watchBackoff, and each one after
+// that twice as long as the one before, up to watchMaxBackoff.
+const (
+	watchBackoff    = time.Minute
+	watchMaxBackoff = time.Hour
+)
+
+type processed struct {
+	path  string
+	state fileState
+	ok    bool
+}
+
+// watcher uploads the files that appear under root once they have stopped
+// changing for stable, then moves them into archive.
+type watcher struct {
+	root    string
+	archive string
+	stable  time.Duration
+	// routes are tried longest directory first; files outside all of
+	// them go to channel. Both hold channel IDs.
+	routes   []route
+	channel  string
+	uploader *uploader
+	params   func(f uploadFile, channelID string) slack.UploadFileV2Parameters
+	// backoff and maxBackoff bound how long a failed file waits before
+	// it is tried again.
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	// The fields below are only used by the run loop.
+	pending  map[string]*pendingFile
+	inFlight map[string]bool
+	failed   map[string]*failedFile
+	ready    []string
+}
+
+func newWatcher(root, archive string, stable time.Duration, routes []route, channel string, u *uploader, params func(uploadFile, string) slack.UploadFileV2Parameters) *watcher {
+	routes = append([]route(nil), routes...)
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Dir) > len(routes[j].Dir)
+	})
+
+	return &watcher{
+		root:       filepath.Clean(root),
+		archive:    filepath.Clean(archive),
+		stable:     stable,
+		routes:     routes,
+		channel:    channel,
+		uploader:   u,
+		params:     params,
+		backoff:    watchBackoff,
+		maxBackoff: watchMaxBackoff,
+		pending:    map[string]*pendingFile{},
+		inFlight:   map[string]bool{},
+		failed:     map[string]*failedFile{},
+	}
+}
+
+// run watches until ctx is cancelled. Uploads in progress are cancelled
+// too, and their files are left in place for the next run.
+func (w *watcher) run(ctx context.Context) error {
+	fw, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	defer fw.Close()
+
+	// Files that are already there when the watch starts are uploaded too.
+	if err := w.addTree(fw, w.root, time.Now()); err != nil {
+		return err
+	}
+
+	jobs := make(chan string)
+	results := make(chan processed)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < w.uploader.workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for path := range jobs {
+				results <- w.process(ctx, path)
+			}
+		}()
+	}
+
+	defer func() {
+		close(jobs)
+
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		for range results {
+		}
+	}()
+
+	tick := w.stable / 4
+
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		var send chan string
+		var next string
+
+		if len(w.ready) > 0 {
+			send, next = jobs, w.ready[0]
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-fw.Events:
+			if !ok {
+				return nil
+			}
+
+			w.handle(fw, ev, time.Now())
+		case err, ok := <-fw.Errors:
+			if !ok {
+				return nil
+			}
+
+			log.Printf("Watch Error: %v", err)
+		case now := <-ticker.C:
+			w.tick(now)
+		case send <- next:
+			w.ready = w.ready[1:]
+		case p := <-results:
+			w.finish(p, time.Now())
+		}
+	}
+}
+
+// ignored reports whether path is hidden or inside the archive.
+func (w *watcher) ignored(path string) bool {
+	if path == w.archive || strings.HasPrefix(path, w.archive+string(filepath.Separator)) {
+		return true
+	}
+
+	rel, err := filepath.Rel(w.root, path)
+
+	if err != nil || rel == "." {
+		return err != nil
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addTree watches dir and its subdirectories and marks the files in them
+// as pending.
+func (w *watcher) addTree(fw *fsnotify.Watcher, dir string, now time.Time) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The directory may have been removed again already.
+			if errors.Is(err, fs.ErrNotExist) && path != dir {
+				return nil
+			}
+
+			return err
+		}
+
+		if w.ignored(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() {
+			return fw.Add(path)
+		}
+
+		if d.Type().IsRegular() {
+			w.markPending(path, now)
+		}
+
+		return nil
+	})
+}
+
+func (w *watcher) handle(fw *fsnotify.Watcher, ev fsnotify.Event, now time.Time) {
+	path := filepath.Clean(ev.Name)
+
+	if w.ignored(path) {
+		return
+	}
+
+	switch {
+	case ev.Has(fsnotify.Create):
+		info, err := os.Lstat(path)
+
+		if err != nil {
+			return
+		}
+
+		if info.IsDir() {
+			if err := w.addTree(fw, path, now); err != nil {
+				log.Printf("Watch Error: %s: %v", path, err)
+			}
+
+			return
+		}
+
+		if info.Mode().IsRegular() {
+			w.markPending(path, now)
+		}
+	case ev.Has(fsnotify.Write):
+		w.markPending(path, now)
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		delete(w.pending, path)
+		delete(w.failed, path)
+	}
+}
+
+func (w *watcher) markPending(path string, now time.Time) {
+	if w.inFlight[path] {
+		return
+	}
+
+	if p, ok := w.pending[path]; ok {
+		p.since = now
+		return
+	}
+
+	w.pending[path] = &pendingFile{size: -1, since: now}
+}
+
+// tick queues the pending files whose size and modification time have not
+// changed for w.stable.
+func (w *watcher) tick(now time.Time) {
+	var ready []string
+
+	for path, p := range w.pending {
+		info, err := os.Stat(path)
+
+		if err != nil || !info.Mode().IsRegular() {
+			delete(w.pending, path)
+			continue
+		}
+
+		if info.Size() != p.size || !info.ModTime().Equal(p.mod) {
+			p.size, p.mod, p.since = info.Size(), info.ModTime(), now
+			continue
+		}
+
+		if now.Sub(p.since) < w.stable {
+			continue
+		}
+
+		state := fileState{size: info.Size(), mod: info.ModTime()}
+
+		if f := w.failed[path]; f != nil && f.state.same(state) {
+			if now.Before(f.retryAt) {
+				continue
+			}
+		} else {
+			// A changed file starts over.
+			delete(w.failed, path)
+		}
+
+		delete(w.pending, path)
+
+		if info.Size() == 0 {
+			log.Printf("Skipping %s: Slack does not accept empty files", path)
+			continue
+		}
+
+		ready = append(ready, path)
+	}
+
+	sort.Strings(ready)
+
+	for _, path := range ready {
+		w.inFlight[path] = true
+	}
+
+	w.ready = append(w.ready, ready...)
+}
+
+// finish records the result of processing a file. A file that failed goes
+// back to pending until it changes or its backoff has passed.
+func (w *watcher) finish(p processed, now time.Time) {
+	delete(w.inFlight, p.path)
+
+	if p.ok {
+		delete(w.failed, p.path)
+		return
+	}
+
+	f := w.failed[p.path]
+
+	if f == nil {
+		f = &failedFile{}
+		w.failed[p.path] = f
+	}
+
+	delay := w.backoff
+
+	for i := 0; i < f.attempts && delay < w.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > w.maxBackoff {
+		delay = w.maxBackoff
+	}
+
+	f.state = p.state
+	f.attempts++
+	f.retryAt = now.Add(delay)
+
+	log.Printf("Retrying %s in %s unless it changes first", p.path, delay)
+
+	w.pending[p.path] = &pendingFile{size: -1, since: now}
+}
+
+// channelFor returns the channel ID for a file, or "" if no route matches
+// and there is no default channel.
+func (w *watcher) channelFor(path string) string {
+	rel, err := filepath.Rel(w.root, filepath.Dir(path))
+
+	if err != nil {
+		return w.channel
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	for _, r := range w.routes {
+		if r.Dir == "." || rel == r.Dir || strings.HasPrefix(rel, r.Dir+"/") {
+			return r.Channel
+		}
+	}
+
+	return w.channel
+}
+
+// process uploads one file and archives it.
+func (w *watcher) process(ctx context.Context, path string) processed {
+	p := processed{path: path}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		log.Printf("Upload Error: %s: %v", path, err)
+		return p
+	}
+
+	p.state = fileState{size: info.Size(), mod: info.ModTime()}
+
+	channel := w.channelFor(path)
+
+	if channel == "" {
+		log.Printf("Skipping %s: no -route matches it and there is no -channel", path)
+		return p
+	}
+
+	f := uploadFile{Path: path, Size: info.Size()}
+	r := w.uploader.upload(ctx, f, w.params(f, channel))
+
+	if r.Err != nil {
+		log.Printf("Upload Error: %s: %v", path, r.Err)
+		return p
+	}
+
+	switch {
+	case r.Unchanged:
+		log.Printf("Unchanged %s (already uploaded as %s)", path, r.FileID)
+	case r.DeleteErr != nil:
+		log.Printf("Uploaded %s to %s as %s; deleting the earlier upload %s failed: %v", path, channel, r.FileID, r.Superseded, r.DeleteErr)
+	default:
+		log.Printf("Uploaded %s to %s as %s", path, channel, r.FileID)
+	}
+
+	dest, err := w.archiveFile(path, time.Now())
+
+	if err != nil {
+		log.Printf("Archive Error: %s: %v", path, err)
+		return p
+	}
+
+	log.Printf("Archived %s to %s", path, dest)
+
+	p.ok = true
+
+	return p
+}
+
+// archiveFile moves path to the same place under the archive directory. A
+// file that is already there is not overwritten; the new one gets a
+// timestamp added to its name instead.
+func (w *watcher) archiveFile(path string, now time.Time) (string, error) {
+	rel, err := filepath.Rel(w.root, path)
+
+	if err != nil {
+		return "", err
+	}
+
+	dest := filepath.Join(w.archive, rel)
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return "", err
+	}
+
+	if _, err := os.Lstat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = strings.TrimSuffix(dest, ext) + "-" + now.Format("20060102T150405.000") + ext
+	}
+
+	return dest, os.Rename(path, dest)
+}
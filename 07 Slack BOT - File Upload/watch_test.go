@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/slack-go/slack"
+)
+
+func TestRouteFlag(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    route
+		wantErr bool
+	}{
+		{"invoices=finance", route{"invoices", "finance"}, false},
+		{"reports/weekly/=#reports", route{"reports/weekly", "#reports"}, false},
+		{"./scans=C0123456789", route{"scans", "C0123456789"}, false},
+		{"../outside=general", route{}, true},
+		{"/srv/drop=general", route{}, true},
+		{"invoices", route{}, true},
+		{"invoices=", route{}, true},
+	}
+
+	for _, tt := range tests {
+		var f routeFlag
+
+		err := f.Set(tt.value)
+
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Set(%q) = %v, want an error", tt.value, f)
+			}
+
+			continue
+		}
+
+		if err != nil || len(f) != 1 || f[0] != tt.want {
+			t.Errorf("Set(%q) = %v, %v, want %v", tt.value, f, err, tt.want)
+		}
+	}
+}
+
+func TestChannelFor(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "drop")
+	routes := []route{{"invoices", "CINV"}, {"invoices/eu", "CEU"}, {"scans", "CSCAN"}}
+
+	tests := []struct {
+		channel string
+		path    string
+		want    string
+	}{
+		{"CDEF", "a.pdf", "CDEF"},
+		{"CDEF", "invoices/a.pdf", "CINV"},
+		{"CDEF", "invoices/2024/a.pdf", "CINV"},
+		{"CDEF", "invoices/eu/a.pdf", "CEU"},
+		{"CDEF", "invoices-old/a.pdf", "CDEF"},
+		{"", "other/a.pdf", ""},
+	}
+
+	for _, tt := range tests {
+		w := newWatcher(root, filepath.Join(root, ".archive"), time.Second, routes, tt.channel, nil, nil)
+
+		if got := w.channelFor(filepath.Join(root, tt.path)); got != tt.want {
+			t.Errorf("channelFor(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveFile(t *testing.T) {
+	root := t.TempDir()
+	w := newWatcher(root, filepath.Join(root, ".archive"), time.Second, nil, "C1", nil, nil)
+	now := time.Date(2024, 11, 22, 9, 0, 4, 123e6, time.UTC)
+
+	path := filepath.Join(root, "invoices", "a.pdf")
+
+	writeFile(t, path, "first")
+
+	dest, err := w.archiveFile(path, now)
+
+	if err != nil || dest != filepath.Join(root, ".archive", "invoices", "a.pdf") {
+		t.Fatalf("first archive = %s, %v", dest, err)
+	}
+
+	writeFile(t, path, "second")
+
+	dest, err = w.archiveFile(path, now)
+
+	if err != nil || dest != filepath.Join(root, ".archive", "invoices", "a-20241122T090004.123.pdf") {
+		t.Fatalf("second archive = %s, %v", dest, err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s is still there: %v", path, err)
+	}
+
+	if data, _ := os.ReadFile(dest); string(data) != "second" {
+		t.Errorf("%s holds %q", dest, data)
+	}
+}
+
+// startWatcher runs a watcher on a new directory against the fake Slack
+// API until the test ends. Failed files are retried after backoff.
+func startWatcher(t *testing.T, fake *slacktest.Server, routes []route, backoff time.Duration) (root string) {
+	t.Helper()
+
+	root = t.TempDir()
+
+	u := newTestUploader(fake)
+	u.retries = 0
+
+	w := newWatcher(root, filepath.Join(root, ".archive"), 100*time.Millisecond, routes, "CDEF", u, func(f uploadFile, channelID string) slack.UploadFileV2Parameters {
+		return slack.UploadFileV2Parameters{
+			Channel:  channelID,
+			File:     f.Path,
+			Filename: filepath.Base(f.Path),
+			FileSize: int(f.Size),
+		}
+	})
+	w.backoff, w.maxBackoff = backoff, backoff
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() { done <- w.run(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Errorf("run: %v", err)
+		}
+	})
+
+	// Give the watcher time to add its watches.
+	time.Sleep(50 * time.Millisecond)
+
+	return root
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestWatcherUploadsAndArchives(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	root := startWatcher(t, fake, []route{{"invoices", "CINV"}}, time.Minute)
+
+	writeFile(t, filepath.Join(root, "notes.txt"), "notes")
+	writeFile(t, filepath.Join(root, "invoices", "2024", "a.pdf"), "invoice")
+	writeFile(t, filepath.Join(root, ".partial", "b.pdf"), "hidden")
+	writeFile(t, filepath.Join(root, "empty.txt"), "")
+
+	waitFor(t, "both files to be archived", func() bool {
+		return exists(filepath.Join(root, ".archive", "notes.txt")) && exists(filepath.Join(root, ".archive", "invoices", "2024", "a.pdf"))
+	})
+
+	channels := map[string]string{}
+
+	for _, c := range fake.CallsTo("files.completeUploadExternal") {
+		channels[c.Form.Get("channel_id")] += c.Form.Get("files")
+	}
+
+	if len(channels) != 2 || !strings.Contains(channels["CDEF"], "F") || !strings.Contains(channels["CINV"], "F") {
+		t.Errorf("uploads by channel = %v, want one to CDEF and one to CINV", channels)
+	}
+
+	if got := len(fake.CallsTo("upload")); got != 2 {
+		t.Errorf("got %d uploads, want 2", got)
+	}
+
+	if !exists(filepath.Join(root, ".partial", "b.pdf")) || !exists(filepath.Join(root, "empty.txt")) {
+		t.Error("hidden or empty files were moved")
+	}
+}
+
+func TestWatcherWaitsForStableSize(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	root := startWatcher(t, fake, nil, time.Minute)
+	path := filepath.Join(root, "big.bin")
+
+	f, err := os.Create(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 6; i++ {
+		f.WriteString("chunk ")
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	f.Close()
+
+	waitFor(t, "the file to be archived", func() bool {
+		return exists(filepath.Join(root, ".archive", "big.bin"))
+	})
+
+	uploads := fake.CallsTo("upload")
+
+	if len(uploads) != 1 || uploads[0].Content != strings.Repeat("chunk ", 6) {
+		t.Fatalf("uploads = %+v, want the whole file once", uploads)
+	}
+}
+
+func TestWatcherRetriesFailedFileAfterBackoff(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.Fail("files.completeUploadExternal", slacktest.SlackError("not_in_channel"))
+
+	root := startWatcher(t, fake, nil, time.Second)
+	path := filepath.Join(root, "a.txt")
+
+	writeFile(t, path, "first")
+
+	waitFor(t, "the failed upload", func() bool {
+		return len(fake.CallsTo("files.completeUploadExternal")) == 1
+	})
+
+	// Several stability periods pass within the backoff without another
+	// attempt.
+	time.Sleep(400 * time.Millisecond)
+
+	if got := len(fake.CallsTo("files.completeUploadExternal")); got != 1 || !exists(path) {
+		t.Fatalf("after a failure: %d attempts, file there: %v", got, exists(path))
+	}
+
+	waitFor(t, "the unchanged file to be archived", func() bool {
+		return exists(filepath.Join(root, ".archive", "a.txt"))
+	})
+
+	if got := len(fake.CallsTo("files.completeUploadExternal")); got != 2 {
+		t.Errorf("got %d attempts, want 2", got)
+	}
+}
+
+func TestWatcherRetriesChangedFileBeforeBackoff(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.Fail("files.completeUploadExternal", slacktest.SlackError("not_in_channel"))
+
+	root := startWatcher(t, fake, nil, time.Hour)
+	path := filepath.Join(root, "a.txt")
+
+	writeFile(t, path, "first")
+
+	waitFor(t, "the failed upload", func() bool {
+		return len(fake.CallsTo("files.completeUploadExternal")) == 1
+	})
+
+	writeFile(t, path, "second")
+
+	waitFor(t, "the changed file to be archived", func() bool {
+		return exists(filepath.Join(root, ".archive", "a.txt"))
+	})
+
+	if uploads := fake.CallsTo("upload"); uploads[len(uploads)-1].Content != "second" {
+		t.Errorf("last upload sent %q", uploads[len(uploads)-1].Content)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/shomali11/slacker"
+)
+
+const (
+	yobUsage      = "my yob is <year>"
+	birthdayUsage = "my birthday is <date>"
+)
+
+// maxAge is the oldest age the bot accepts. Anything older is taken to be a
+// typo in the year.
+const maxAge = 150
+
+// dateLayouts are the birth date formats the bot understands. Numeric dates
+// with slashes, such as 05/06/2000, are left out on purpose: they are read
+// day first in some countries and month first in others.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"02.01.2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	"January 2 2006",
+	"Jan 2 2006",
+}
+
+var (
+	yearPattern    = regexp.MustCompile(`^\d{4}$`)
+	ordinalPattern = regexp.MustCompile(`\b(\d{1,2})(st|nd|rd|th)\b`)
+)
+
+// birthDate is a date of birth as the user gave it. When only a year was
+// given, YearOnly is set and the month and day are January 1.
+type birthDate struct {
+	Date     time.Time
+	YearOnly bool
+}
+
+// parseBirthDate reads a year such as "2000" or a full date in one of the
+// dateLayouts. "May 17th, 2000" is read as "May 17 2000". The errors are
+// shown to the user as they are.
+func parseBirthDate(s string) (birthDate, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return birthDate{}, errors.New("tell me your date of birth, for example `my birthday is 2000-05-17`")
+	}
+
+	if yearPattern.MatchString(s) {
+		year, _ := strconv.Atoi(s)
+
+		return birthDate{Date: time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC), YearOnly: true}, nil
+	}
+
+	normalized := strings.ReplaceAll(s, ",", " ")
+	normalized = ordinalPattern.ReplaceAllString(normalized, "$1")
+	normalized = strings.Join(strings.Fields(normalized), " ")
+
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, normalized)
+
+		if err == nil {
+			return birthDate{Date: date}, nil
+		}
+
+		// The format matched, but the day or month does not exist, as in
+		// 2001-02-29.
+		var parseErr *time.ParseError
+
+		if errors.As(err, &parseErr) && parseErr.Message != "" {
+			return birthDate{}, fmt.Errorf("%s is not a real date", s)
+		}
+	}
+
+	return birthDate{}, fmt.Errorf("could not read %q as a date; try 2000-05-17, 17 May 2000 or May 17, 2000", s)
+}
+
+// localDate returns the calendar date at now in loc, as midnight UTC.
+func localDate(now time.Time, loc *time.Location) time.Time {
+	y, m, d := now.In(loc).Date()
+
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// addMonths adds n months to date. When the day does not exist in the
+// resulting month, the last day of that month is used, so January 31 plus
+// one month is February 28 or 29.
+func addMonths(date time.Time, n int) time.Time {
+	y, m, d := date.Date()
+	first := time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+
+	if last := first.AddDate(0, 1, -1).Day(); d > last {
+		d = last
+	}
+
+	return first.AddDate(0, 0, d-1)
+}
+
+// ageOn returns the exact age on the date today of someone born on birth,
+// in whole years, months and days. Someone born on February 29 has their
+// birthday on February 28 in common years.
+func ageOn(birth, today time.Time) (years, months, days int) {
+	total := (today.Year()-birth.Year())*12 + int(today.Month()-birth.Month())
+
+	if addMonths(birth, total).After(today) {
+		total--
+	}
+
+	anniversary := addMonths(birth, total)
+
+	return total / 12, total % 12, int(today.Sub(anniversary).Hours() / 24)
+}
+
+// checkBirthDate rejects dates in the future and ages over maxAge.
+func checkBirthDate(b birthDate, today time.Time) error {
+	if b.YearOnly {
+		if b.Date.Year() > today.Year() {
+			return fmt.Errorf("%d is in the future", b.Date.Year())
+		}
+
+		if today.Year()-b.Date.Year() > maxAge {
+			return fmt.Errorf("that would make you over %d; check the year", maxAge)
+		}
+
+		return nil
+	}
+
+	if b.Date.After(today) {
+		return fmt.Errorf("%s is in the future", b.Date.Format("2 January 2006"))
+	}
+
+	if years, _, _ := ageOn(b.Date, today); years > maxAge {
+		return fmt.Errorf("that would make you over %d; check the year", maxAge)
+	}
+
+	return nil
+}
+
+// ageReply is the bot's answer for someone born on b, on the date today.
+func ageReply(b birthDate, today time.Time) string {
+	if b.YearOnly {
+		years := today.Year() - b.Date.Year()
+
+		if years == 0 {
+			return "You were born this year, so you are not 1 yet."
+		}
+
+		return fmt.Sprintf("You are %d, or %d if you have had your birthday this year. For your exact age, tell me the whole date, as in `my birthday is 2000-05-17`.", years-1, years)
+	}
+
+	years, months, days := ageOn(b.Date, today)
+
+	if years == 0 && months == 0 && days == 0 {
+		return "You were born today. Welcome!"
+	}
+
+	var parts []string
+
+	for _, p := range []struct {
+		n    int
+		unit string
+	}{{years, "year"}, {months, "month"}, {days, "day"}} {
+		switch {
+		case p.n == 1:
+			parts = append(parts, "1 "+p.unit)
+		case p.n > 1:
+			parts = append(parts, fmt.Sprintf("%d %ss", p.n, p.unit))
+		}
+	}
+
+	reply := "You are " + parts[0]
+
+	if len(parts) > 1 {
+		reply = "You are " + strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+	}
+
+	reply += " old."
+
+	if months == 0 && days == 0 {
+		reply += " Happy birthday! :birthday:"
+	}
+
+	return reply
+}
+
+// answerAge is the reply to a user who gave text as their date of birth.
+// The age is worked out on today's date in the user's Slack time zone. A
+// date that cannot be read or cannot be right is an error, to be shown to
+// the user as a sentence.
+func answerAge(ctx context.Context, api slackAPI, userID, text string, now time.Time) (string, error) {
+	birth, err := parseBirthDate(text)
+	if err != nil {
+		return "", err
+	}
+
+	day := localDate(now, userLocation(ctx, api, userID))
+
+	err = checkBirthDate(birth, day)
+	if err != nil {
+		return "", err
+	}
+
+	return ageReply(birth, day), nil
+}
+
+// answerAgeCommand replies to a command that gave text as the user's date
+// of birth. A date that is wrong is an invalid use of the command.
+func answerAgeCommand(botCtx slacker.BotContext, response slacker.ResponseWriter, userID, text string, now time.Time) {
+	age, err := answerAge(botCtx.Context(), botCtx.APIClient(), userID, text, now)
+	if err != nil {
+		reply(botCtx, response, analytics.OutcomeInvalid, sentence(err))
+		return
+	}
+
+	reply(botCtx, response, analytics.OutcomeOK, age)
+}
+
+// ageCommand answers with the exact age of the user, born on the date in
+// the param parameter, in the user's Slack time zone.
+func ageCommand(param, description string, examples []string, now func() time.Time) *slacker.CommandDefinition {
+	return &slacker.CommandDefinition{
+		Description: description,
+		Examples:    examples,
+		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+			answerAgeCommand(botCtx, response, botCtx.Event().UserID, request.Param(param), now())
+		},
+	}
+}
+
+// sentence turns an error into a reply: "2030 is in the future" becomes
+// "2030 is in the future.", and "could not read" becomes "Could not read".
+func sentence(err error) string {
+	msg := err.Error()
+
+	return strings.ToUpper(msg[:1]) + msg[1:] + "."
+}
+
+// yobCommand answers "my yob is <year>". It takes a full date too.
+func yobCommand(now func() time.Time) *slacker.CommandDefinition {
+	return ageCommand("year", "age from your year or date of birth", []string{"my yob is 2000", "my yob is 2000-05-17"}, now)
+}
+
+// birthdayCommand answers "my birthday is <date>" with the exact age.
+func birthdayCommand(now func() time.Time) *slacker.CommandDefinition {
+	return ageCommand("date", "exact age from your date of birth", []string{"my birthday is 2000-05-17", "my birthday is 17 May 2000"}, now)
+}
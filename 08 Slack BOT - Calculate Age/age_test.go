@@ -0,0 +1,167 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseBirthDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    birthDate
+		wantErr string
+	}{
+		{"2000", birthDate{Date: date(2000, 1, 1), YearOnly: true}, ""},
+		{"2000-05-17", birthDate{Date: date(2000, 5, 17)}, ""},
+		{"2000/05/17", birthDate{Date: date(2000, 5, 17)}, ""},
+		{"17.05.2000", birthDate{Date: date(2000, 5, 17)}, ""},
+		{"17 May 2000", birthDate{Date: date(2000, 5, 17)}, ""},
+		{"17 september 2000", birthDate{Date: date(2000, 9, 17)}, ""},
+		{"May 17, 2000", birthDate{Date: date(2000, 5, 17)}, ""},
+		{"  December 1st,  1999 ", birthDate{Date: date(1999, 12, 1)}, ""},
+		{"Feb 29 2000", birthDate{Date: date(2000, 2, 29)}, ""},
+		{"2001-02-29", birthDate{}, "2001-02-29 is not a real date"},
+		{"2000-13-01", birthDate{}, "not a real date"},
+		{"05/06/2000", birthDate{}, "could not read"},
+		{"soon", birthDate{}, "could not read"},
+		{"", birthDate{}, "tell me your date of birth"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseBirthDate(tt.in)
+
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseBirthDate(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("parseBirthDate(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestAgeOn(t *testing.T) {
+	tests := []struct {
+		birth, today        time.Time
+		years, months, days int
+	}{
+		{date(2000, 5, 17), date(2025, 5, 17), 25, 0, 0},
+		{date(2000, 5, 17), date(2025, 5, 16), 24, 11, 29},
+		{date(2000, 5, 17), date(2025, 3, 1), 24, 9, 12},
+		{date(2000, 1, 31), date(2001, 2, 28), 1, 1, 0},
+		{date(2000, 1, 31), date(2001, 3, 1), 1, 1, 1},
+		{date(2000, 2, 29), date(2001, 2, 28), 1, 0, 0},
+		{date(2000, 2, 29), date(2001, 2, 27), 0, 11, 29},
+		{date(2000, 2, 29), date(2004, 2, 29), 4, 0, 0},
+		{date(2025, 3, 1), date(2025, 3, 1), 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		y, m, d := ageOn(tt.birth, tt.today)
+
+		if y != tt.years || m != tt.months || d != tt.days {
+			t.Errorf("ageOn(%s, %s) = %d, %d, %d, want %d, %d, %d", tt.birth.Format("2006-01-02"), tt.today.Format("2006-01-02"), y, m, d, tt.years, tt.months, tt.days)
+		}
+	}
+}
+
+func TestCheckBirthDate(t *testing.T) {
+	today := date(2025, 3, 1)
+
+	tests := []struct {
+		birth   birthDate
+		wantErr string
+	}{
+		{birthDate{Date: date(2000, 5, 17)}, ""},
+		{birthDate{Date: date(2025, 3, 1)}, ""},
+		{birthDate{Date: date(2025, 3, 2)}, "2 March 2025 is in the future"},
+		{birthDate{Date: date(1874, 3, 2)}, ""},
+		{birthDate{Date: date(1874, 3, 1)}, "over 150"},
+		{birthDate{Date: date(2025, 1, 1), YearOnly: true}, ""},
+		{birthDate{Date: date(2026, 1, 1), YearOnly: true}, "2026 is in the future"},
+		{birthDate{Date: date(1874, 1, 1), YearOnly: true}, "over 150"},
+	}
+
+	for _, tt := range tests {
+		err := checkBirthDate(tt.birth, today)
+
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("checkBirthDate(%+v) = %v, want %q", tt.birth, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAgeCommands(t *testing.T) {
+	// Noon in UTC is already the next day in Auckland and still the
+	// morning in New York.
+	now := func() time.Time { return time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		usage string
+		def   *slacker.CommandDefinition
+		user  string
+		text  string
+		want  string
+	}{
+		{yobUsage, yobCommand(now), "UNY", "my yob is 2000", "You are 24, or 25 if you have had your birthday this year. For your exact age, tell me the whole date, as in `my birthday is 2000-05-17`."},
+		{yobUsage, yobCommand(now), "UNY", "my yob is 2000-03-02", "You are 24 years, 11 months and 27 days old."},
+		{yobUsage, yobCommand(now), "UNY", "my yob is 2030", "2030 is in the future."},
+		{yobUsage, yobCommand(now), "UNY", "my yob is soon", `Could not read "soon" as a date; try 2000-05-17, 17 May 2000 or May 17, 2000.`},
+		{birthdayUsage, birthdayCommand(now), "UNY", "my birthday is March 2, 2000", "You are 24 years, 11 months and 27 days old."},
+		{birthdayUsage, birthdayCommand(now), "UAKL", "my birthday is March 2, 2000", "You are 25 years old. Happy birthday! :birthday:"},
+		{birthdayUsage, birthdayCommand(now), "UAKL", "my birthday is 2025-03-02", "You were born today. Welcome!"},
+		{birthdayUsage, birthdayCommand(now), "UNY", "my birthday is 2025-03-02", "2 March 2025 is in the future."},
+		// The zone name is unknown here, so the offset Slack gives is used.
+		{birthdayUsage, birthdayCommand(now), "UOFF", "my birthday is 2000-03-02", "You are 25 years old. Happy birthday! :birthday:"},
+		// users.info fails, so the date is taken in UTC.
+		{birthdayUsage, birthdayCommand(now), "UNKNOWN", "my birthday is 2000-02-01", "You are 25 years and 1 month old."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			fake := slacktest.NewServer(t)
+			fake.AddUser("UNY", slacktest.User{TZ: "America/New_York", TZOffset: -18000})
+			fake.AddUser("UAKL", slacktest.User{TZ: "Pacific/Auckland", TZOffset: 46800})
+			fake.AddUser("UOFF", slacktest.User{TZ: "Nowhere/Island", TZOffset: 14 * 3600})
+
+			if !runCommand(fake, tt.usage, tt.def, &slacker.MessageEvent{ChannelID: "C0001", UserID: tt.user, Text: tt.text}) {
+				t.Fatal("did not match")
+			}
+
+			if got := fake.Messages(); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("replies = %q, want %q", got, tt.want)
+			}
+
+			for _, c := range fake.CallsTo("chat.postMessage") {
+				if c.Form.Get("channel") != "C0001" {
+					t.Errorf("replied in %q, want C0001", c.Form.Get("channel"))
+				}
+			}
+		})
+	}
+}
+
+func TestAgeCommandNoMatch(t *testing.T) {
+	fake := slacktest.NewServer(t)
+
+	if runCommand(fake, yobUsage, yobCommand(time.Now), &slacker.MessageEvent{ChannelID: "C0001", Text: "what is my age"}) {
+		t.Fatal("matched")
+	}
+
+	if calls := len(fake.Calls()); calls != 0 {
+		t.Fatalf("made %d calls", calls)
+	}
+}
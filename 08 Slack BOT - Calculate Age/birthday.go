@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+)
+
+const (
+	rememberUsage = "remember my birthday <date>"
+	forgetUsage   = "forget my birthday"
+	upcomingUsage = "upcoming birthdays"
+)
+
+const (
+	// birthdayHour is the local hour birthday messages are posted at.
+	birthdayHour = 9
+	// upcomingDays is how far ahead "upcoming birthdays" looks.
+	upcomingDays = 30
+)
+
+// birthdayIn returns the birthday in year of someone born on birth.
+// Someone born on February 29 has their birthday on February 28 in common
+// years.
+func birthdayIn(birth time.Time, year int) time.Time {
+	return addMonths(birth, (year-birth.Year())*12)
+}
+
+// nextBirthday returns the first birthday on or after today.
+func nextBirthday(birth, today time.Time) time.Time {
+	next := birthdayIn(birth, today.Year())
+
+	if next.Before(today) {
+		next = birthdayIn(birth, today.Year()+1)
+	}
+
+	return next
+}
+
+// birthdays holds what the birthday commands and the scheduler share. The
+// bot token belongs to one workspace, team, and the messages are posted in
+// channel. Without a channel, birthdays are remembered but not announced.
+type birthdays struct {
+	store   *birthdayStore
+	team    string
+	channel string
+	now     func() time.Time
+}
+
+// rememberCommand answers "remember my birthday <date>".
+func (b *birthdays) rememberCommand() *slacker.CommandDefinition {
+	return &slacker.CommandDefinition{
+		Description: "remember your birthday and wish you a happy one",
+		Examples:    []string{"remember my birthday 2000-05-17", "remember my birthday May 17, 2000"},
+		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+			birth, err := parseBirthDate(request.Param("date"))
+			if err == nil && birth.YearOnly {
+				err = errors.New("the day and month are needed too, as in `remember my birthday 2000-05-17`")
+			}
+
+			if err != nil {
+				reply(botCtx, response, analytics.OutcomeInvalid, sentence(err))
+				return
+			}
+
+			user := botCtx.Event().UserID
+			loc := userLocation(botCtx.Context(), botCtx.APIClient(), user)
+
+			err = checkBirthDate(birth, localDate(b.now(), loc))
+			if err != nil {
+				reply(botCtx, response, analytics.OutcomeInvalid, sentence(err))
+				return
+			}
+
+			err = b.store.set(b.team, user, birth.Date)
+			if err != nil {
+				log.Printf("Error saving the birthday of %s: %v", user, err)
+				reply(botCtx, response, analytics.OutcomeError, "Sorry, I could not save your birthday. Please try again later.")
+				return
+			}
+
+			text := fmt.Sprintf("Got it, your birthday is %s.", birth.Date.Format("2 January"))
+
+			if b.channel != "" {
+				text += fmt.Sprintf(" I will wish you a happy birthday in <#%s> at %d:00 your time.", b.channel, birthdayHour)
+			}
+
+			reply(botCtx, response, analytics.OutcomeOK, text)
+		},
+	}
+}
+
+// forgetCommand answers "forget my birthday".
+func (b *birthdays) forgetCommand() *slacker.CommandDefinition {
+	return &slacker.CommandDefinition{
+		Description: "forget your birthday",
+		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+			user := botCtx.Event().UserID
+
+			known, err := b.store.remove(b.team, user)
+			if err != nil {
+				log.Printf("Error removing the birthday of %s: %v", user, err)
+				reply(botCtx, response, analytics.OutcomeError, "Sorry, I could not forget your birthday. Please try again later.")
+				return
+			}
+
+			if !known {
+				reply(botCtx, response, analytics.OutcomeOK, "I did not know your birthday.")
+				return
+			}
+
+			reply(botCtx, response, analytics.OutcomeOK, "Done, I have forgotten your birthday.")
+		},
+	}
+}
+
+// upcomingCommand answers "upcoming birthdays" with the birthdays in the
+// next upcomingDays days, counted from today in the asking user's time
+// zone. Only days are shown, not years or ages.
+func (b *birthdays) upcomingCommand() *slacker.CommandDefinition {
+	return &slacker.CommandDefinition{
+		Description: fmt.Sprintf("birthdays in the next %d days", upcomingDays),
+		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+			loc := userLocation(botCtx.Context(), botCtx.APIClient(), botCtx.Event().UserID)
+			reply(botCtx, response, analytics.OutcomeOK, upcomingReply(b.store.list(b.team), localDate(b.now(), loc)))
+		},
+	}
+}
+
+func upcomingReply(list []birthday, today time.Time) string {
+	type upcoming struct {
+		user string
+		date time.Time
+	}
+
+	var next []upcoming
+
+	for _, b := range list {
+		date := nextBirthday(b.date(), today)
+
+		if date.Sub(today) <= upcomingDays*24*time.Hour {
+			next = append(next, upcoming{b.User, date})
+		}
+	}
+
+	if len(next) == 0 {
+		return fmt.Sprintf("No birthdays in the next %d days.", upcomingDays)
+	}
+
+	sort.SliceStable(next, func(i, j int) bool {
+		return next[i].date.Before(next[j].date)
+	})
+
+	lines := []string{fmt.Sprintf("*Birthdays in the next %d days*", upcomingDays)}
+
+	for _, u := range next {
+		switch days := int(u.date.Sub(today).Hours() / 24); days {
+		case 0:
+			lines = append(lines, fmt.Sprintf("• Today: <@%s> :birthday:", u.user))
+		case 1:
+			lines = append(lines, fmt.Sprintf("• Tomorrow: <@%s>", u.user))
+		default:
+			lines = append(lines, fmt.Sprintf("• %s: <@%s> (in %d days)", u.date.Format("Mon 2 January"), u.user, days))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// run posts the birthday messages that are due every interval until ctx
+// is cancelled.
+func (b *birthdays) run(ctx context.Context, api slackAPI, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		b.announce(ctx, api)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// announce posts a message in the birthday channel for each user whose
+// birthday it is and for whom it is past birthdayHour, unless one was
+// posted this year already. A message that fails is tried again on the
+// next run.
+func (b *birthdays) announce(ctx context.Context, api slackAPI) {
+	now := b.now()
+	utcToday := localDate(now, time.UTC)
+
+	for _, entry := range b.store.list(b.team) {
+		birth := entry.date()
+
+		// Time zones are at most a day away from UTC, so only users with a
+		// birthday around today, who have not been greeted for it yet, need
+		// their time zone looked up.
+		next := nextBirthday(birth, utcToday.AddDate(0, 0, -1))
+
+		if next.After(utcToday.AddDate(0, 0, 1)) || entry.LastGreeted >= next.Year() {
+			continue
+		}
+
+		loc := userLocation(ctx, api, entry.User)
+		today := localDate(now, loc)
+
+		if !birthdayIn(birth, today.Year()).Equal(today) || now.In(loc).Hour() < birthdayHour || entry.LastGreeted >= today.Year() {
+			continue
+		}
+
+		_, _, err := api.PostMessageContext(ctx, b.channel, slack.MsgOptionText(fmt.Sprintf("Happy birthday, <@%s>! :birthday:", entry.User), false))
+		if err != nil {
+			log.Printf("Error posting the birthday message for %s: %v", entry.User, err)
+			continue
+		}
+
+		err = b.store.markGreeted(entry.Team, entry.User, today.Year())
+		if err != nil {
+			log.Printf("Error saving the birthday store: %v", err)
+		}
+	}
+}
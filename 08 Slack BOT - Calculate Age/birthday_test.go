@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+)
+
+func newTestBirthdays(t *testing.T, now time.Time) *birthdays {
+	t.Helper()
+
+	store, err := loadBirthdayStore(filepath.Join(t.TempDir(), "birthdays.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &birthdays{store: store, team: "T0001", channel: "CBDAY", now: func() time.Time { return now }}
+}
+
+func TestNextBirthday(t *testing.T) {
+	tests := []struct {
+		birth, today, want time.Time
+	}{
+		{date(2000, 5, 17), date(2025, 3, 1), date(2025, 5, 17)},
+		{date(2000, 5, 17), date(2025, 5, 17), date(2025, 5, 17)},
+		{date(2000, 5, 17), date(2025, 5, 18), date(2026, 5, 17)},
+		{date(2000, 2, 29), date(2025, 2, 1), date(2025, 2, 28)},
+		{date(2000, 2, 29), date(2027, 12, 1), date(2028, 2, 29)},
+	}
+
+	for _, tt := range tests {
+		if got := nextBirthday(tt.birth, tt.today); !got.Equal(tt.want) {
+			t.Errorf("nextBirthday(%s, %s) = %s, want %s", tt.birth.Format(dateFormat), tt.today.Format(dateFormat), got.Format(dateFormat), tt.want.Format(dateFormat))
+		}
+	}
+}
+
+func TestRememberAndForget(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.AddUser("U0002", slacktest.User{TZ: "Europe/Lisbon"})
+
+	b := newTestBirthdays(t, time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	run := func(usage string, def *slacker.CommandDefinition, text string) {
+		t.Helper()
+
+		if !runCommand(fake, usage, def, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: text}) {
+			t.Fatalf("%q did not match", text)
+		}
+	}
+
+	run(rememberUsage, b.rememberCommand(), "remember my birthday 2000")
+	run(rememberUsage, b.rememberCommand(), "remember my birthday 2026-01-01")
+	run(rememberUsage, b.rememberCommand(), "remember my birthday May 17th, 2000")
+	run(forgetUsage, b.forgetCommand(), "forget my birthday")
+	run(forgetUsage, b.forgetCommand(), "please forget my birthday")
+
+	want := []string{
+		"The day and month are needed too, as in `remember my birthday 2000-05-17`.",
+		"1 January 2026 is in the future.",
+		"Got it, your birthday is 17 May. I will wish you a happy birthday in <#CBDAY> at 9:00 your time.",
+		"Done, I have forgotten your birthday.",
+		"I did not know your birthday.",
+	}
+
+	if got := fake.Messages(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replies:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	run(rememberUsage, b.rememberCommand(), "remember my birthday 2000-05-17")
+
+	if got := b.store.list("T0001"); len(got) != 1 || got[0].User != "U0002" || got[0].Date != "2000-05-17" {
+		t.Fatalf("store = %+v", got)
+	}
+
+	b.channel = ""
+	run(rememberUsage, b.rememberCommand(), "remember my birthday 2000-05-18")
+
+	if replies := fake.Messages(); replies[len(replies)-1] != "Got it, your birthday is 18 May." {
+		t.Fatalf("reply without a channel = %q", replies[len(replies)-1])
+	}
+}
+
+func TestUpcomingReply(t *testing.T) {
+	list := []birthday{
+		{User: "UFAR", Date: "1990-04-01"},
+		{User: "ULEAP", Date: "2000-02-29"},
+		{User: "UTODAY", Date: "1980-02-20"},
+		{User: "UTOMORROW", Date: "1995-02-21"},
+		{User: "ULAST", Date: "1970-03-22"},
+	}
+
+	got := upcomingReply(list, date(2025, 2, 20))
+	want := `*Birthdays in the next 30 days*
+• Today: <@UTODAY> :birthday:
+• Tomorrow: <@UTOMORROW>
+• Fri 28 February: <@ULEAP> (in 8 days)
+• Sat 22 March: <@ULAST> (in 30 days)`
+
+	if got != want {
+		t.Errorf("reply:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The list wraps around the end of the year.
+	if got := upcomingReply([]birthday{{User: "U1", Date: "2001-01-05"}}, date(2025, 12, 20)); !strings.Contains(got, "Mon 5 January: <@U1> (in 16 days)") {
+		t.Errorf("reply across the new year:\n%s", got)
+	}
+
+	if got := upcomingReply(list[:1], date(2025, 2, 20)); got != "No birthdays in the next 30 days." {
+		t.Errorf("empty reply = %q", got)
+	}
+}
+
+func TestUpcomingCommand(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.AddUser("U0002", slacktest.User{TZ: "Pacific/Auckland"})
+
+	// Already March 2 in Auckland.
+	b := newTestBirthdays(t, time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC))
+	b.store.set("T0001", "U0003", date(1999, 3, 2))
+	b.store.set("T0002", "U0004", date(1999, 3, 3))
+
+	runCommand(fake, upcomingUsage, b.upcomingCommand(), &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "upcoming birthdays"})
+
+	want := []string{"*Birthdays in the next 30 days*\n• Today: <@U0003> :birthday:"}
+
+	if got := fake.Messages(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replies = %q, want %q", got, want)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.AddUser("UTOKYO", slacktest.User{TZ: "Asia/Tokyo"})
+	fake.AddUser("ULA", slacktest.User{TZ: "America/Los_Angeles"})
+	fake.AddUser("ULATER", slacktest.User{TZ: "UTC"})
+
+	now := time.Date(2025, 5, 16, 23, 30, 0, 0, time.UTC)
+	b := newTestBirthdays(t, now)
+	b.now = func() time.Time { return now }
+	b.store.set("T0001", "UTOKYO", date(2000, 5, 17))
+	b.store.set("T0001", "ULA", date(1990, 5, 17))
+	b.store.set("T0001", "ULATER", date(1990, 6, 1))
+	b.store.set("T0002", "UOTHER", date(1990, 5, 17))
+
+	posted := func() []string {
+		var texts []string
+
+		for _, c := range fake.CallsTo("chat.postMessage") {
+			if c.Form.Get("channel") != "CBDAY" {
+				t.Errorf("posted in %q, want CBDAY", c.Form.Get("channel"))
+			}
+
+			texts = append(texts, c.Form.Get("text"))
+		}
+
+		return texts
+	}
+
+	// 8:30 on May 17 in Tokyo: too early.
+	b.announce(context.Background(), fake.Client())
+
+	if got := posted(); len(got) != 0 {
+		t.Fatalf("posted %q before 9am", got)
+	}
+
+	// 9:30 in Tokyo, while it is still May 16 in Los Angeles.
+	now = now.Add(time.Hour)
+	b.announce(context.Background(), fake.Client())
+	b.announce(context.Background(), fake.Client())
+
+	if got := posted(); !reflect.DeepEqual(got, []string{"Happy birthday, <@UTOKYO>! :birthday:"}) {
+		t.Fatalf("posted %q", got)
+	}
+
+	// 9:00 on May 17 in Los Angeles. The first message fails and is posted
+	// on the next run.
+	now = time.Date(2025, 5, 17, 16, 0, 0, 0, time.UTC)
+	fake.Fail("chat.postMessage", slacktest.RateLimited(1))
+	b.announce(context.Background(), fake.Client())
+	b.announce(context.Background(), fake.Client())
+
+	if got := posted(); len(got) != 3 || got[2] != "Happy birthday, <@ULA>! :birthday:" {
+		t.Fatalf("posted %q", got)
+	}
+
+	// Users already greeted are not looked up again on later runs.
+	lookups := len(fake.CallsTo("users.info"))
+	now = now.Add(time.Minute)
+	b.announce(context.Background(), fake.Client())
+
+	if extra := len(fake.CallsTo("users.info")) - lookups; extra != 0 {
+		t.Errorf("made %d users.info calls after everyone was greeted", extra)
+	}
+
+	for _, c := range fake.CallsTo("users.info") {
+		if user := c.Form.Get("user"); user == "ULATER" || user == "UOTHER" {
+			t.Errorf("looked up %s, whose birthday is not today", user)
+		}
+	}
+
+	// The greeting is remembered across restarts.
+	reloaded, err := loadBirthdayStore(b.store.path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range reloaded.list("T0001") {
+		if want := map[string]int{"UTOKYO": 2025, "ULA": 2025}[entry.User]; entry.LastGreeted != want {
+			t.Errorf("%s LastGreeted = %d, want %d", entry.User, entry.LastGreeted, want)
+		}
+	}
+}
@@ -2,13 +2,13 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"time"
 
-	"github.com/shomali11/slacker"
+	"github.com/fbdaf/slackbot/analytics"
 	"github.com/joho/godotenv"
+	"github.com/shomali11/slacker"
 )
 
 func main() {
@@ -19,46 +19,71 @@ func main() {
 
 	botToken := os.Getenv("SLACK_BOT_TOKEN")
 	appToken := os.Getenv("SLACK_APP_TOKEN")
-	
+
 	if botToken == "" || appToken == "" {
 		log.Fatal("Missing SLACK_BOT_TOKEN or SLACK_APP_TOKEN")
 	}
 
-	bot := slacker.NewClient(botToken, appToken)
+	storePath := os.Getenv("BIRTHDAY_STORE")
+	if storePath == "" {
+		storePath = "birthdays.json"
+	}
 
-	go printCommandEvents(bot.CommandEvents())
-
-	bot.Command("my yob is <year>", &slacker.CommandDefinition{
-		Description: "yob calculator",
-		Examples:    []string{"my yob is 2000"},
-		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
-			year := request.Param("year")
-			yob, err := strconv.Atoi(year)
-			if err != nil {
-				response.Reply("Invalid year")
-				return
-			}
-			age := 2024 - yob
-			r := fmt.Sprintf("age is %d", age)
-			response.Reply(r)
-		},
-	})
+	store, err := loadBirthdayStore(storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	recorder, events, err := analytics.Open(analytics.ConfigFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer recorder.Close()
+
+	bot := slacker.NewClient(botToken, appToken)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err = bot.Listen(ctx)
+	auth, err := checkAuth(ctx, bot.APIClient())
 	if err != nil {
 		log.Fatal(err)
 	}
-}
 
-func printCommandEvents(analyticsChannel <-chan *slacker.CommandEvent) {
-	for event := range analyticsChannel {
-		fmt.Println("Command Events")
-		fmt.Println(event.Timestamp)
-		fmt.Println(event.Command)
-		fmt.Println(event.Parameters)
-		fmt.Println(event.Event)
+	b := &birthdays{store: store, team: auth.TeamID, channel: os.Getenv("BIRTHDAY_CHANNEL"), now: time.Now}
+
+	// The birthday commands come first: slacker runs the first command
+	// that matches, and "my birthday is <date>" would match "remember my
+	// birthday is ...".
+	command := func(usage string, def *slacker.CommandDefinition) {
+		bot.Command(usage, recorded(recorder, usage, def, false))
+	}
+
+	command(rememberUsage, b.rememberCommand())
+	command(forgetUsage, b.forgetCommand())
+	command(upcomingUsage, b.upcomingCommand())
+	command(yobUsage, yobCommand(time.Now))
+	command(birthdayUsage, birthdayCommand(time.Now))
+
+	// "age <date>" matches any message with the word "age" in it, and
+	// "stats {days}" any with "stats", so the slash commands come last.
+	modal := &ageModal{now: time.Now}
+	bot.Command(slashUsage, recorded(recorder, slashUsage, modal.command(), true))
+	bot.Interactive(modal.handleInteraction)
+
+	if events != nil {
+		bot.Command(statsUsage, recorded(recorder, statsUsage, statsCommand(events, time.Now), true))
+	}
+
+	if b.channel != "" {
+		go b.run(ctx, bot.APIClient(), time.Minute)
+	} else {
+		log.Printf("BIRTHDAY_CHANNEL is not set, so birthdays are not announced")
+	}
+
+	err = bot.Listen(ctx)
+	if err != nil {
+		log.Fatal(err)
 	}
 }
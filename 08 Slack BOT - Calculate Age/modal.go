@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/fbdaf/slackbot/slash"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+)
+
+// slashUsage is how slacker sees "/age" and "/age 2000-05-17": the command
+// name without the slash, followed by its text.
+const slashUsage = "age <date>"
+
+const (
+	ageModalCallbackID = "age_modal"
+	birthDateBlockID   = "birth_date"
+	birthDateActionID  = "birth_date_picker"
+)
+
+// ageModal answers the /age slash command. Without a date it opens a modal
+// with a date picker, and the date submitted there, or the one given as in
+// "/age 2000-05-17", is answered with an ephemeral reply. Replies go to the
+// command's response URL, which works in channels the bot is not in.
+type ageModal struct {
+	now func() time.Time
+}
+
+// ageModalMetadata is what the modal carries from the slash command to its
+// submission.
+type ageModalMetadata struct {
+	ResponseURL string `json:"responseUrl"`
+}
+
+// command handles /age. Messages that merely contain the word "age" match
+// slashUsage too, and are ignored.
+func (m *ageModal) command() *slacker.CommandDefinition {
+	return &slacker.CommandDefinition{
+		Description: "/age opens a form to pick your date of birth",
+		Examples:    []string{"/age", "/age 2000-05-17"},
+		HideHelp:    true,
+		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+			cmd, ok := slash.Command(botCtx.Event())
+			if !ok {
+				return
+			}
+
+			if text := request.Param("date"); strings.TrimSpace(text) != "" {
+				answerAgeCommand(botCtx, response, cmd.UserID, text, m.now())
+				return
+			}
+
+			err := m.open(botCtx.Context(), botCtx.APIClient(), cmd)
+			if err != nil {
+				log.Printf("Error opening the age form for %s: %v", cmd.UserID, err)
+				reply(botCtx, response, analytics.OutcomeError, "Sorry, I could not open the form. Try `/age 2000-05-17` instead.")
+			}
+		},
+	}
+}
+
+// open shows the modal to the user who ran cmd.
+func (m *ageModal) open(ctx context.Context, api slackAPI, cmd slack.SlashCommand) error {
+	metadata, err := json.Marshal(ageModalMetadata{ResponseURL: cmd.ResponseURL})
+	if err != nil {
+		return err
+	}
+
+	_, err = api.OpenViewContext(ctx, cmd.TriggerID, ageModalView(string(metadata)))
+
+	return err
+}
+
+func ageModalView(metadata string) slack.ModalViewRequest {
+	text := func(s string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.PlainTextType, s, false, false)
+	}
+
+	picker := slack.NewDatePickerBlockElement(birthDateActionID)
+	picker.Placeholder = text("Pick a date")
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      ageModalCallbackID,
+		Title:           text("Age"),
+		Submit:          text("Calculate"),
+		Close:           text("Cancel"),
+		PrivateMetadata: metadata,
+		Blocks: slack.Blocks{BlockSet: []slack.Block{
+			slack.NewInputBlock(birthDateBlockID, text("Date of birth"), text("Your age is worked out in your Slack time zone."), picker),
+		}},
+	}
+}
+
+// handleInteraction is the bot's handler for interactive events. slacker
+// does not acknowledge them, so it is done here.
+func (m *ageModal) handleInteraction(botCtx slacker.InteractiveBotContext, callback *slack.InteractionCallback) {
+	m.interact(botCtx.Context(), botCtx.APIClient(), callback, func(payload ...interface{}) {
+		botCtx.SocketModeClient().Ack(*botCtx.Event().Request, payload...)
+	})
+}
+
+// interact handles an interactive event and acknowledges it with ack,
+// which Slack expects within three seconds. A submitted age modal with a
+// date that cannot be right stays open with the error under the date
+// picker; otherwise it closes and the age is posted.
+func (m *ageModal) interact(ctx context.Context, api slackAPI, callback *slack.InteractionCallback, ack func(payload ...interface{})) {
+	if callback.Type != slack.InteractionTypeViewSubmission || callback.View.CallbackID != ageModalCallbackID {
+		ack()
+		return
+	}
+
+	errs, reply := m.submit(ctx, api, callback)
+	if errs != nil {
+		ack(slack.NewErrorsViewSubmissionResponse(errs))
+		return
+	}
+
+	ack()
+
+	var metadata ageModalMetadata
+
+	err := json.Unmarshal([]byte(callback.View.PrivateMetadata), &metadata)
+	if err != nil {
+		log.Printf("Error reading the age form metadata: %v", err)
+		return
+	}
+
+	err = slash.Respond(ctx, metadata.ResponseURL, reply)
+	if err != nil {
+		log.Printf("Error answering the age form of %s: %v", callback.User.ID, err)
+	}
+}
+
+// submit returns the errors to show in a submitted age modal, by block,
+// or when there are none, the reply to post.
+func (m *ageModal) submit(ctx context.Context, api slackAPI, callback *slack.InteractionCallback) (map[string]string, string) {
+	var selected string
+
+	if callback.View.State != nil {
+		selected = callback.View.State.Values[birthDateBlockID][birthDateActionID].SelectedDate
+	}
+
+	birth, err := parseBirthDate(selected)
+	if err != nil {
+		return map[string]string{birthDateBlockID: sentence(err)}, ""
+	}
+
+	day := localDate(m.now(), userLocation(ctx, api, callback.User.ID))
+
+	err = checkBirthDate(birth, day)
+	if err != nil {
+		return map[string]string{birthDateBlockID: sentence(err)}, ""
+	}
+
+	return nil, ageReply(birth, day)
+}
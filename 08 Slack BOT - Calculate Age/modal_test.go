@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// slashEvent is the event slacker makes of "/command text".
+func slashEvent(t *testing.T, fake *slacktest.Server, command, user, text string) *slacker.MessageEvent {
+	t.Helper()
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"command":      "/" + command,
+		"text":         text,
+		"user_id":      user,
+		"channel_id":   "C0001",
+		"trigger_id":   "TRIGGER1",
+		"response_url": fake.ResponseURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &socketmode.Request{Type: socketmode.RequestTypeSlashCommands, Payload: payload}
+
+	return &slacker.MessageEvent{ChannelID: "C0001", UserID: user, Text: command + " " + text, Data: req, Type: req.Type}
+}
+
+func TestAgeSlashCommandOpensModal(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	modal := &ageModal{now: time.Now}
+
+	if !runCommand(fake, slashUsage, modal.command(), slashEvent(t, fake, "age", "UNY", "")) {
+		t.Fatal("did not match")
+	}
+
+	calls := fake.CallsTo("views.open")
+	if len(calls) != 1 {
+		t.Fatalf("views.open called %d times, want 1", len(calls))
+	}
+
+	if got := calls[0].Body["trigger_id"]; got != "TRIGGER1" {
+		t.Errorf("trigger_id = %v, want TRIGGER1", got)
+	}
+
+	view, _ := calls[0].Body["view"].(map[string]interface{})
+
+	if got := view["callback_id"]; got != ageModalCallbackID {
+		t.Errorf("callback_id = %v, want %s", got, ageModalCallbackID)
+	}
+
+	var metadata ageModalMetadata
+
+	err := json.Unmarshal([]byte(view["private_metadata"].(string)), &metadata)
+	if err != nil || metadata.ResponseURL != fake.ResponseURL() {
+		t.Errorf("private_metadata = %v, %v, want the response URL", view["private_metadata"], err)
+	}
+
+	if got := fake.EphemeralResponses(); len(got) != 0 {
+		t.Errorf("ephemeral replies = %q, want none", got)
+	}
+}
+
+func TestAgeSlashCommandWithDate(t *testing.T) {
+	now := func() time.Time { return time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"2000-03-02", "You are 25 years old. Happy birthday! :birthday:"},
+		{"2030", "2030 is in the future."},
+		{"soon", `Could not read "soon" as a date; try 2000-05-17, 17 May 2000 or May 17, 2000.`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			fake := slacktest.NewServer(t)
+			fake.AddUser("UAKL", slacktest.User{TZ: "Pacific/Auckland", TZOffset: 46800})
+			modal := &ageModal{now: now}
+
+			if !runCommand(fake, slashUsage, modal.command(), slashEvent(t, fake, "age", "UAKL", tt.text)) {
+				t.Fatal("did not match")
+			}
+
+			if got := fake.EphemeralResponses(); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("ephemeral replies = %q, want %q", got, tt.want)
+			}
+
+			if calls := len(fake.CallsTo("views.open")) + len(fake.CallsTo("chat.postMessage")); calls != 0 {
+				t.Errorf("made %d views.open and chat.postMessage calls, want 0", calls)
+			}
+		})
+	}
+}
+
+func TestAgeSlashCommandOpenFails(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.Fail("views.open", slacktest.SlackError("expired_trigger_id"))
+	modal := &ageModal{now: time.Now}
+
+	runCommand(fake, slashUsage, modal.command(), slashEvent(t, fake, "age", "UNY", ""))
+
+	want := []string{"Sorry, I could not open the form. Try `/age 2000-05-17` instead."}
+
+	if got := fake.EphemeralResponses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ephemeral replies = %q, want %q", got, want)
+	}
+}
+
+func TestAgeSlashCommandIgnoresMessages(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	modal := &ageModal{now: time.Now}
+
+	if !runCommand(fake, slashUsage, modal.command(), &slacker.MessageEvent{ChannelID: "C0001", UserID: "UNY", Text: "what age am I"}) {
+		t.Fatal("did not match")
+	}
+
+	if calls := len(fake.Calls()); calls != 0 {
+		t.Fatalf("made %d calls", calls)
+	}
+}
+
+// submission is a submitted age modal with date picked.
+func submission(fake *slacktest.Server, user, date string) *slack.InteractionCallback {
+	return &slack.InteractionCallback{
+		Type: slack.InteractionTypeViewSubmission,
+		User: slack.User{ID: user},
+		View: slack.View{
+			CallbackID:      ageModalCallbackID,
+			PrivateMetadata: `{"responseUrl":"` + fake.ResponseURL() + `"}`,
+			State: &slack.ViewState{Values: map[string]map[string]slack.BlockAction{
+				birthDateBlockID: {birthDateActionID: {SelectedDate: date}},
+			}},
+		},
+	}
+}
+
+func TestAgeModalSubmission(t *testing.T) {
+	now := func() time.Time { return time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		user      string
+		date      string
+		wantError string
+		wantReply string
+	}{
+		{"UNY", "2000-03-02", "", "You are 24 years, 11 months and 27 days old."},
+		{"UAKL", "2000-03-02", "", "You are 25 years old. Happy birthday! :birthday:"},
+		{"UNY", "2025-03-02", "2 March 2025 is in the future.", ""},
+		{"UNY", "1800-01-01", "That would make you over 150; check the year.", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.user+" "+tt.date, func(t *testing.T) {
+			fake := slacktest.NewServer(t)
+			fake.AddUser("UNY", slacktest.User{TZ: "America/New_York", TZOffset: -18000})
+			fake.AddUser("UAKL", slacktest.User{TZ: "Pacific/Auckland", TZOffset: 46800})
+			modal := &ageModal{now: now}
+
+			var acks [][]interface{}
+
+			modal.interact(context.Background(), fake.Client(), submission(fake, tt.user, tt.date), func(payload ...interface{}) {
+				acks = append(acks, payload)
+			})
+
+			if len(acks) != 1 {
+				t.Fatalf("acknowledged %d times, want 1", len(acks))
+			}
+
+			if tt.wantError != "" {
+				want := []interface{}{slack.NewErrorsViewSubmissionResponse(map[string]string{birthDateBlockID: tt.wantError})}
+
+				if !reflect.DeepEqual(acks[0], want) {
+					t.Errorf("ack = %+v, want %+v", acks[0], want)
+				}
+
+				if got := fake.EphemeralResponses(); len(got) != 0 {
+					t.Errorf("ephemeral replies = %q, want none", got)
+				}
+
+				return
+			}
+
+			if len(acks[0]) != 0 {
+				t.Errorf("ack = %+v, want no payload", acks[0])
+			}
+
+			if got := fake.EphemeralResponses(); !reflect.DeepEqual(got, []string{tt.wantReply}) {
+				t.Errorf("ephemeral replies = %q, want %q", got, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestInteractOtherEvents(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	modal := &ageModal{now: time.Now}
+
+	for _, callback := range []*slack.InteractionCallback{
+		{Type: slack.InteractionTypeBlockActions},
+		{Type: slack.InteractionTypeViewSubmission, View: slack.View{CallbackID: "other"}},
+	} {
+		acks := 0
+
+		modal.interact(context.Background(), fake.Client(), callback, func(payload ...interface{}) {
+			if len(payload) != 0 {
+				t.Errorf("%s: ack payload = %+v, want none", callback.Type, payload)
+			}
+
+			acks++
+		})
+
+		if acks != 1 {
+			t.Errorf("%s: acknowledged %d times, want 1", callback.Type, acks)
+		}
+	}
+
+	if calls := len(fake.Calls()); calls != 0 {
+		t.Fatalf("made %d calls", calls)
+	}
+}
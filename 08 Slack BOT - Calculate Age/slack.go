@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/fbdaf/slackbot/slash"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+)
+
+// slackAPI is the part of the Slack Web API the bot calls itself; replies
+// to commands go through slacker, or reply. *slack.Client implements it,
+// and tests point one at a fake server with slack.OptionAPIURL.
+type slackAPI interface {
+	AuthTestContext(ctx context.Context) (*slack.AuthTestResponse, error)
+	GetUserInfoContext(ctx context.Context, userID string) (*slack.User, error)
+	PostMessageContext(ctx context.Context, channelID string, options ...slack.MsgOption) (string, string, error)
+	OpenViewContext(ctx context.Context, triggerID string, view slack.ModalViewRequest) (*slack.ViewResponse, error)
+}
+
+// checkAuth makes sure the bot token works before the bot connects, so a
+// wrong token fails at startup instead of leaving a bot that never answers.
+// It returns the bot's identity, which includes its workspace.
+func checkAuth(ctx context.Context, api slackAPI) (*slack.AuthTestResponse, error) {
+	resp, err := api.AuthTestContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("auth test: %w", err)
+	}
+
+	log.Printf("Connected to %s as %s", resp.Team, resp.User)
+
+	return resp, nil
+}
+
+// reply answers the command behind botCtx with text, and gives outcome as
+// the command's outcome when it is recorded. A slash command is answered
+// through its response URL, so only the user who ran it sees the answer;
+// anything else is answered in the channel. A reply that cannot be posted
+// is logged and makes the outcome an error.
+func reply(botCtx slacker.BotContext, response slacker.ResponseWriter, outcome, text string) {
+	w, recorded := response.(*outcomeWriter)
+	if recorded {
+		w.set(outcome)
+	}
+
+	var err error
+
+	if cmd, ok := slash.Command(botCtx.Event()); ok {
+		err = slash.Respond(botCtx.Context(), cmd.ResponseURL, text)
+	} else {
+		err = response.Reply(text)
+	}
+
+	if err != nil {
+		log.Printf("Error replying to %s: %v", botCtx.Event().UserID, err)
+
+		if recorded {
+			w.set(analytics.OutcomeError)
+		}
+	}
+}
+
+// userLocation returns the time zone set in the user's Slack profile, so
+// that "today" is the user's date and not the server's. When the zone is
+// not in the local time zone database, the UTC offset Slack gives is used
+// instead. A profile without a zone gets one named after its offset, such
+// as "UTC+02:00", and when users.info fails, UTC.
+func userLocation(ctx context.Context, api slackAPI, userID string) *time.Location {
+	user, err := api.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		log.Printf("Error looking up the time zone of %s: %v", userID, err)
+		return time.UTC
+	}
+
+	if user.TZ == "" {
+		return offsetZone(user.TZOffset)
+	}
+
+	loc, err := time.LoadLocation(user.TZ)
+	if err != nil {
+		return time.FixedZone(user.TZ, user.TZOffset)
+	}
+
+	return loc
+}
+
+// offsetZone returns a zone offset seconds east of UTC, named after the
+// offset so that it is not mistaken for UTC itself.
+func offsetZone(offset int) *time.Location {
+	if offset == 0 {
+		return time.UTC
+	}
+
+	sign := '+'
+	abs := offset
+
+	if offset < 0 {
+		sign, abs = '-', -offset
+	}
+
+	return time.FixedZone(fmt.Sprintf("UTC%c%02d:%02d", sign, abs/3600, abs%3600/60), offset)
+}
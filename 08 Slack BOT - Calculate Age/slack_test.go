@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+)
+
+// runCommand does what slacker does with a message: if its text matches
+// usage, def's handler runs and its replies are posted to fake. It reports
+// whether the text matched.
+func runCommand(fake *slacktest.Server, usage string, def *slacker.CommandDefinition, ev *slacker.MessageEvent) bool {
+	cmd := slacker.NewCommand(usage, def)
+
+	params, ok := cmd.Match(ev.Text)
+
+	if !ok {
+		return false
+	}
+
+	botCtx := slacker.NewBotContext(context.Background(), fake.Client(), nil, ev)
+	cmd.Execute(botCtx, slacker.NewRequest(botCtx, params), slacker.NewResponse(botCtx))
+
+	return true
+}
+
+func TestCheckAuth(t *testing.T) {
+	fake := slacktest.NewServer(t)
+
+	resp, err := checkAuth(context.Background(), fake.Client())
+
+	if err != nil || resp.TeamID != "T0001" {
+		t.Fatalf("checkAuth = %+v, %v", resp, err)
+	}
+
+	fake.Fail("auth.test", slacktest.SlackError("invalid_auth"))
+
+	if _, err := checkAuth(context.Background(), fake.Client()); err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Fatalf("error = %v, want invalid_auth", err)
+	}
+
+	fake.Fail("auth.test", slacktest.RateLimited(30))
+
+	var rateLimited *slack.RateLimitedError
+
+	if _, err := checkAuth(context.Background(), fake.Client()); !errors.As(err, &rateLimited) || rateLimited.RetryAfter.Seconds() != 30 {
+		t.Fatalf("error = %v, want a rate limit of 30s", err)
+	}
+
+	fake.Fail("auth.test", slacktest.HTTPStatus(503))
+
+	var status slack.StatusCodeError
+
+	if _, err := checkAuth(context.Background(), fake.Client()); !errors.As(err, &status) || status.Code != 503 {
+		t.Fatalf("error = %v, want status 503", err)
+	}
+
+	if calls := len(fake.CallsTo("auth.test")); calls != 4 {
+		t.Errorf("made %d calls, want 4", calls)
+	}
+}
+
+func TestUserLocation(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.AddUser("UNY", slacktest.User{TZ: "America/New_York", TZOffset: -18000})
+	fake.AddUser("UOFF", slacktest.User{TZ: "Nowhere/Island", TZOffset: 14 * 3600})
+	fake.AddUser("UEAST", slacktest.User{TZOffset: 2 * 3600})
+	fake.AddUser("UWEST", slacktest.User{TZOffset: -(9*3600 + 30*60)})
+	fake.AddUser("UZERO", slacktest.User{})
+
+	tests := []struct {
+		user       string
+		wantName   string
+		wantOffset int
+	}{
+		{"UNY", "EST", -18000},
+		{"UOFF", "Nowhere/Island", 14 * 3600},
+		{"UEAST", "UTC+02:00", 2 * 3600},
+		{"UWEST", "UTC-09:30", -(9*3600 + 30*60)},
+		{"UZERO", "UTC", 0},
+		{"UMISSING", "UTC", 0},
+	}
+
+	at := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	for _, tt := range tests {
+		name, offset := at.In(userLocation(context.Background(), fake.Client(), tt.user)).Zone()
+
+		if name != tt.wantName || offset != tt.wantOffset {
+			t.Errorf("%s: zone = %s %d, want %s %d", tt.user, name, offset, tt.wantName, tt.wantOffset)
+		}
+	}
+}
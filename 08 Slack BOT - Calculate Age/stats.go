@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/fbdaf/slackbot/slash"
+	"github.com/shomali11/slacker"
+)
+
+// statsUsage is how slacker sees "/stats" and "/stats 30".
+const statsUsage = "stats {days}"
+
+const (
+	// defaultStatsDays is the period /stats covers when no days are given.
+	defaultStatsDays = 7
+	maxStatsDays     = 365
+)
+
+var usageParamPattern = regexp.MustCompile(`[<{](\w+)[>}]`)
+
+// birthParams are the parameters that hold a date or year of birth. They
+// are recorded as redactedParam, so the analytics show that one was given
+// without keeping it.
+var birthParams = map[string]bool{"date": true, "year": true}
+
+const redactedParam = "redacted"
+
+// recorded wraps def so that every run of the command is recorded with
+// the time it took and the outcome its handler gave to reply. The outcome
+// is an error when a reply could not be posted. Commands that only answer
+// slash commands, such as /age, also match messages that merely contain
+// their name; with slashOnly, those are not recorded, and the command is
+// recorded as "/age". Dates and years of birth are left out of the
+// recorded parameters.
+func recorded(recorder *analytics.Recorder, usage string, def *slacker.CommandDefinition, slashOnly bool) *slacker.CommandDefinition {
+	name := usage
+
+	if slashOnly {
+		name = "/" + strings.Fields(usage)[0]
+	}
+
+	wrapped := *def
+	wrapped.Handler = func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+		ev := botCtx.Event()
+
+		if _, ok := slash.Command(ev); slashOnly && !ok {
+			def.Handler(botCtx, request, response)
+			return
+		}
+
+		start := time.Now()
+		w := &outcomeWriter{ResponseWriter: response, outcome: analytics.OutcomeOK}
+
+		def.Handler(botCtx, request, w)
+
+		e := analytics.Event{
+			Time:    start,
+			User:    ev.UserID,
+			Channel: ev.ChannelID,
+			Command: name,
+			Params:  map[string]string{},
+			Latency: time.Since(start),
+			Outcome: w.outcome,
+		}
+
+		for _, m := range usageParamPattern.FindAllStringSubmatch(usage, -1) {
+			v := strings.TrimSpace(request.Param(m[1]))
+
+			switch {
+			case v == "":
+			case birthParams[m[1]]:
+				e.Params[m[1]] = redactedParam
+			default:
+				e.Params[m[1]] = v
+			}
+		}
+
+		recorder.Record(botCtx.Context(), e)
+	}
+
+	return &wrapped
+}
+
+// outcomeWriter keeps the outcome of a command. Handlers set it with
+// reply, and a reply that cannot be posted makes it an error.
+type outcomeWriter struct {
+	slacker.ResponseWriter
+	outcome string
+}
+
+// set records outcome, unless the command has already failed.
+func (w *outcomeWriter) set(outcome string) {
+	if w.outcome != analytics.OutcomeError {
+		w.outcome = outcome
+	}
+}
+
+func (w *outcomeWriter) Post(channel string, message string, options ...slacker.ReplyOption) error {
+	err := w.ResponseWriter.Post(channel, message, options...)
+	if err != nil {
+		w.set(analytics.OutcomeError)
+	}
+
+	return err
+}
+
+func (w *outcomeWriter) Reply(text string, options ...slacker.ReplyOption) error {
+	err := w.ResponseWriter.Reply(text, options...)
+	if err != nil {
+		w.set(analytics.OutcomeError)
+	}
+
+	return err
+}
+
+func (w *outcomeWriter) ReportError(err error, options ...slacker.ReportErrorOption) {
+	w.set(analytics.OutcomeError)
+	w.ResponseWriter.ReportError(err, options...)
+}
+
+// statsCommand answers /stats with a summary of the commands run in the
+// last days, only to the user who asked. Messages that merely contain
+// "stats" are ignored.
+func statsCommand(events analytics.Reader, now func() time.Time) *slacker.CommandDefinition {
+	return &slacker.CommandDefinition{
+		Description: fmt.Sprintf("/stats summarises how the bot was used in the last %d days, or the last days given", defaultStatsDays),
+		Examples:    []string{"/stats", "/stats 30"},
+		HideHelp:    true,
+		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+			if _, ok := slash.Command(botCtx.Event()); !ok {
+				return
+			}
+
+			days := defaultStatsDays
+
+			if text := request.Param("days"); text != "" {
+				n, err := strconv.Atoi(text)
+				if err != nil || n < 1 || n > maxStatsDays {
+					reply(botCtx, response, analytics.OutcomeInvalid, fmt.Sprintf("The days must be a whole number from 1 to %d.", maxStatsDays))
+					return
+				}
+
+				days = n
+			}
+
+			list, err := events.Events(botCtx.Context(), now().AddDate(0, 0, -days))
+			if err != nil {
+				log.Printf("Error reading command events: %v", err)
+				reply(botCtx, response, analytics.OutcomeError, "Sorry, I could not read the usage. Please try again later.")
+				return
+			}
+
+			reply(botCtx, response, analytics.OutcomeOK, analytics.Summarize(list).Report(days))
+		},
+	}
+}
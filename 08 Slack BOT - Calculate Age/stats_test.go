@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+)
+
+// newTestAnalytics returns a recorder that writes to a JSON-lines file in
+// a temporary directory, and the file to read the events back from.
+func newTestAnalytics(t *testing.T) (*analytics.Recorder, analytics.Reader) {
+	t.Helper()
+
+	recorder, events, err := analytics.Open(analytics.Config{JSONL: filepath.Join(t.TempDir(), "events.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { recorder.Close() })
+
+	return recorder, events
+}
+
+// recordedEvents returns the events recorded so far, without their times
+// and latencies, after checking that those are set.
+func recordedEvents(t *testing.T, events analytics.Reader) []analytics.Event {
+	t.Helper()
+
+	got, err := events.Events(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range got {
+		if got[i].Time.IsZero() || got[i].Latency <= 0 {
+			t.Errorf("event %d has time %s and latency %s", i, got[i].Time, got[i].Latency)
+		}
+
+		got[i].Time, got[i].Latency = time.Time{}, 0
+	}
+
+	return got
+}
+
+func TestRecordedCommand(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.AddUser("UNY", slacktest.User{TZ: "America/New_York", TZOffset: -18000})
+	recorder, events := newTestAnalytics(t)
+
+	def := recorded(recorder, yobUsage, yobCommand(time.Now), false)
+
+	runCommand(fake, yobUsage, def, &slacker.MessageEvent{ChannelID: "C0001", UserID: "UNY", Text: "my yob is 2000"})
+	runCommand(fake, yobUsage, def, &slacker.MessageEvent{ChannelID: "C0001", UserID: "UNY", Text: "my yob is"})
+
+	fake.Fail("chat.postMessage", slacktest.SlackError("not_in_channel"))
+	runCommand(fake, yobUsage, def, &slacker.MessageEvent{ChannelID: "C0002", UserID: "UNY", Text: "my yob is 1990"})
+
+	want := []analytics.Event{
+		{User: "UNY", Channel: "C0001", Command: yobUsage, Params: map[string]string{"year": "redacted"}, Outcome: analytics.OutcomeOK},
+		{User: "UNY", Channel: "C0001", Command: yobUsage, Outcome: analytics.OutcomeInvalid},
+		{User: "UNY", Channel: "C0002", Command: yobUsage, Params: map[string]string{"year": "redacted"}, Outcome: analytics.OutcomeError},
+	}
+
+	if got := recordedEvents(t, events); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+}
+
+func TestRecordedSlashCommand(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	recorder, events := newTestAnalytics(t)
+
+	def := recorded(recorder, slashUsage, (&ageModal{now: time.Now}).command(), true)
+
+	runCommand(fake, slashUsage, def, &slacker.MessageEvent{ChannelID: "C0001", UserID: "UNY", Text: "what age am I"})
+	runCommand(fake, slashUsage, def, slashEvent(t, fake, "age", "UNY", "2000-05-17"))
+	runCommand(fake, slashUsage, def, slashEvent(t, fake, "age", "UNY", "2100-05-17"))
+	runCommand(fake, slashUsage, def, slashEvent(t, fake, "age", "UNY", "the 17th"))
+
+	fake.Fail("response", slacktest.HTTPStatus(500))
+	runCommand(fake, slashUsage, def, slashEvent(t, fake, "age", "UNY", "1990-05-17"))
+
+	want := []analytics.Event{
+		{User: "UNY", Channel: "C0001", Command: "/age", Params: map[string]string{"date": "redacted"}, Outcome: analytics.OutcomeOK},
+		{User: "UNY", Channel: "C0001", Command: "/age", Params: map[string]string{"date": "redacted"}, Outcome: analytics.OutcomeInvalid},
+		{User: "UNY", Channel: "C0001", Command: "/age", Params: map[string]string{"date": "redacted"}, Outcome: analytics.OutcomeInvalid},
+		{User: "UNY", Channel: "C0001", Command: "/age", Params: map[string]string{"date": "redacted"}, Outcome: analytics.OutcomeError},
+	}
+
+	if got := recordedEvents(t, events); !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsCommand(t *testing.T) {
+	recorder, events := newTestAnalytics(t)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, e := range []analytics.Event{
+		{Time: now.AddDate(0, 0, -20), User: "U0002", Command: "/age", Latency: time.Millisecond, Outcome: analytics.OutcomeOK},
+		{Time: now.AddDate(0, 0, -3), User: "U0002", Command: yobUsage, Latency: 4 * time.Millisecond, Outcome: analytics.OutcomeOK},
+		{Time: now.AddDate(0, 0, -1), User: "U0003", Command: yobUsage, Latency: 8 * time.Millisecond, Outcome: analytics.OutcomeError},
+	} {
+		recorder.Record(context.Background(), e)
+	}
+
+	tests := []struct {
+		text    string
+		want    string
+		outcome string
+	}{
+		{"", "*Usage in the last 7 days*\n2 commands from 2 users: 1 ok, 1 error.\n• `my yob is <year>`: 2 runs, median 4 ms, 95th percentile 8 ms, 1 failed", analytics.OutcomeOK},
+		{"30", "*Usage in the last 30 days*\n3 commands from 2 users: 2 ok, 1 error.\n• `my yob is <year>`: 2 runs, median 4 ms, 95th percentile 8 ms, 1 failed\n• `/age`: 1 run, median 1 ms, 95th percentile 1 ms", analytics.OutcomeOK},
+		{"0", "The days must be a whole number from 1 to 365.", analytics.OutcomeInvalid},
+		{"week", "The days must be a whole number from 1 to 365.", analytics.OutcomeInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			fake := slacktest.NewServer(t)
+			statsRecorder, statsEvents := newTestAnalytics(t)
+			def := recorded(statsRecorder, statsUsage, statsCommand(events, func() time.Time { return now }), true)
+
+			if !runCommand(fake, statsUsage, def, slashEvent(t, fake, "stats", "U0002", tt.text)) {
+				t.Fatal("did not match")
+			}
+
+			if got := fake.EphemeralResponses(); !reflect.DeepEqual(got, []string{tt.want}) {
+				t.Errorf("ephemeral replies = %q, want %q", got, tt.want)
+			}
+
+			if got := recordedEvents(t, statsEvents); len(got) != 1 || got[0].Outcome != tt.outcome {
+				t.Errorf("events = %+v, want one with outcome %s", got, tt.outcome)
+			}
+		})
+	}
+}
+
+// brokenReader is a Reader whose events cannot be read.
+type brokenReader struct{}
+
+func (brokenReader) Events(ctx context.Context, since time.Time) ([]analytics.Event, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func TestStatsCommandFailures(t *testing.T) {
+	tests := map[string]struct {
+		events  analytics.Reader
+		fail    bool
+		want    []string
+		outcome string
+	}{
+		"read":  {brokenReader{}, false, []string{"Sorry, I could not read the usage. Please try again later."}, analytics.OutcomeError},
+		"reply": {nil, true, []string{"No commands in the last 7 days."}, analytics.OutcomeError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			fake := slacktest.NewServer(t)
+			recorder, events := newTestAnalytics(t)
+
+			if tt.events == nil {
+				tt.events = events
+			}
+
+			if tt.fail {
+				fake.Fail("response", slacktest.HTTPStatus(500))
+			}
+
+			def := recorded(recorder, statsUsage, statsCommand(tt.events, time.Now), true)
+
+			if !runCommand(fake, statsUsage, def, slashEvent(t, fake, "stats", "U0002", "")) {
+				t.Fatal("did not match")
+			}
+
+			if got := fake.EphemeralResponses(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ephemeral replies = %q, want %q", got, tt.want)
+			}
+
+			if got := recordedEvents(t, events); len(got) != 1 || got[0].Outcome != tt.outcome {
+				t.Errorf("events = %+v, want one with outcome %s", got, tt.outcome)
+			}
+		})
+	}
+}
+
+func TestStatsCommandIgnoresMessages(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	_, events := newTestAnalytics(t)
+
+	if !runCommand(fake, statsUsage, statsCommand(events, time.Now), &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "the stats look good"}) {
+		t.Fatal("did not match")
+	}
+
+	if calls := len(fake.Calls()); calls != 0 {
+		t.Fatalf("made %d calls", calls)
+	}
+}
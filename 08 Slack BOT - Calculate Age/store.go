@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dateFormat is how birth dates are stored.
+const dateFormat = "2006-01-02"
+
+// birthday is a birth date a user asked the bot to remember.
+type birthday struct {
+	// Team is the workspace and User the user in it, as Slack IDs.
+	Team string `json:"team"`
+	User string `json:"user"`
+	Date string `json:"date"`
+	// LastGreeted is the year of the last birthday message, so a message
+	// is not posted twice when the bot restarts on someone's birthday.
+	LastGreeted int `json:"lastGreeted,omitempty"`
+}
+
+func (b birthday) date() time.Time {
+	date, _ := time.Parse(dateFormat, b.Date)
+
+	return date
+}
+
+type birthdayKey struct {
+	team string
+	user string
+}
+
+// birthdayStore is a JSON file of the birthdays the bot knows. It is saved
+// after every change, and a change that could not be saved is not made, so
+// what the bot does always matches what it told the user and what it will
+// load after a restart.
+type birthdayStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[birthdayKey]birthday
+}
+
+type birthdayFile struct {
+	Version   int        `json:"version"`
+	Birthdays []birthday `json:"birthdays"`
+}
+
+// loadBirthdayStore reads the store at path. A missing file is an empty
+// store.
+func loadBirthdayStore(path string) (*birthdayStore, error) {
+	s := &birthdayStore{path: path, entries: map[birthdayKey]birthday{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f birthdayFile
+
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("birthday store %s: %w", path, err)
+	}
+
+	if f.Version != 1 {
+		return nil, fmt.Errorf("birthday store %s: unsupported version %d", path, f.Version)
+	}
+
+	for _, b := range f.Birthdays {
+		if _, err := time.Parse(dateFormat, b.Date); err != nil {
+			return nil, fmt.Errorf("birthday store %s: user %s: %w", path, b.User, err)
+		}
+
+		s.entries[birthdayKey{b.Team, b.User}] = b
+	}
+
+	return s, nil
+}
+
+// set stores the birth date of a user. Changing the date to the one that
+// was already stored keeps LastGreeted.
+func (s *birthdayStore) set(team, user string, date time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := birthdayKey{team, user}
+	b := birthday{Team: team, User: user, Date: date.Format(dateFormat)}
+
+	if prev, ok := s.entries[key]; ok && prev.Date == b.Date {
+		b.LastGreeted = prev.LastGreeted
+	}
+
+	entries := s.copyEntries()
+	entries[key] = b
+
+	return s.commit(entries)
+}
+
+// remove forgets the birthday of a user and reports whether it was known.
+func (s *birthdayStore) remove(team, user string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := birthdayKey{team, user}
+
+	if _, ok := s.entries[key]; !ok {
+		return false, nil
+	}
+
+	entries := s.copyEntries()
+	delete(entries, key)
+
+	return true, s.commit(entries)
+}
+
+// markGreeted records that the birthday message for year was posted.
+func (s *birthdayStore) markGreeted(team, user string, year int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := birthdayKey{team, user}
+
+	b, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+
+	b.LastGreeted = year
+
+	entries := s.copyEntries()
+	entries[key] = b
+
+	return s.commit(entries)
+}
+
+// list returns the birthdays in a workspace, ordered by user.
+func (s *birthdayStore) list(team string) []birthday {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var birthdays []birthday
+
+	for _, b := range s.entries {
+		if b.Team == team {
+			birthdays = append(birthdays, b)
+		}
+	}
+
+	sort.Slice(birthdays, func(i, j int) bool {
+		return birthdays[i].User < birthdays[j].User
+	})
+
+	return birthdays
+}
+
+// copyEntries returns a copy of the entries for a change to be made on.
+// The caller holds s.mu.
+func (s *birthdayStore) copyEntries() map[birthdayKey]birthday {
+	entries := make(map[birthdayKey]birthday, len(s.entries)+1)
+
+	for k, b := range s.entries {
+		entries[k] = b
+	}
+
+	return entries
+}
+
+// commit saves entries and, once they are on disk, makes them the store's.
+// The caller holds s.mu.
+func (s *birthdayStore) commit(entries map[birthdayKey]birthday) error {
+	if err := s.save(entries); err != nil {
+		return err
+	}
+
+	s.entries = entries
+
+	return nil
+}
+
+// save writes entries as the whole birthday file. LastGreeted is what keeps
+// a restart from posting a second birthday message, so the file is swapped
+// in only once it is complete: a bot stopped mid-write restarts with the
+// previous birthdays and greetings rather than an unreadable store.
+func (s *birthdayStore) save(entries map[birthdayKey]birthday) error {
+	f := birthdayFile{Version: 1, Birthdays: make([]birthday, 0, len(entries))}
+
+	for _, b := range entries {
+		f.Birthdays = append(f.Birthdays, b)
+	}
+
+	sort.Slice(f.Birthdays, func(i, j int) bool {
+		if f.Birthdays[i].Team != f.Birthdays[j].Team {
+			return f.Birthdays[i].Team < f.Birthdays[j].Team
+		}
+
+		return f.Birthdays[i].User < f.Birthdays[j].User
+	})
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(data, '\n'))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBirthdayStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "birthdays.json")
+
+	s, err := loadBirthdayStore(path)
+
+	if err != nil {
+		t.Fatalf("missing store: %v", err)
+	}
+
+	for _, b := range []birthday{
+		{Team: "T1", User: "U2", Date: "1990-12-01"},
+		{Team: "T1", User: "U1", Date: "2000-05-17"},
+		{Team: "T2", User: "U1", Date: "1985-01-30"},
+	} {
+		if err := s.set(b.Team, b.User, b.date()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.markGreeted("T1", "U1", 2025); err != nil {
+		t.Fatal(err)
+	}
+
+	// Setting the same date again keeps LastGreeted; a new date clears it.
+	if err := s.set("T1", "U1", date(2000, 5, 17)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.markGreeted("T1", "U2", 2025); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.set("T1", "U2", date(1990, 12, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	if known, err := s.remove("T2", "U1"); !known || err != nil {
+		t.Fatalf("remove = %v, %v", known, err)
+	}
+
+	if known, err := s.remove("T2", "U1"); known || err != nil {
+		t.Fatalf("second remove = %v, %v", known, err)
+	}
+
+	reloaded, err := loadBirthdayStore(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := reloaded.list("T1")
+	want := []birthday{
+		{Team: "T1", User: "U1", Date: "2000-05-17", LastGreeted: 2025},
+		{Team: "T1", User: "U2", Date: "1990-12-02"},
+	}
+
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("list = %+v, want %+v", got, want)
+	}
+
+	if other := reloaded.list("T2"); len(other) != 0 {
+		t.Fatalf("T2 still has %+v", other)
+	}
+}
+
+func TestLoadBirthdayStoreErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		wantErr string
+	}{
+		{`{"version": 2, "birthdays": []}`, "unsupported version 2"},
+		{`{"version": 1, "birthdays": [{"team": "T1", "user": "U1", "date": "17 May"}]}`, "user U1"},
+		{`[`, "unexpected end"},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "birthdays.json")
+
+		if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := loadBirthdayStore(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("loadBirthdayStore(%s) error = %v, want %q", tt.content, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBirthdayStoreSaveFails(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := loadBirthdayStore(filepath.Join(dir, "birthdays.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.set("T1", "U1", date(2000, 5, 17)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Saving into a directory that does not exist fails every time.
+	s.path = filepath.Join(dir, "missing", "birthdays.json")
+
+	if err := s.set("T1", "U1", date(2001, 6, 18)); err == nil {
+		t.Error("set succeeded")
+	}
+
+	if err := s.set("T1", "U2", date(1990, 1, 1)); err == nil {
+		t.Error("set of a new user succeeded")
+	}
+
+	if err := s.markGreeted("T1", "U1", 2025); err == nil {
+		t.Error("markGreeted succeeded")
+	}
+
+	if known, err := s.remove("T1", "U1"); !known || err == nil {
+		t.Errorf("remove = %v, %v, want known and an error", known, err)
+	}
+
+	want := []birthday{{Team: "T1", User: "U1", Date: "2000-05-17"}}
+
+	if got := s.list("T1"); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("list = %+v, want %+v unchanged", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+// Package command is the bot's command system. A command describes itself
+// with a Spec and is added to a Registry, which reads the messages sent to
+// the bot, parses the arguments, checks permissions and runs the command.
+// The registry writes the help from the specs.
+//
+// Commands do not depend on Slack or on the rest of the bot, so they can
+// live in their own packages:
+//
+//	var Echo = command.New(command.Spec{
+//		Name:        "echo",
+//		Args:        []command.Arg{{Name: "text", Rest: true}},
+//		Description: "repeat a message",
+//		Examples:    []string{"echo hello there"},
+//	}, func(ctx context.Context, req command.Request) (string, error) {
+//		return req.Args.Get("text"), nil
+//	})
+package command
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command is something the bot can be asked to do.
+type Command interface {
+	// Spec describes the command. It is read once, when the command is
+	// registered.
+	Spec() Spec
+	// Run runs the command and returns the reply. An error made with
+	// Errorf is shown to the user as it is; any other error is logged and
+	// the user is told the command failed.
+	Run(ctx context.Context, req Request) (string, error)
+}
+
+// Spec is the name, arguments and help of a command.
+type Spec struct {
+	// Name is the word that runs the command, such as "ping". It is
+	// matched without regard to case.
+	Name string
+	// Args are the arguments that follow the name, in order.
+	Args        []Arg
+	Description string
+	Examples    []string
+	// Allow decides who may run the command. When it is nil, everyone
+	// may.
+	Allow Permission
+	// Hidden leaves the command out of the list of commands in help.
+	Hidden bool
+}
+
+// Arg is an argument of a command. Arguments are separated by spaces,
+// except for a Rest argument, which takes the rest of the message. Only
+// the last argument can be Rest, and optional arguments come after the
+// required ones.
+type Arg struct {
+	Name     string
+	Optional bool
+	Rest     bool
+}
+
+// Usage returns how to run the command, such as "echo <text...>" or
+// "roll [sides]".
+func (s Spec) Usage() string {
+	words := []string{s.Name}
+
+	for _, a := range s.Args {
+		name := a.Name
+
+		if a.Rest {
+			name += "..."
+		}
+
+		if a.Optional {
+			words = append(words, "["+name+"]")
+		} else {
+			words = append(words, "<"+name+">")
+		}
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Request is a command to run, as the registry parsed it.
+type Request struct {
+	UserID    string
+	ChannelID string
+	Args      Args
+}
+
+// Args are the arguments of a command by name. Optional arguments that
+// were not given are missing.
+type Args map[string]string
+
+// Get returns the argument name, or "" if it was not given.
+func (a Args) Get(name string) string {
+	return a[name]
+}
+
+// Int returns the argument name as a whole number, or def if it was not
+// given. An argument that is not a number is an error for the user.
+func (a Args) Int(name string, def int) (int, error) {
+	s, ok := a[name]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, Errorf("The %s must be a whole number, not %q.", name, s)
+	}
+
+	return n, nil
+}
+
+// Permission decides whether a user may run a command in a channel.
+type Permission func(ctx context.Context, userID, channelID string) bool
+
+// Users lets only the users with the given IDs run a command.
+func Users(ids ...string) Permission {
+	allowed := make(map[string]bool, len(ids))
+
+	for _, id := range ids {
+		allowed[id] = true
+	}
+
+	return func(ctx context.Context, userID, channelID string) bool {
+		return allowed[userID]
+	}
+}
+
+// Channels lets a command run only in the channels with the given IDs.
+func Channels(ids ...string) Permission {
+	allowed := make(map[string]bool, len(ids))
+
+	for _, id := range ids {
+		allowed[id] = true
+	}
+
+	return func(ctx context.Context, userID, channelID string) bool {
+		return allowed[channelID]
+	}
+}
+
+// UserError is an error meant for the user who ran a command. Its message
+// is the reply.
+type UserError struct {
+	msg string
+}
+
+// Errorf returns a UserError with the formatted message.
+func Errorf(format string, args ...interface{}) error {
+	return &UserError{msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *UserError) Error() string {
+	return e.msg
+}
+
+// New returns a command with spec that runs run.
+func New(spec Spec, run func(ctx context.Context, req Request) (string, error)) Command {
+	return funcCommand{spec: spec, run: run}
+}
+
+type funcCommand struct {
+	spec Spec
+	run  func(ctx context.Context, req Request) (string, error)
+}
+
+func (c funcCommand) Spec() Spec {
+	return c.spec
+}
+
+func (c funcCommand) Run(ctx context.Context, req Request) (string, error) {
+	return c.run(ctx, req)
+}
@@ -0,0 +1,302 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Registry holds the commands of a bot and runs them. It comes with a help
+// command. Commands are registered at startup, before the bot handles
+// messages; Handle can then be called from several goroutines.
+type Registry struct {
+	commands map[string]entry
+}
+
+type entry struct {
+	spec Spec
+	cmd  Command
+}
+
+// Message is a message sent to the bot.
+type Message struct {
+	UserID    string
+	ChannelID string
+	Text      string
+}
+
+// Result is what came of a message that asked for a command.
+type Result struct {
+	// Command is the name of the command as it was registered.
+	Command string
+	// Args are the parsed arguments, when parsing them succeeded.
+	Args    Args
+	Reply   string
+	Outcome Outcome
+}
+
+// Outcome is how a command that was asked for went.
+type Outcome int
+
+const (
+	// Ran is a command that ran and returned its reply.
+	Ran Outcome = iota
+	// Invalid is a command that was used wrongly or returned an error
+	// made with Errorf.
+	Invalid
+	// Denied is a command the user was not allowed to run.
+	Denied
+	// Failed is a command that returned any other error.
+	Failed
+)
+
+// NewRegistry returns a registry with only the help command.
+func NewRegistry() *Registry {
+	r := &Registry{commands: map[string]entry{}}
+
+	err := r.Register(r.helpCommand())
+	if err != nil {
+		panic(err)
+	}
+
+	return r
+}
+
+// Register adds commands to the registry. It fails on a spec that is not
+// valid or on a name that is taken, before adding any of them.
+func (r *Registry) Register(cmds ...Command) error {
+	added := map[string]entry{}
+
+	for _, cmd := range cmds {
+		spec := cmd.Spec()
+
+		err := checkSpec(spec)
+		if err != nil {
+			return err
+		}
+
+		key := strings.ToLower(spec.Name)
+
+		if _, ok := r.commands[key]; ok {
+			return fmt.Errorf("command %q is already registered", spec.Name)
+		}
+
+		if _, ok := added[key]; ok {
+			return fmt.Errorf("command %q is registered twice", spec.Name)
+		}
+
+		added[key] = entry{spec: spec, cmd: cmd}
+	}
+
+	for key, e := range added {
+		r.commands[key] = e
+	}
+
+	return nil
+}
+
+func checkSpec(spec Spec) error {
+	if spec.Name == "" || strings.IndexFunc(spec.Name, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("command %q: the name must be one word", spec.Name)
+	}
+
+	names := map[string]bool{}
+	optional := false
+
+	for i, a := range spec.Args {
+		switch {
+		case a.Name == "":
+			return fmt.Errorf("command %q: argument %d has no name", spec.Name, i+1)
+		case names[a.Name]:
+			return fmt.Errorf("command %q: argument %q is there twice", spec.Name, a.Name)
+		case a.Rest && i < len(spec.Args)-1:
+			return fmt.Errorf("command %q: only the last argument can take the rest of the message", spec.Name)
+		case optional && !a.Optional:
+			return fmt.Errorf("command %q: required argument %q comes after an optional one", spec.Name, a.Name)
+		}
+
+		names[a.Name] = true
+		optional = a.Optional
+	}
+
+	return nil
+}
+
+// Specs returns the specs of the registered commands, ordered by name.
+func (r *Registry) Specs() []Spec {
+	specs := make([]Spec, 0, len(r.commands))
+
+	for _, e := range r.commands {
+		specs = append(specs, e.spec)
+	}
+
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+
+	return specs
+}
+
+// Handle runs the command msg asks for and returns the result. ok is false
+// when msg does not start with the name of a command, so that the bot
+// stays out of conversations that are not meant for it. A leading mention
+// of the bot is skipped.
+func (r *Registry) Handle(ctx context.Context, msg Message) (res Result, ok bool) {
+	name, rest := nextWord(stripMention(msg.Text))
+
+	e, ok := r.commands[strings.ToLower(name)]
+	if !ok {
+		return Result{}, false
+	}
+
+	res.Command = e.spec.Name
+
+	if !allowed(ctx, e.spec, msg.UserID, msg.ChannelID) {
+		res.Reply = fmt.Sprintf("Sorry, you are not allowed to use `%s` here.", e.spec.Name)
+		res.Outcome = Denied
+
+		return res, true
+	}
+
+	args, err := parseArgs(e.spec, rest)
+	if err != nil {
+		res.Reply = err.Error()
+		res.Outcome = Invalid
+
+		return res, true
+	}
+
+	res.Args = args
+
+	reply, err := e.cmd.Run(ctx, Request{UserID: msg.UserID, ChannelID: msg.ChannelID, Args: args})
+
+	var userErr *UserError
+
+	switch {
+	case errors.As(err, &userErr):
+		res.Reply = userErr.Error()
+		res.Outcome = Invalid
+	case err != nil:
+		log.Printf("Error running %s: %v", e.spec.Name, err)
+		res.Reply = fmt.Sprintf("Sorry, `%s` failed. Please try again later.", e.spec.Name)
+		res.Outcome = Failed
+	default:
+		res.Reply = reply
+		res.Outcome = Ran
+	}
+
+	return res, true
+}
+
+func allowed(ctx context.Context, spec Spec, userID, channelID string) bool {
+	return spec.Allow == nil || spec.Allow(ctx, userID, channelID)
+}
+
+// stripMention removes a leading "<@U0123>", as in "@bot ping".
+func stripMention(text string) string {
+	text = strings.TrimSpace(text)
+
+	if strings.HasPrefix(text, "<@") {
+		if end := strings.IndexByte(text, '>'); end >= 0 {
+			text = text[end+1:]
+		}
+	}
+
+	return strings.TrimSpace(text)
+}
+
+// nextWord splits s into its first word and the rest, without the spaces
+// around them.
+func nextWord(s string) (word, rest string) {
+	s = strings.TrimSpace(s)
+
+	end := strings.IndexFunc(s, unicode.IsSpace)
+	if end < 0 {
+		return s, ""
+	}
+
+	return s[:end], strings.TrimSpace(s[end:])
+}
+
+// parseArgs reads the arguments in text, which follows the command name.
+func parseArgs(spec Spec, text string) (Args, error) {
+	args := Args{}
+
+	for _, a := range spec.Args {
+		var value string
+
+		if a.Rest {
+			value, text = strings.TrimSpace(text), ""
+		} else {
+			value, text = nextWord(text)
+		}
+
+		if value == "" {
+			if a.Optional {
+				continue
+			}
+
+			return nil, Errorf("Missing %s. Usage: `%s`", a.Name, spec.Usage())
+		}
+
+		args[a.Name] = value
+	}
+
+	if text != "" {
+		return nil, Errorf("Too many arguments. Usage: `%s`", spec.Usage())
+	}
+
+	return args, nil
+}
+
+// helpCommand lists the commands the asking user may run, or explains one
+// of them.
+func (r *Registry) helpCommand() Command {
+	return New(Spec{
+		Name:        "help",
+		Args:        []Arg{{Name: "command", Optional: true}},
+		Description: "list the commands, or explain one",
+		Examples:    []string{"help", "help ping"},
+	}, func(ctx context.Context, req Request) (string, error) {
+		if name := req.Args.Get("command"); name != "" {
+			e, ok := r.commands[strings.ToLower(name)]
+			if !ok || !allowed(ctx, e.spec, req.UserID, req.ChannelID) {
+				return "", Errorf("I do not know the command %q. Say `help` for the list.", name)
+			}
+
+			return explain(e.spec), nil
+		}
+
+		lines := []string{"*Commands*"}
+
+		for _, spec := range r.Specs() {
+			if spec.Hidden || !allowed(ctx, spec, req.UserID, req.ChannelID) {
+				continue
+			}
+
+			lines = append(lines, fmt.Sprintf("• `%s` – %s", spec.Usage(), spec.Description))
+		}
+
+		lines = append(lines, "Say `help <command>` for examples.")
+
+		return strings.Join(lines, "\n"), nil
+	})
+}
+
+func explain(spec Spec) string {
+	lines := []string{fmt.Sprintf("`%s` – %s", spec.Usage(), spec.Description)}
+
+	if len(spec.Examples) > 0 {
+		lines = append(lines, "*Examples*")
+
+		for _, ex := range spec.Examples {
+			lines = append(lines, fmt.Sprintf("• `%s`", ex))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
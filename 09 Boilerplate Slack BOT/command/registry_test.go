@@ -0,0 +1,239 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// echo replies with its arguments, so tests can see how they were parsed.
+func echo(spec Spec) Command {
+	return New(spec, func(ctx context.Context, req Request) (string, error) {
+		var parts []string
+
+		for _, a := range spec.Args {
+			if v, ok := req.Args[a.Name]; ok {
+				parts = append(parts, a.Name+"="+v)
+			}
+		}
+
+		return strings.Join(parts, " "), nil
+	})
+}
+
+func newTestRegistry(t *testing.T, cmds ...Command) *Registry {
+	t.Helper()
+
+	r := NewRegistry()
+
+	err := r.Register(cmds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		spec Spec
+		want string
+	}{
+		{Spec{Name: "ping"}, "ping"},
+		{Spec{Name: "echo", Args: []Arg{{Name: "text", Rest: true}}}, "echo <text...>"},
+		{Spec{Name: "roll", Args: []Arg{{Name: "dice"}, {Name: "sides", Optional: true}}}, "roll <dice> [sides]"},
+		{Spec{Name: "note", Args: []Arg{{Name: "text", Optional: true, Rest: true}}}, "note [text...]"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.spec.Usage(); got != tt.want {
+			t.Errorf("Usage() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	tests := []struct {
+		spec    Spec
+		wantErr string
+	}{
+		{Spec{}, "one word"},
+		{Spec{Name: "two words"}, "one word"},
+		{Spec{Name: "Help"}, "already registered"},
+		{Spec{Name: "x", Args: []Arg{{}}}, "argument 1 has no name"},
+		{Spec{Name: "x", Args: []Arg{{Name: "a"}, {Name: "a"}}}, "twice"},
+		{Spec{Name: "x", Args: []Arg{{Name: "a", Rest: true}, {Name: "b"}}}, "only the last argument"},
+		{Spec{Name: "x", Args: []Arg{{Name: "a", Optional: true}, {Name: "b"}}}, "comes after an optional one"},
+	}
+
+	for _, tt := range tests {
+		err := NewRegistry().Register(echo(tt.spec))
+
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Register(%+v) = %v, want %q", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRegisterIsAllOrNothing(t *testing.T) {
+	r := NewRegistry()
+
+	err := r.Register(echo(Spec{Name: "ping"}), echo(Spec{Name: "PING"}))
+	if err == nil {
+		t.Fatal("registered the same name twice")
+	}
+
+	if specs := r.Specs(); len(specs) != 1 || specs[0].Name != "help" {
+		t.Fatalf("Specs() = %+v, want only help", specs)
+	}
+}
+
+func TestHandleArgs(t *testing.T) {
+	r := newTestRegistry(t,
+		echo(Spec{Name: "ping"}),
+		echo(Spec{Name: "echo", Args: []Arg{{Name: "text", Rest: true}}}),
+		echo(Spec{Name: "roll", Args: []Arg{{Name: "dice"}, {Name: "sides", Optional: true}}}),
+	)
+
+	tests := []struct {
+		text   string
+		want   string
+		wantOK bool
+	}{
+		{"ping", "", true},
+		{"  PING  ", "", true},
+		{"<@U0001> ping", "", true},
+		{"ping pong", "Too many arguments. Usage: `ping`", true},
+		{"echo  hello,   world ", "text=hello,   world", true},
+		{"echo", "Missing text. Usage: `echo <text...>`", true},
+		{"roll 2", "dice=2", true},
+		{"roll 2 6", "dice=2 sides=6", true},
+		{"roll 2 6 8", "Too many arguments. Usage: `roll <dice> [sides]`", true},
+		{"hello there", "", false},
+		{"", "", false},
+		{"please ping", "", false},
+	}
+
+	for _, tt := range tests {
+		res, ok := r.Handle(context.Background(), Message{UserID: "U0002", ChannelID: "C0001", Text: tt.text})
+
+		if res.Reply != tt.want || ok != tt.wantOK {
+			t.Errorf("Handle(%q) = %q, %v, want %q, %v", tt.text, res.Reply, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestHandleErrors(t *testing.T) {
+	r := newTestRegistry(t,
+		New(Spec{Name: "count", Args: []Arg{{Name: "n", Optional: true}}}, func(ctx context.Context, req Request) (string, error) {
+			n, err := req.Args.Int("n", 3)
+			if err != nil {
+				return "", err
+			}
+
+			return strings.Repeat("*", n), nil
+		}),
+		New(Spec{Name: "broken"}, func(ctx context.Context, req Request) (string, error) {
+			return "", errors.New("database is down")
+		}),
+	)
+
+	tests := []struct {
+		text        string
+		want        string
+		wantOutcome Outcome
+	}{
+		{"count", "***", Ran},
+		{"count 5", "*****", Ran},
+		{"count five", `The n must be a whole number, not "five".`, Invalid},
+		{"count 5 6", "Too many arguments. Usage: `count [n]`", Invalid},
+		{"broken", "Sorry, `broken` failed. Please try again later.", Failed},
+	}
+
+	for _, tt := range tests {
+		res, _ := r.Handle(context.Background(), Message{Text: tt.text})
+
+		if res.Reply != tt.want || res.Outcome != tt.wantOutcome {
+			t.Errorf("Handle(%q) = %q, %d, want %q, %d", tt.text, res.Reply, res.Outcome, tt.want, tt.wantOutcome)
+		}
+	}
+}
+
+func TestHandlePermissions(t *testing.T) {
+	ran := 0
+
+	r := newTestRegistry(t,
+		New(Spec{Name: "deploy", Allow: Users("UADMIN")}, func(ctx context.Context, req Request) (string, error) {
+			ran++
+			return "deploying", nil
+		}),
+		echo(Spec{Name: "standup", Allow: Channels("CTEAM")}),
+	)
+
+	tests := []struct {
+		user, channel, text string
+		want                string
+	}{
+		{"UADMIN", "C0001", "deploy", "deploying"},
+		{"U0002", "C0001", "deploy", "Sorry, you are not allowed to use `deploy` here."},
+		{"U0002", "C0001", "DEPLOY now", "Sorry, you are not allowed to use `deploy` here."},
+		{"U0002", "CTEAM", "standup", ""},
+		{"U0002", "C0001", "standup", "Sorry, you are not allowed to use `standup` here."},
+	}
+
+	for _, tt := range tests {
+		if res, _ := r.Handle(context.Background(), Message{UserID: tt.user, ChannelID: tt.channel, Text: tt.text}); res.Reply != tt.want {
+			t.Errorf("%s in %s: Handle(%q) = %q, want %q", tt.user, tt.channel, tt.text, res.Reply, tt.want)
+		}
+	}
+
+	if ran != 1 {
+		t.Errorf("deploy ran %d times, want 1", ran)
+	}
+}
+
+func TestHelp(t *testing.T) {
+	r := newTestRegistry(t,
+		echo(Spec{Name: "ping", Description: "check that the bot is up", Examples: []string{"ping"}}),
+		echo(Spec{Name: "echo", Args: []Arg{{Name: "text", Rest: true}}, Description: "repeat a message"}),
+		echo(Spec{Name: "deploy", Description: "ship it", Allow: Users("UADMIN")}),
+		echo(Spec{Name: "debug", Description: "dump state", Hidden: true}),
+	)
+
+	tests := []struct {
+		user string
+		text string
+		want []string
+	}{
+		{"U0002", "help", []string{
+			"*Commands*",
+			"• `echo <text...>` – repeat a message",
+			"• `help [command]` – list the commands, or explain one",
+			"• `ping` – check that the bot is up",
+			"Say `help <command>` for examples.",
+		}},
+		{"UADMIN", "help", []string{
+			"*Commands*",
+			"• `deploy` – ship it",
+			"• `echo <text...>` – repeat a message",
+			"• `help [command]` – list the commands, or explain one",
+			"• `ping` – check that the bot is up",
+			"Say `help <command>` for examples.",
+		}},
+		{"U0002", "help ping", []string{"`ping` – check that the bot is up", "*Examples*", "• `ping`"}},
+		{"U0002", "help echo", []string{"`echo <text...>` – repeat a message"}},
+		{"U0002", "help debug", []string{"`debug` – dump state"}},
+		{"U0002", "help deploy", []string{"I do not know the command \"deploy\". Say `help` for the list."}},
+		{"U0002", "help nope", []string{"I do not know the command \"nope\". Say `help` for the list."}},
+	}
+
+	for _, tt := range tests {
+		res, _ := r.Handle(context.Background(), Message{UserID: tt.user, Text: tt.text})
+
+		if lines := strings.Split(res.Reply, "\n"); !reflect.DeepEqual(lines, tt.want) {
+			t.Errorf("%s: Handle(%q) = %q, want %q", tt.user, tt.text, lines, tt.want)
+		}
+	}
+}
@@ -2,13 +2,27 @@ module github.com/fbdaf/slack-test
 
 go 1.21.4
 
-require github.com/shomali11/slacker v1.4.1
+require (
+	github.com/fbdaf/slackbot v0.0.0
+	github.com/joho/godotenv v1.5.1
+	github.com/shomali11/slacker v1.4.1
+	github.com/slack-go/slack v0.12.1
+)
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
-	github.com/joho/godotenv v1.5.1
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	github.com/prometheus/client_golang v1.19.1 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/robfig/cron v1.2.0 // indirect
 	github.com/shomali11/commander v0.0.0-20220716022157-b5248c76541a // indirect
 	github.com/shomali11/proper v0.0.0-20180607004733-233a9a872c30 // indirect
-	github.com/slack-go/slack v0.12.1 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
+
+replace github.com/fbdaf/slackbot => ../slackbot
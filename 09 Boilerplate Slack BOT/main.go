@@ -2,14 +2,88 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"os"
+	"time"
 
+	"github.com/fbdaf/slack-test/command"
+	"github.com/fbdaf/slackbot/analytics"
 	"github.com/joho/godotenv"
 	"github.com/shomali11/slacker"
 )
 
+// pingCommand answers "ping" with "pong", to check that the bot is up.
+var pingCommand = command.New(command.Spec{
+	Name:        "ping",
+	Description: "check that the bot is up",
+}, func(ctx context.Context, req command.Request) (string, error) {
+	return "pong", nil
+})
+
+// newRegistry returns the registry with the bot's commands. Commands from
+// other packages are registered here too. stats is only there when usage
+// can be read back, that is when events is not nil.
+func newRegistry(events analytics.Reader) (*command.Registry, error) {
+	registry := command.NewRegistry()
+
+	err := registry.Register(pingCommand)
+	if err != nil {
+		return nil, err
+	}
+
+	if events != nil {
+		err = registry.Register(statsCommand(events, time.Now))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+// outcomes maps the registry's outcomes to the ones analytics records.
+var outcomes = map[command.Outcome]string{
+	command.Ran:     analytics.OutcomeOK,
+	command.Invalid: analytics.OutcomeInvalid,
+	command.Denied:  analytics.OutcomeDenied,
+	command.Failed:  analytics.OutcomeError,
+}
+
+// handleMessage runs the command a message asks for, replies with what it
+// returns and records the run. Messages that are not commands get no
+// reply and are not recorded.
+func handleMessage(registry *command.Registry, recorder *analytics.Recorder) func(slacker.BotContext, slacker.Request, slacker.ResponseWriter) {
+	return func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
+		start := time.Now()
+		ev := botCtx.Event()
+
+		res, ok := registry.Handle(botCtx.Context(), command.Message{UserID: ev.UserID, ChannelID: ev.ChannelID, Text: ev.Text})
+		if !ok {
+			return
+		}
+
+		outcome := outcomes[res.Outcome]
+
+		if res.Reply != "" {
+			err := reply(botCtx, response, res.Reply)
+			if err != nil {
+				log.Printf("Error replying to %s: %v", res.Command, err)
+				outcome = analytics.OutcomeError
+			}
+		}
+
+		recorder.Record(botCtx.Context(), analytics.Event{
+			Time:    start,
+			User:    ev.UserID,
+			Channel: ev.ChannelID,
+			Command: res.Command,
+			Params:  res.Args,
+			Latency: time.Since(start),
+			Outcome: outcome,
+		})
+	}
+}
+
 func main() {
 	err := godotenv.Load()
 	if err != nil {
@@ -19,32 +93,35 @@ func main() {
 	botToken := os.Getenv("SLACK_BOT_TOKEN")
 	appToken := os.Getenv("SLACK_APP_TOKEN")
 
+	recorder, events, err := analytics.Open(analytics.ConfigFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer recorder.Close()
+
+	registry, err := newRegistry(events)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	bot := slacker.NewClient(botToken, appToken)
 
-	go printCommandEvents(bot.CommandEvents())
+	// The registry matches the commands itself, so every message goes to
+	// it. slacker's own help is replaced by the registry's.
+	bot.DefaultCommand(handleMessage(registry, recorder))
+	bot.Help(&slacker.CommandDefinition{Handler: handleMessage(registry, recorder)})
 
-	bot.Command("ping", &slacker.CommandDefinition{
-		Handler: func(botCtx slacker.BotContext, request slacker.Request, response slacker.ResponseWriter) {
-			response.Reply("pong")
-		},
-	})
-	
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err = bot.Listen(ctx)
+	err = checkAuth(ctx, bot.APIClient())
 	if err != nil {
-		log.Printf("Error listening: %v", err)
+		log.Fatal(err)
 	}
 
-}
-
-func printCommandEvents(analyticsChannel <-chan *slacker.CommandEvent) {
-	for event := range analyticsChannel {
-		fmt.Println("Command Events")
-		fmt.Println(event.Timestamp)
-		fmt.Println(event.Command)
-		fmt.Println(event.Parameters)
-		fmt.Println(event.Event)
+	err = bot.Listen(ctx)
+	if err != nil {
+		log.Printf("Error listening: %v", err)
 	}
 }
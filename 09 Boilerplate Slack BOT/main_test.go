@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+)
+
+func TestPingCommand(t *testing.T) {
+	fake := slacktest.NewServer(t)
+
+	registry, err := newRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := handleMessage(registry, analytics.NewRecorder())
+
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "ping"})
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "<@U0001> Ping"})
+	// Not a command, so the bot stays quiet.
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "did anyone ping the bot?"})
+
+	if got := fake.Messages(); !reflect.DeepEqual(got, []string{"pong", "pong"}) {
+		t.Fatalf("replies = %q", got)
+	}
+}
+
+func TestHelpListsCommands(t *testing.T) {
+	fake := slacktest.NewServer(t)
+
+	registry, err := newRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runHandler(fake, handleMessage(registry, analytics.NewRecorder()), &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "help"})
+
+	replies := fake.Messages()
+	if len(replies) != 1 || !strings.Contains(replies[0], "• `ping` – check that the bot is up") {
+		t.Fatalf("replies = %q, want the list of commands", replies)
+	}
+}
+
+func TestPingReplyFailure(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	fake.Fail("chat.postMessage", slacktest.SlackError("not_in_channel"))
+
+	registry, err := newRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The handler cannot do anything about a failed reply, but it must not
+	// retry it or post anything else.
+	runHandler(fake, handleMessage(registry, analytics.NewRecorder()), &slacker.MessageEvent{ChannelID: "C0001", Text: "ping"})
+
+	if calls := len(fake.CallsTo("chat.postMessage")); calls != 1 {
+		t.Fatalf("made %d calls, want 1", calls)
+	}
+}
+
+func TestHandleMessageRecordsOutcomes(t *testing.T) {
+	fake := slacktest.NewServer(t)
+
+	events, err := analytics.OpenJSONL(filepath.Join(t.TempDir(), "events.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer events.Close()
+
+	registry, err := newRegistry(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := handleMessage(registry, analytics.NewRecorder(events))
+
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "ping"})
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "help nope"})
+	fake.Fail("chat.postMessage", slacktest.SlackError("not_in_channel"))
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "ping"})
+
+	list, err := events.Events(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+
+	for _, e := range list {
+		got = append(got, e.Command+" "+e.Outcome)
+	}
+
+	want := []string{"ping ok", "help invalid", "ping error"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("recorded %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/fbdaf/slackbot/slash"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+)
+
+// slackAPI is the part of the Slack Web API the bot calls itself; replies
+// go through slacker. *slack.Client implements it, and tests point one at a
+// fake server with slack.OptionAPIURL.
+type slackAPI interface {
+	AuthTestContext(ctx context.Context) (*slack.AuthTestResponse, error)
+}
+
+// checkAuth makes sure the bot token works before the bot connects, so a
+// wrong token fails at startup instead of leaving a bot that never answers.
+func checkAuth(ctx context.Context, api slackAPI) error {
+	resp, err := api.AuthTestContext(ctx)
+	if err != nil {
+		return fmt.Errorf("auth test: %w", err)
+	}
+
+	log.Printf("Connected to %s as %s", resp.Team, resp.User)
+
+	return nil
+}
+
+// reply answers the message behind botCtx. A slash command is answered
+// through its response URL, so only the user who ran it sees the answer
+// and the bot does not need to be in the channel. Anything else is
+// answered in the channel.
+func reply(botCtx slacker.BotContext, response slacker.ResponseWriter, text string) error {
+	cmd, ok := slash.Command(botCtx.Event())
+	if !ok {
+		return response.Reply(text)
+	}
+
+	return slash.Respond(botCtx.Context(), cmd.ResponseURL, text)
+}
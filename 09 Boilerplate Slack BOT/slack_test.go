@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+)
+
+// runHandler does what slacker does with a message no command matched: it
+// runs handler, as set with DefaultCommand, and posts its replies to fake.
+func runHandler(fake *slacktest.Server, handler func(slacker.BotContext, slacker.Request, slacker.ResponseWriter), ev *slacker.MessageEvent) {
+	botCtx := slacker.NewBotContext(context.Background(), fake.Client(), nil, ev)
+	handler(botCtx, slacker.NewRequest(botCtx, nil), slacker.NewResponse(botCtx))
+}
+
+func TestCheckAuth(t *testing.T) {
+	fake := slacktest.NewServer(t)
+
+	if err := checkAuth(context.Background(), fake.Client()); err != nil {
+		t.Fatalf("checkAuth: %v", err)
+	}
+
+	fake.Fail("auth.test", slacktest.SlackError("invalid_auth"))
+
+	if err := checkAuth(context.Background(), fake.Client()); err == nil || !strings.Contains(err.Error(), "invalid_auth") {
+		t.Fatalf("error = %v, want invalid_auth", err)
+	}
+
+	fake.Fail("auth.test", slacktest.RateLimited(30))
+
+	var rateLimited *slack.RateLimitedError
+
+	if err := checkAuth(context.Background(), fake.Client()); !errors.As(err, &rateLimited) || rateLimited.RetryAfter.Seconds() != 30 {
+		t.Fatalf("error = %v, want a rate limit of 30s", err)
+	}
+
+	fake.Fail("auth.test", slacktest.HTTPStatus(503))
+
+	var status slack.StatusCodeError
+
+	if err := checkAuth(context.Background(), fake.Client()); !errors.As(err, &status) || status.Code != 503 {
+		t.Fatalf("error = %v, want status 503", err)
+	}
+
+	if calls := len(fake.CallsTo("auth.test")); calls != 4 {
+		t.Errorf("made %d calls, want 4", calls)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/fbdaf/slack-test/command"
+	"github.com/fbdaf/slackbot/analytics"
+)
+
+const (
+	// defaultStatsDays is the period stats covers when no days are given.
+	defaultStatsDays = 7
+	maxStatsDays     = 365
+)
+
+// statsCommand answers "stats [days]" with a summary of the commands run
+// in the last days days.
+func statsCommand(events analytics.Reader, now func() time.Time) command.Command {
+	return command.New(command.Spec{
+		Name:        "stats",
+		Args:        []command.Arg{{Name: "days", Optional: true}},
+		Description: "how the bot was used in the last 7 days, or the last days given",
+		Examples:    []string{"stats", "stats 30"},
+	}, func(ctx context.Context, req command.Request) (string, error) {
+		days, err := req.Args.Int("days", defaultStatsDays)
+		if err != nil {
+			return "", err
+		}
+
+		if days < 1 || days > maxStatsDays {
+			return "", command.Errorf("The days must be between 1 and %d.", maxStatsDays)
+		}
+
+		list, err := events.Events(ctx, now().AddDate(0, 0, -days))
+		if err != nil {
+			return "", err
+		}
+
+		return analytics.Summarize(list).Report(days), nil
+	})
+}
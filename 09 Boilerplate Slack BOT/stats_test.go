@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fbdaf/slack-test/command"
+	"github.com/fbdaf/slackbot/analytics"
+	"github.com/fbdaf/slackbot/slacktest"
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// newTestAnalytics returns a recorder that writes to a JSON-lines file in
+// a temporary directory, and the file to read the events back from.
+func newTestAnalytics(t *testing.T) (*analytics.Recorder, analytics.Reader) {
+	t.Helper()
+
+	recorder, events, err := analytics.Open(analytics.Config{JSONL: filepath.Join(t.TempDir(), "events.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { recorder.Close() })
+
+	return recorder, events
+}
+
+func TestMessagesAreRecorded(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	recorder, events := newTestAnalytics(t)
+
+	registry, err := newRegistry(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := handleMessage(registry, recorder)
+
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "ping"})
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "not a command"})
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0002", UserID: "U0003", Text: "help ping pong"})
+
+	fake.Fail("chat.postMessage", slacktest.SlackError("not_in_channel"))
+	runHandler(fake, handler, &slacker.MessageEvent{ChannelID: "C0003", UserID: "U0002", Text: "ping"})
+
+	got, err := events.Events(context.Background(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []analytics.Event{
+		{User: "U0002", Channel: "C0001", Command: "ping", Outcome: analytics.OutcomeOK},
+		{User: "U0003", Channel: "C0002", Command: "help", Outcome: analytics.OutcomeInvalid},
+		{User: "U0002", Channel: "C0003", Command: "ping", Outcome: analytics.OutcomeError},
+	}
+
+	for i := range got {
+		if got[i].Time.IsZero() || got[i].Latency <= 0 {
+			t.Errorf("event %d has time %s and latency %s", i, got[i].Time, got[i].Latency)
+		}
+
+		got[i].Time, got[i].Latency = time.Time{}, 0
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsCommand(t *testing.T) {
+	recorder, events := newTestAnalytics(t)
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, e := range []analytics.Event{
+		{Time: now.AddDate(0, 0, -20), User: "U0002", Command: "ping", Latency: time.Millisecond, Outcome: analytics.OutcomeOK},
+		{Time: now.AddDate(0, 0, -3), User: "U0002", Command: "ping", Latency: 4 * time.Millisecond, Outcome: analytics.OutcomeOK},
+		{Time: now.AddDate(0, 0, -1), User: "U0003", Command: "ping", Latency: 8 * time.Millisecond, Outcome: analytics.OutcomeOK},
+	} {
+		recorder.Record(context.Background(), e)
+	}
+
+	stats := statsCommand(events, func() time.Time { return now })
+
+	tests := []struct {
+		args    map[string]string
+		want    string
+		wantErr string
+	}{
+		{nil, "*Usage in the last 7 days*\n2 commands from 2 users: 2 ok.\n• `ping`: 2 runs, median 4 ms, 95th percentile 8 ms", ""},
+		{map[string]string{"days": "30"}, "*Usage in the last 30 days*\n3 commands from 2 users: 3 ok.\n• `ping`: 3 runs, median 4 ms, 95th percentile 8 ms", ""},
+		{map[string]string{"days": "2"}, "*Usage in the last 2 days*\n1 command from 1 user: 1 ok.\n• `ping`: 1 run, median 8 ms, 95th percentile 8 ms", ""},
+		{map[string]string{"days": "0"}, "", "The days must be between 1 and 365."},
+		{map[string]string{"days": "week"}, "", `The days must be a whole number, not "week".`},
+	}
+
+	for _, tt := range tests {
+		got, err := stats.Run(context.Background(), command.Request{Args: tt.args})
+
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("stats %v: error = %v, want %q", tt.args, err, tt.wantErr)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("stats %v = %q, %v, want %q", tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestStatsNeedsAReader(t *testing.T) {
+	for _, events := range []analytics.Reader{nil, &analytics.JSONL{}} {
+		registry, err := newRegistry(events)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listed := false
+
+		for _, spec := range registry.Specs() {
+			listed = listed || spec.Name == "stats"
+		}
+
+		if listed != (events != nil) {
+			t.Errorf("reader %T: stats registered = %v", events, listed)
+		}
+	}
+}
+
+func TestSlashCommandReplyIsEphemeral(t *testing.T) {
+	fake := slacktest.NewServer(t)
+	recorder, events := newTestAnalytics(t)
+
+	registry, err := newRegistry(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"command":      "/ping",
+		"user_id":      "U0002",
+		"channel_id":   "C0001",
+		"response_url": fake.ResponseURL(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &socketmode.Request{Type: socketmode.RequestTypeSlashCommands, Payload: payload}
+
+	runHandler(fake, handleMessage(registry, recorder), &slacker.MessageEvent{ChannelID: "C0001", UserID: "U0002", Text: "ping ", Data: req, Type: req.Type})
+
+	if got := fake.EphemeralResponses(); !reflect.DeepEqual(got, []string{"pong"}) {
+		t.Errorf("ephemeral replies = %q, want pong", got)
+	}
+
+	if got := fake.Messages(); len(got) != 0 {
+		t.Errorf("replies in the channel = %q, want none", strings.Join(got, ", "))
+	}
+}
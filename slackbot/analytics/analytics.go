@@ -0,0 +1,143 @@
+// Package analytics records the commands a bot runs. Each run is an Event,
+// which a Recorder hands to its sinks: a JSON-lines file, an SQLite
+// database, Prometheus metrics or the log. The file and the database can
+// be read back to summarise usage. Open sets up the sinks a bot is
+// configured with.
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// The outcomes of a command.
+const (
+	// OutcomeOK is a command that ran and replied.
+	OutcomeOK = "ok"
+	// OutcomeInvalid is a command that was used wrongly, for example with
+	// an argument missing.
+	OutcomeInvalid = "invalid"
+	// OutcomeDenied is a command the user was not allowed to run.
+	OutcomeDenied = "denied"
+	// OutcomeError is a command that failed, or whose reply could not be
+	// posted.
+	OutcomeError = "error"
+)
+
+// Event is one run of a command.
+type Event struct {
+	Time    time.Time
+	User    string
+	Channel string
+	Command string
+	Params  map[string]string
+	Latency time.Duration
+	Outcome string
+}
+
+// eventJSON is how an Event is written to JSON, with the latency in
+// milliseconds.
+type eventJSON struct {
+	Time      time.Time         `json:"time"`
+	User      string            `json:"user"`
+	Channel   string            `json:"channel"`
+	Command   string            `json:"command"`
+	Params    map[string]string `json:"params,omitempty"`
+	LatencyMS float64           `json:"latencyMs"`
+	Outcome   string            `json:"outcome"`
+}
+
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eventJSON{
+		Time:      e.Time,
+		User:      e.User,
+		Channel:   e.Channel,
+		Command:   e.Command,
+		Params:    e.Params,
+		LatencyMS: float64(e.Latency) / float64(time.Millisecond),
+		Outcome:   e.Outcome,
+	})
+}
+
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var j eventJSON
+
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+
+	*e = Event{
+		Time:    j.Time,
+		User:    j.User,
+		Channel: j.Channel,
+		Command: j.Command,
+		Params:  j.Params,
+		Latency: time.Duration(j.LatencyMS * float64(time.Millisecond)),
+		Outcome: j.Outcome,
+	}
+
+	return nil
+}
+
+// Sink is somewhere events are recorded.
+type Sink interface {
+	Record(ctx context.Context, e Event) error
+}
+
+// Reader is a sink that events can be read back from.
+type Reader interface {
+	// Events returns the events at or after since, oldest first.
+	Events(ctx context.Context, since time.Time) ([]Event, error)
+}
+
+// Recorder records events in all of its sinks.
+type Recorder struct {
+	sinks []Sink
+	// closers are what Open opened for the sinks.
+	closers []io.Closer
+	// metricsAddr is where Open serves the metrics, if it does.
+	metricsAddr net.Addr
+}
+
+// NewRecorder returns a recorder for sinks.
+func NewRecorder(sinks ...Sink) *Recorder {
+	return &Recorder{sinks: sinks}
+}
+
+// Close closes the files, databases and listeners Open opened for r. The
+// sinks of a recorder made with NewRecorder are left to the caller.
+func (r *Recorder) Close() error {
+	var errs []error
+
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// Record records e in every sink. A sink that fails is logged and does not
+// keep e from the others; analytics never get in the way of a reply.
+func (r *Recorder) Record(ctx context.Context, e Event) {
+	for _, s := range r.sinks {
+		err := s.Record(ctx, e)
+		if err != nil {
+			log.Printf("Error recording %s in %T: %v", e.Command, s, err)
+		}
+	}
+}
+
+// Log is a sink that writes each event to the log on one line.
+type Log struct{}
+
+func (Log) Record(ctx context.Context, e Event) error {
+	log.Printf("Command %q by %s in %s: %s in %s, params %v", e.Command, e.User, e.Channel, e.Outcome, e.Latency.Round(time.Millisecond), e.Params)
+
+	return nil
+}
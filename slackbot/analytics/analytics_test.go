@@ -0,0 +1,220 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func event(at time.Time, user, command, outcome string, latency time.Duration) Event {
+	return Event{Time: at, User: user, Channel: "C0001", Command: command, Latency: latency, Outcome: outcome}
+}
+
+func TestEventJSON(t *testing.T) {
+	e := Event{
+		Time:    time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		User:    "U0002",
+		Channel: "C0001",
+		Command: "stats",
+		Params:  map[string]string{"days": "30"},
+		Latency: 1500 * time.Microsecond,
+		Outcome: OutcomeOK,
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"time":"2025-03-01T12:00:00Z","user":"U0002","channel":"C0001","command":"stats","params":{"days":"30"},"latencyMs":1.5,"outcome":"ok"}`
+	if string(data) != want {
+		t.Errorf("json = %s, want %s", data, want)
+	}
+
+	var got Event
+
+	err = json.Unmarshal(data, &got)
+	if err != nil || !reflect.DeepEqual(got, e) {
+		t.Errorf("round trip = %+v, %v, want %+v", got, err, e)
+	}
+}
+
+type failingSink struct{}
+
+func (failingSink) Record(ctx context.Context, e Event) error {
+	return errors.New("disk full")
+}
+
+type memorySink struct {
+	events []Event
+}
+
+func (s *memorySink) Record(ctx context.Context, e Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestRecorderKeepsGoingAfterAFailure(t *testing.T) {
+	mem := &memorySink{}
+	e := event(time.Now(), "U0002", "ping", OutcomeOK, time.Millisecond)
+
+	NewRecorder(failingSink{}, mem).Record(context.Background(), e)
+
+	if !reflect.DeepEqual(mem.events, []Event{e}) {
+		t.Fatalf("events = %+v, want %+v", mem.events, e)
+	}
+}
+
+// readerSink is a sink that events can be read back from.
+type readerSink interface {
+	Sink
+	Reader
+}
+
+func TestReaders(t *testing.T) {
+	open := map[string]func(t *testing.T) readerSink{
+		"jsonl": func(t *testing.T) readerSink {
+			s, err := OpenJSONL(filepath.Join(t.TempDir(), "events.jsonl"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Cleanup(func() { s.Close() })
+
+			return s
+		},
+		"sqlite": func(t *testing.T) readerSink {
+			s, err := OpenSQLite(filepath.Join(t.TempDir(), "events.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Cleanup(func() { s.Close() })
+
+			return s
+		},
+	}
+
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	events := []Event{
+		event(day.AddDate(0, 0, -10), "U0002", "ping", OutcomeOK, 2*time.Millisecond),
+		event(day.AddDate(0, 0, -1), "U0003", "help", OutcomeOK, 5*time.Millisecond),
+		{Time: day, User: "U0002", Channel: "C0002", Command: "stats", Params: map[string]string{"days": "x"}, Latency: 1500 * time.Microsecond, Outcome: OutcomeInvalid},
+	}
+
+	for name, open := range open {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+
+			for _, e := range events {
+				err := s.Record(context.Background(), e)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := s.Events(context.Background(), day.AddDate(0, 0, -1))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, events[1:]) {
+				t.Errorf("Events = %+v, want %+v", got, events[1:])
+			}
+		})
+	}
+}
+
+func TestJSONLSkipsBrokenLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+
+	s, err := OpenJSONL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.Close()
+
+	e := event(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), "U0002", "ping", OutcomeOK, time.Millisecond)
+
+	s.Record(context.Background(), e)
+	s.f.WriteString(`{"time":"2025-03-01T00:00:01Z","us` + "\n")
+	s.Record(context.Background(), e)
+
+	got, err := s.Events(context.Background(), time.Time{})
+	if err != nil || len(got) != 2 {
+		t.Fatalf("Events = %+v, %v, want 2 events", got, err)
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	p, err := NewPrometheus(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	for _, e := range []Event{
+		event(now, "U0002", "ping", OutcomeOK, 10*time.Millisecond),
+		event(now, "U0003", "ping", OutcomeOK, 20*time.Millisecond),
+		event(now, "U0002", "ping", OutcomeError, 3*time.Second),
+	} {
+		p.Record(context.Background(), e)
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			var labels []string
+
+			for _, l := range m.GetLabel() {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+
+			key := f.GetName() + "{" + strings.Join(labels, ",") + "}"
+
+			if c := m.GetCounter(); c != nil {
+				got[key] = c.GetValue()
+			}
+
+			if h := m.GetHistogram(); h != nil {
+				got[key+" count"] = float64(h.GetSampleCount())
+				got[key+" sum"] = h.GetSampleSum()
+			}
+		}
+	}
+
+	want := map[string]float64{
+		"slackbot_commands_total{command=ping,outcome=ok}":      2,
+		"slackbot_commands_total{command=ping,outcome=error}":   1,
+		"slackbot_command_duration_seconds{command=ping} count": 3,
+		"slackbot_command_duration_seconds{command=ping} sum":   3.03,
+	}
+
+	for k, v := range want {
+		if diff := got[k] - v; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+
+	if _, err := NewPrometheus(reg); err == nil {
+		t.Error("registered the metrics twice")
+	}
+}
@@ -0,0 +1,88 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// JSONL is a sink that appends events to a file, one JSON object per line.
+type JSONL struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// OpenJSONL opens the file at path for appending, creating it if needed.
+func OpenJSONL(path string) (*JSONL, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JSONL{path: path, f: f}, nil
+}
+
+// Record appends e to the file. Each event is written with a single write,
+// so a crash loses at most the line being written.
+func (s *JSONL) Record(ctx context.Context, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err = s.f.Write(append(line, '\n'))
+
+	return err
+}
+
+// Events reads the file and returns the events at or after since. Lines
+// that are not events, such as one cut short by a crash, are skipped.
+func (s *JSONL) Events(ctx context.Context, since time.Time) ([]Event, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	var events []Event
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var e Event
+
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+
+		if !e.Time.Before(since) {
+			events = append(events, e)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.path, err)
+	}
+
+	return events, nil
+}
+
+// Close closes the file.
+func (s *JSONL) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
@@ -0,0 +1,106 @@
+package analytics
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config says where a bot's events go besides the log. Each sink is off
+// when its field is empty.
+type Config struct {
+	// JSONL is the path of a JSON-lines file.
+	JSONL string
+	// SQLite is the path of an SQLite database.
+	SQLite string
+	// MetricsAddr is the address Prometheus metrics are served on, at
+	// /metrics.
+	MetricsAddr string
+}
+
+// ConfigFromEnv reads ANALYTICS_JSONL, ANALYTICS_SQLITE and METRICS_ADDR.
+func ConfigFromEnv() Config {
+	return Config{
+		JSONL:       os.Getenv("ANALYTICS_JSONL"),
+		SQLite:      os.Getenv("ANALYTICS_SQLITE"),
+		MetricsAddr: os.Getenv("METRICS_ADDR"),
+	}
+}
+
+// Open returns the recorder for the sinks in cfg, and the log. events is
+// where usage is read back from: the database if there is one, otherwise
+// the file, and nil if there is neither. If a sink cannot be opened, the
+// ones opened before it are closed again. Close the recorder when the bot
+// stops.
+func Open(cfg Config) (recorder *Recorder, events Reader, err error) {
+	r := NewRecorder(Log{})
+
+	defer func() {
+		if err != nil {
+			r.Close()
+		}
+	}()
+
+	if cfg.JSONL != "" {
+		jsonl, err := OpenJSONL(cfg.JSONL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r.sinks = append(r.sinks, jsonl)
+		r.closers = append(r.closers, jsonl)
+		events = jsonl
+	}
+
+	if cfg.SQLite != "" {
+		db, err := OpenSQLite(cfg.SQLite)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		r.sinks = append(r.sinks, db)
+		r.closers = append(r.closers, db)
+		events = db
+	}
+
+	if cfg.MetricsAddr != "" {
+		reg := prometheus.NewRegistry()
+
+		metrics, err := NewPrometheus(reg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Listening here, and not in the goroutine, makes an address that
+		// is taken fail at startup.
+		ln, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+
+		srv := &http.Server{Handler: mux}
+
+		go func() {
+			err := srv.Serve(ln)
+			if !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Error serving metrics: %v", err)
+			}
+		}()
+
+		log.Printf("Serving metrics on http://%s/metrics", ln.Addr())
+
+		r.sinks = append(r.sinks, metrics)
+		r.closers = append(r.closers, srv)
+		r.metricsAddr = ln.Addr()
+	}
+
+	return r, events, nil
+}
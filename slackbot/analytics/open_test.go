@@ -0,0 +1,109 @@
+package analytics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenPrefersSQLite(t *testing.T) {
+	dir := t.TempDir()
+
+	r, events, err := Open(Config{JSONL: filepath.Join(dir, "events.jsonl"), SQLite: filepath.Join(dir, "events.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	if _, ok := events.(*SQLite); !ok {
+		t.Fatalf("events read from %T, want *SQLite", events)
+	}
+
+	r, events, err = Open(Config{})
+	if err != nil || events != nil {
+		t.Fatalf("Open with nothing set = %v, %v, want no reader", events, err)
+	}
+
+	r.Close()
+}
+
+func TestOpenServesMetrics(t *testing.T) {
+	r, _, err := Open(Config{MetricsAddr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Record(context.Background(), event(time.Now(), "U0002", "ping", OutcomeOK, time.Millisecond))
+
+	url := "http://" + r.metricsAddr.String() + "/metrics"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if want := `slackbot_commands_total{command="ping",outcome="ok"} 1`; !strings.Contains(string(body), want) {
+		t.Errorf("metrics do not contain %s:\n%s", want, body)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := http.Get(url); err == nil {
+		t.Error("metrics still served after Close")
+	}
+}
+
+// openFiles returns the number of files the process has open.
+func openFiles(t *testing.T) int {
+	t.Helper()
+
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count open files: %v", err)
+	}
+
+	return len(fds)
+}
+
+func TestOpenClosesSinksOnFailure(t *testing.T) {
+	dir := t.TempDir()
+
+	// Something that is not a listener holds the address, so Listen fails.
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer taken.Close()
+
+	tests := map[string]Config{
+		"database": {JSONL: filepath.Join(dir, "events.jsonl"), SQLite: filepath.Join(dir, "missing", "events.db")},
+		"metrics":  {JSONL: filepath.Join(dir, "events.jsonl"), SQLite: filepath.Join(dir, "events.db"), MetricsAddr: taken.Addr().String()},
+	}
+
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			before := openFiles(t)
+
+			if _, _, err := Open(cfg); err == nil {
+				t.Fatal("Open succeeded")
+			}
+
+			if after := openFiles(t); after != before {
+				t.Errorf("%d files open after a failed Open, want %d", after, before)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package analytics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus is a sink that counts commands by outcome and keeps a
+// histogram of how long they take. Serve the registry it was created with,
+// for example with promhttp.HandlerFor, for Prometheus to scrape.
+type Prometheus struct {
+	commands *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+}
+
+// NewPrometheus registers the metrics with reg:
+//
+//	slackbot_commands_total{command, outcome}
+//	slackbot_command_duration_seconds{command}
+func NewPrometheus(reg prometheus.Registerer) (*Prometheus, error) {
+	p := &Prometheus{
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "slackbot_commands_total",
+			Help: "Commands run by the bot, by command and outcome.",
+		}, []string{"command", "outcome"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "slackbot_command_duration_seconds",
+			Help:    "Time taken to run a command and post its reply.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"command"}),
+	}
+
+	for _, c := range []prometheus.Collector{p.commands, p.latency} {
+		err := reg.Register(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Record counts e. The user, channel and params are left out, so the
+// number of series stays small.
+func (p *Prometheus) Record(ctx context.Context, e Event) error {
+	p.commands.WithLabelValues(e.Command, e.Outcome).Inc()
+	p.latency.WithLabelValues(e.Command).Observe(e.Latency.Seconds())
+
+	return nil
+}
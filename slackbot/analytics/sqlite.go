@@ -0,0 +1,108 @@
+package analytics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	// The SQLite driver, registered as "sqlite3". It needs cgo.
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS command_events (
+	time_ms    INTEGER NOT NULL,
+	user       TEXT NOT NULL,
+	channel    TEXT NOT NULL,
+	command    TEXT NOT NULL,
+	params     TEXT NOT NULL,
+	latency_ms REAL NOT NULL,
+	outcome    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS command_events_time ON command_events (time_ms);
+`
+
+// SQLite is a sink that stores events in the command_events table of an
+// SQLite database. Times are Unix milliseconds and params are a JSON
+// object, so the table is easy to query by hand:
+//
+//	SELECT command, count(*) FROM command_events GROUP BY command;
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens the database at path, creating it and the table if
+// needed.
+func OpenSQLite(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLite{db: db}, nil
+}
+
+// Record inserts e.
+func (s *SQLite) Record(ctx context.Context, e Event) error {
+	params, err := json.Marshal(e.Params)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO command_events (time_ms, user, channel, command, params, latency_ms, outcome) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.UnixMilli(), e.User, e.Channel, e.Command, string(params), float64(e.Latency)/float64(time.Millisecond), e.Outcome)
+
+	return err
+}
+
+// Events returns the events at or after since.
+func (s *SQLite) Events(ctx context.Context, since time.Time) ([]Event, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT time_ms, user, channel, command, params, latency_ms, outcome FROM command_events WHERE time_ms >= ? ORDER BY time_ms`,
+		since.UnixMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var events []Event
+
+	for rows.Next() {
+		var (
+			e         Event
+			timeMS    int64
+			params    string
+			latencyMS float64
+		)
+
+		err = rows.Scan(&timeMS, &e.User, &e.Channel, &e.Command, &params, &latencyMS, &e.Outcome)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal([]byte(params), &e.Params)
+		if err != nil {
+			return nil, err
+		}
+
+		e.Time = time.UnixMilli(timeMS).UTC()
+		e.Latency = time.Duration(latencyMS * float64(time.Millisecond))
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// Close closes the database.
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
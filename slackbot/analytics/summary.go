@@ -0,0 +1,161 @@
+package analytics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Summary is the usage of a bot over a period.
+type Summary struct {
+	Total int
+	// Users is the number of different users.
+	Users int
+	// Outcomes counts the events by outcome.
+	Outcomes map[string]int
+	// Commands are ordered from the most used.
+	Commands []CommandSummary
+}
+
+// CommandSummary is the usage of one command.
+type CommandSummary struct {
+	Command string
+	Count   int
+	Errors  int
+	Median  time.Duration
+	P95     time.Duration
+}
+
+// Summarize adds up events.
+func Summarize(events []Event) Summary {
+	s := Summary{Total: len(events), Outcomes: map[string]int{}}
+
+	users := map[string]bool{}
+	latencies := map[string][]time.Duration{}
+	errors := map[string]int{}
+
+	for _, e := range events {
+		users[e.User] = true
+		s.Outcomes[e.Outcome]++
+		latencies[e.Command] = append(latencies[e.Command], e.Latency)
+
+		if e.Outcome == OutcomeError {
+			errors[e.Command]++
+		}
+	}
+
+	s.Users = len(users)
+
+	for command, l := range latencies {
+		sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+
+		s.Commands = append(s.Commands, CommandSummary{
+			Command: command,
+			Count:   len(l),
+			Errors:  errors[command],
+			Median:  percentile(l, 0.5),
+			P95:     percentile(l, 0.95),
+		})
+	}
+
+	sort.Slice(s.Commands, func(i, j int) bool {
+		if s.Commands[i].Count != s.Commands[j].Count {
+			return s.Commands[i].Count > s.Commands[j].Count
+		}
+
+		return s.Commands[i].Command < s.Commands[j].Command
+	})
+
+	return s
+}
+
+// percentile returns the p-th percentile of sorted by the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	return sorted[int(math.Ceil(p*float64(len(sorted))))-1]
+}
+
+// outcomeOrder is the order outcomes are reported in. Others come after,
+// by name.
+var outcomeOrder = []string{OutcomeOK, OutcomeInvalid, OutcomeDenied, OutcomeError}
+
+// Report is s as a Slack message about the last days days.
+func (s Summary) Report(days int) string {
+	period := fmt.Sprintf("the last %d days", days)
+
+	if days == 1 {
+		period = "the last day"
+	}
+
+	if s.Total == 0 {
+		return fmt.Sprintf("No commands in %s.", period)
+	}
+
+	var outcomes []string
+
+	for _, o := range outcomeOrder {
+		if n := s.Outcomes[o]; n > 0 {
+			outcomes = append(outcomes, fmt.Sprintf("%d %s", n, o))
+		}
+	}
+
+	var others []string
+
+	for o, n := range s.Outcomes {
+		if !contains(outcomeOrder, o) {
+			others = append(others, fmt.Sprintf("%d %s", n, o))
+		}
+	}
+
+	sort.Strings(others)
+	outcomes = append(outcomes, others...)
+
+	lines := []string{
+		fmt.Sprintf("*Usage in %s*", period),
+		fmt.Sprintf("%s from %s: %s.", plural(s.Total, "command"), plural(s.Users, "user"), strings.Join(outcomes, ", ")),
+	}
+
+	for _, c := range s.Commands {
+		line := fmt.Sprintf("• `%s`: %s, median %s, 95th percentile %s", c.Command, plural(c.Count, "run"), formatLatency(c.Median), formatLatency(c.P95))
+
+		if c.Errors > 0 {
+			line += fmt.Sprintf(", %d failed", c.Errors)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func formatLatency(d time.Duration) string {
+	if d < time.Second {
+		return fmt.Sprintf("%d ms", d.Milliseconds())
+	}
+
+	return fmt.Sprintf("%.1f s", d.Seconds())
+}
@@ -0,0 +1,54 @@
+package analytics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummaryReport(t *testing.T) {
+	now := time.Now()
+
+	var events []Event
+
+	for i := 1; i <= 20; i++ {
+		events = append(events, event(now, "U0002", "ping", OutcomeOK, time.Duration(i)*time.Millisecond))
+	}
+
+	events = append(events,
+		event(now, "U0003", "stats [days]", OutcomeOK, 1200*time.Millisecond),
+		event(now, "U0003", "stats [days]", OutcomeInvalid, 2*time.Millisecond),
+		event(now, "U0004", "stats [days]", OutcomeError, 3*time.Second),
+		event(now, "U0004", "deploy", OutcomeDenied, time.Millisecond),
+		event(now, "U0004", "deploy", "timeout", time.Millisecond),
+	)
+
+	want := []string{
+		"*Usage in the last 7 days*",
+		"25 commands from 3 users: 21 ok, 1 invalid, 1 denied, 1 error, 1 timeout.",
+		"• `ping`: 20 runs, median 10 ms, 95th percentile 19 ms",
+		"• `stats [days]`: 3 runs, median 1.2 s, 95th percentile 3.0 s, 1 failed",
+		"• `deploy`: 2 runs, median 1 ms, 95th percentile 1 ms",
+	}
+
+	if got := Summarize(events).Report(7); got != strings.Join(want, "\n") {
+		t.Errorf("Report =\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestSummaryReportEmpty(t *testing.T) {
+	for days, want := range map[int]string{
+		1:  "No commands in the last day.",
+		30: "No commands in the last 30 days.",
+	} {
+		if got := Summarize(nil).Report(days); got != want {
+			t.Errorf("Report(%d) = %q, want %q", days, got, want)
+		}
+	}
+
+	want := "*Usage in the last day*\n1 command from 1 user: 1 ok.\n• `ping`: 1 run, median 5 ms, 95th percentile 5 ms"
+
+	if got := Summarize([]Event{event(time.Now(), "U0002", "ping", OutcomeOK, 5*time.Millisecond)}).Report(1); got != want {
+		t.Errorf("Report =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,353 @@
+// Package slacktest is an offline Slack Web API for tests. A real
+// *slack.Client is pointed at it with slack.OptionAPIURL, so the code under
+// test makes the same requests it makes to Slack.
+//
+// The server answers the methods the bots and the file uploader use,
+// records every call, and fails the calls it is told to with a rate limit,
+// an HTTP status or a Slack error. Handle replaces the answer to a method or
+// adds one.
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// PageSize is how many channels a conversations.list page holds, small
+// enough that tests go through the pagination.
+const PageSize = 2
+
+// Call is one request to the server. Calls made with a JSON body, such as
+// views.open or a slash command response, have it in Body instead of Form.
+type Call struct {
+	Method string
+	Form   url.Values
+	Body   map[string]interface{}
+	// Content is the file sent to an upload URL.
+	Content string
+}
+
+// Failure is how a call fails: with a rate limit when RetryAfter is set,
+// with an HTTP status when Status is set, and otherwise with a Slack error
+// such as not_in_channel.
+type Failure struct {
+	RetryAfter string
+	Status     int
+	Err        string
+}
+
+// RateLimited fails a call with HTTP 429 and a Retry-After of seconds.
+func RateLimited(seconds int) Failure {
+	return Failure{RetryAfter: strconv.Itoa(seconds)}
+}
+
+// HTTPStatus fails a call with the HTTP status code.
+func HTTPStatus(code int) Failure {
+	return Failure{Status: code}
+}
+
+// SlackError fails a call with {"ok": false, "error": code}.
+func SlackError(code string) Failure {
+	return Failure{Err: code}
+}
+
+// Channel is a channel conversations.list returns.
+type Channel struct {
+	ID   string
+	Name string
+}
+
+// User is a user as users.info returns it.
+type User struct {
+	TZ       string
+	TZOffset int
+}
+
+// A Handler answers a call to a method. What it returns is sent as the JSON
+// response, and nil sends {"ok": true}. Handlers run without the server's
+// lock held, so they may call its methods.
+type Handler func(c Call) interface{}
+
+// Server is a fake Slack Web API. The file upload itself, made to the URL
+// files.getUploadURLExternal returns, is recorded as method "upload", and
+// the calls to ResponseURL as method "response".
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	calls    []Call
+	failures map[string][]Failure
+	handlers map[string]Handler
+	channels []Channel
+	users    map[string]User
+	nextFile int
+	nextTS   int
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	s := &Server{failures: map[string][]Failure{}, handlers: map[string]Handler{}, users: map[string]User{}}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.srv.Close)
+
+	s.Handle("auth.test", s.authTest)
+	s.Handle("chat.postMessage", s.postMessage)
+	s.Handle("conversations.list", s.conversationsList)
+	s.Handle("users.info", s.usersInfo)
+	s.Handle("views.open", s.viewsOpen)
+	s.Handle("response", func(Call) interface{} { return nil })
+	s.Handle("files.getUploadURLExternal", s.getUploadURL)
+	s.Handle("upload", func(Call) interface{} { return nil })
+	s.Handle("files.completeUploadExternal", s.completeUpload)
+	s.Handle("files.delete", func(Call) interface{} { return nil })
+
+	return s
+}
+
+// URL is the base URL of the server.
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Client returns a Slack client that talks to the server.
+func (s *Server) Client() *slack.Client {
+	return slack.New("xoxb-test", slack.OptionAPIURL(s.srv.URL+"/"))
+}
+
+// ResponseURL is a slash command response URL that answers on the server.
+func (s *Server) ResponseURL() string {
+	return s.srv.URL + "/response"
+}
+
+// Handle makes h answer the calls to method, in place of the server's own
+// answer if it has one.
+func (s *Server) Handle(method string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[method] = h
+}
+
+// Fail makes the next calls to method fail, one failure per call.
+func (s *Server) Fail(method string, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[method] = append(s.failures[method], failures...)
+}
+
+// AddChannels makes conversations.list return channels, after the ones it
+// already returns.
+func (s *Server) AddChannels(channels ...Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.channels = append(s.channels, channels...)
+}
+
+// AddUser makes users.info know the user id.
+func (s *Server) AddUser(id string, u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[id] = u
+}
+
+// Calls returns every call made so far.
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Call(nil), s.calls...)
+}
+
+// CallsTo returns the calls made to method so far.
+func (s *Server) CallsTo(method string) []Call {
+	var calls []Call
+
+	for _, c := range s.Calls() {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+
+	return calls
+}
+
+// Messages returns the text of the messages posted so far.
+func (s *Server) Messages() []string {
+	var texts []string
+
+	for _, c := range s.CallsTo("chat.postMessage") {
+		texts = append(texts, c.Form.Get("text"))
+	}
+
+	return texts
+}
+
+// EphemeralResponses returns the text of the ephemeral responses posted to
+// ResponseURL so far.
+func (s *Server) EphemeralResponses() []string {
+	var texts []string
+
+	for _, c := range s.CallsTo("response") {
+		if c.Body["response_type"] == "ephemeral" {
+			texts = append(texts, c.Body["text"].(string))
+		}
+	}
+
+	return texts
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	call := Call{Method: strings.TrimPrefix(r.URL.Path, "/")}
+
+	if strings.HasPrefix(call.Method, "upload/") {
+		call.Method = "upload"
+
+		file, _, err := r.FormFile("file")
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		content, _ := io.ReadAll(file)
+		call.Content = string(content)
+	} else if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&call.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	call.Form = r.Form
+
+	s.mu.Lock()
+	s.calls = append(s.calls, call)
+
+	if queued := s.failures[call.Method]; len(queued) > 0 {
+		s.failures[call.Method] = queued[1:]
+		s.mu.Unlock()
+
+		switch failure := queued[0]; {
+		case failure.RetryAfter != "":
+			w.Header().Set("Retry-After", failure.RetryAfter)
+			w.WriteHeader(http.StatusTooManyRequests)
+		case failure.Status != 0:
+			w.WriteHeader(failure.Status)
+		default:
+			writeJSON(w, map[string]interface{}{"ok": false, "error": failure.Err})
+		}
+
+		return
+	}
+
+	h := s.handlers[call.Method]
+	s.mu.Unlock()
+
+	if h == nil {
+		writeJSON(w, map[string]interface{}{"ok": false, "error": "unknown_method"})
+		return
+	}
+
+	resp := h(call)
+
+	if resp == nil {
+		resp = map[string]interface{}{"ok": true}
+	}
+
+	writeJSON(w, resp)
+}
+
+func (s *Server) authTest(Call) interface{} {
+	return map[string]interface{}{"ok": true, "team": "Test", "team_id": "T0001", "user": "testbot", "user_id": "U0001"}
+}
+
+func (s *Server) postMessage(c Call) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextTS++
+
+	return map[string]interface{}{"ok": true, "channel": c.Form.Get("channel"), "ts": fmt.Sprintf("1700000000.%06d", s.nextTS)}
+}
+
+func (s *Server) conversationsList(c Call) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start, _ := strconv.Atoi(c.Form.Get("cursor"))
+	end := start + PageSize
+	next := strconv.Itoa(end)
+
+	if end >= len(s.channels) {
+		end, next = len(s.channels), ""
+	}
+
+	page := []map[string]string{}
+
+	for _, ch := range s.channels[start:end] {
+		page = append(page, map[string]string{"id": ch.ID, "name": ch.Name})
+	}
+
+	return map[string]interface{}{"ok": true, "channels": page, "response_metadata": map[string]string{"next_cursor": next}}
+}
+
+func (s *Server) usersInfo(c Call) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := c.Form.Get("user")
+	u, ok := s.users[id]
+
+	if !ok {
+		return map[string]interface{}{"ok": false, "error": "user_not_found"}
+	}
+
+	return map[string]interface{}{"ok": true, "user": map[string]interface{}{"id": id, "tz": u.TZ, "tz_offset": u.TZOffset}}
+}
+
+func (s *Server) viewsOpen(Call) interface{} {
+	return map[string]interface{}{"ok": true, "view": map[string]interface{}{"id": "V0001"}}
+}
+
+func (s *Server) getUploadURL(Call) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextFile++
+	id := fmt.Sprintf("F%04d", s.nextFile)
+
+	return map[string]interface{}{"ok": true, "file_id": id, "upload_url": s.srv.URL + "/upload/" + id}
+}
+
+func (s *Server) completeUpload(c Call) interface{} {
+	var files []map[string]string
+
+	if err := json.Unmarshal([]byte(c.Form.Get("files")), &files); err != nil {
+		return map[string]interface{}{"ok": false, "error": "invalid_arguments"}
+	}
+
+	return map[string]interface{}{"ok": true, "files": files}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
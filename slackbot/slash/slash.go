@@ -0,0 +1,40 @@
+// Package slash reads the slash commands slacker hands to a bot and
+// answers them.
+package slash
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/shomali11/slacker"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// Command returns the slash command behind ev, if there is one. slacker
+// hands slash commands to the bot as messages: "/stats 30" comes in as
+// "stats 30".
+func Command(ev *slacker.MessageEvent) (slack.SlashCommand, bool) {
+	var cmd slack.SlashCommand
+
+	req, ok := ev.Data.(*socketmode.Request)
+	if !ok || req.Type != socketmode.RequestTypeSlashCommands {
+		return cmd, false
+	}
+
+	err := json.Unmarshal(req.Payload, &cmd)
+	if err != nil {
+		log.Printf("Error reading the slash command: %v", err)
+		return cmd, false
+	}
+
+	return cmd, true
+}
+
+// Respond posts text through a slash command's response URL, so only the
+// user who ran the command sees it and the bot does not need to be in the
+// channel.
+func Respond(ctx context.Context, responseURL, text string) error {
+	return slack.PostWebhookContext(ctx, responseURL, &slack.WebhookMessage{Text: text, ResponseType: slack.ResponseTypeEphemeral})
+}